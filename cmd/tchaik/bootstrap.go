@@ -11,26 +11,50 @@ import (
 	"tchaik.com/index/attr"
 )
 
-// newBootstrapSearch creates a new index.Searcher which builds the search index
-// on the first call to Search.
-func newBootstrapSearcher(root index.Collection) index.Searcher {
+// newBootstrapSearch creates a new index.Searcher which builds (or loads) the search
+// index on the first call to Search.
+func newBootstrapSearcher(root index.Collection, opts LibraryOptions) index.Searcher {
 	return &bootstrapSearcher{
 		root: root,
+		opts: opts,
 	}
 }
 
 type bootstrapSearcher struct {
 	once sync.Once
 	root index.Collection
+	opts LibraryOptions
+
+	mu     sync.Mutex // protects si and closed
+	si     *index.SearchIndex
+	closed bool
 
 	index.Searcher
 }
 
 func (b *bootstrapSearcher) bootstrap() {
-	wi := index.BuildCollectionWordIndex(b.root, []string{"Composer", "Artist", "Album", "Name"})
-	b.Searcher = index.FlatSearcher{
-		Searcher: index.WordsIntersectSearcher(index.BuildPrefixExpandSearcher(wi, wi, 10)),
+	si := loadSearchIndex(b.opts.SearchIndex, b.opts.SearchIndexSource, b.root)
+	b.mu.Lock()
+	if b.closed {
+		si.Close()
+	}
+	b.si = si
+	b.mu.Unlock()
+
+	var expander index.Expander = si.Prefix
+	if b.opts.FuzzySearch {
+		expander = index.MultiExpand{
+			index.FuzzyPrefixExpand{PrefixMultiExpand: si.Prefix},
+			index.BuildFuzzyExpander(si.Rank.Words(), 2),
+		}
 	}
+	b.Searcher = index.NewQuerySearcher(b.root, index.SearchFields, index.NewRankSearcher(si.Rank, expander))
+}
+
+// SearchScored implements index.ScoredSearcher.
+func (b *bootstrapSearcher) SearchScored(input string) []index.ScoredPath {
+	b.once.Do(b.bootstrap)
+	return index.SearchScored(b.Searcher, input)
 }
 
 // Search implements index.Searcher.
@@ -39,6 +63,18 @@ func (b *bootstrapSearcher) Search(input string) []index.Path {
 	return b.Searcher.Search(input)
 }
 
+// Close closes the search index (if it has been loaded, see index.SearchIndex.Close).
+func (b *bootstrapSearcher) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	if b.si == nil {
+		return nil
+	}
+	return b.si.Close()
+}
+
 // newBootstrapFilter creates a new index.Filter which initialises the filter on the
 // first call to Filter.
 func newBootstrapFilter(root index.Collection, field attr.Interface) index.Filter {
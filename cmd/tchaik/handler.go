@@ -5,6 +5,8 @@
 package main
 
 import (
+	"io"
+	"log"
 	"net/http"
 	"path"
 
@@ -22,12 +24,22 @@ import (
 type traceFS struct {
 	store.FileSystem
 	family string
+
+	ctx       context.Context         // context of the request (optional)
+	transcode *store.TranscodeOptions // options for transcoding opened files (optional)
 }
 
 // Open implements http.FileSystem.
 func (t *traceFS) Open(path string) (http.File, error) {
+	ctx := t.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	tr := trace.New(t.family, path)
-	ctx := trace.NewContext(context.Background(), tr)
+	ctx = trace.NewContext(ctx, tr)
+	if t.transcode != nil {
+		ctx = store.NewTranscodeContext(ctx, *t.transcode)
+	}
 	f, err := t.FileSystem.Open(ctx, path)
 
 	// TODO: Decide where this should be in general (requests can be on-going).
@@ -42,7 +54,57 @@ type fsServeMux struct {
 // HandleFileSystem is a convenience method for adding an http.FileServer handler to an
 // http.ServeMux.
 func (fsm *fsServeMux) HandleFileSystem(pattern string, fs store.FileSystem) {
-	fsm.ServeMux.Handle(pattern, http.StripPrefix(pattern, http.FileServer(&traceFS{fs, pattern})))
+	fsm.ServeMux.Handle(pattern, http.StripPrefix(pattern, http.FileServer(&traceFS{FileSystem: fs, family: pattern})))
+}
+
+// HandleTranscodedFileSystem is similar to HandleFileSystem, but files are transcoded when
+// requested with "format" and "bitrate" query parameters (see store.TranscodeFileSystem).
+func (fsm *fsServeMux) HandleTranscodedFileSystem(pattern string, fs store.FileSystem) {
+	fsm.ServeMux.Handle(pattern, http.StripPrefix(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o, ok, err := store.TranscodeOptionsFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tfs := &traceFS{FileSystem: fs, family: pattern, ctx: r.Context()}
+		if !ok {
+			http.FileServer(tfs).ServeHTTP(w, r)
+			return
+		}
+		tfs.transcode = &o
+		serveTranscoded(w, r, tfs, o)
+	})))
+}
+
+// serveTranscoded serves the transcoded file.  Files which are still being transcoded are
+// written to the response as they are transcoded (without support for range requests),
+// otherwise they are served using http.ServeContent.
+func serveTranscoded(w http.ResponseWriter, r *http.Request, fs http.FileSystem, o store.TranscodeOptions) {
+	f, err := fs.Open(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", o.ContentType())
+	if sf, ok := f.(store.StreamingFile); !ok || !sf.Streaming() {
+		http.ServeContent(w, r, stat.Name(), stat.ModTime(), f)
+		return
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("error writing transcoded file: %v", err)
+	}
 }
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
@@ -51,15 +113,9 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // NewHandler creates the root http.Handler.
-func NewHandler(l Library, m *Meta, mediaFileSystem, artworkFileSystem store.FileSystem) http.Handler {
-	c := httpauth.Skip
-	if authUser != "" {
-		c = httpauth.Creds(map[string]string{
-			authUser: authPassword,
-		})
-	}
+func NewHandler(l *Library, u *Users, mediaFileSystem, artworkFileSystem store.FileSystem) http.Handler {
 	h := fsServeMux{
-		httpauth.NewServeMux(c, http.NewServeMux()),
+		httpauth.NewServeMux(u.Checker(), http.NewServeMux()),
 	}
 
 	h.HandleFunc("/", rootHandler)
@@ -71,13 +127,16 @@ func NewHandler(l Library, m *Meta, mediaFileSystem, artworkFileSystem store.Fil
 
 	mediaFileSystem = l.FileSystem(mediaFileSystem)
 	artworkFileSystem = l.FileSystem(artworkFileSystem)
-	h.HandleFileSystem("/track/", mediaFileSystem)
+	h.HandleTranscodedFileSystem("/track/", mediaFileSystem)
+	h.Handle("/hls/", http.StripPrefix("/hls/", store.NewHLSHandler(mediaFileSystem, 0)))
 	h.HandleFileSystem("/artwork/", artworkFileSystem)
 	h.HandleFileSystem("/icon/", store.FaviconFileSystem(artworkFileSystem))
 
 	p := player.NewPlayers()
-	h.Handle("/socket", NewWebsocketHandler(l, m, p))
+	h.Handle("/socket", NewWebsocketHandler(l, u, p))
 	h.Handle("/api/players/", http.StripPrefix("/api/players/", player.NewHTTPHandler(p)))
+	h.Handle("/api/stats/history", &statsHandler{lib: l, users: u})
+	h.Handle("/api/playlists/", http.StripPrefix("/api/playlists/", &playlistHandler{lib: l, users: u}))
 
 	return h
 }
@@ -6,48 +6,267 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"golang.org/x/net/context"
 
 	"tchaik.com/index"
 	"tchaik.com/index/attr"
+	"tchaik.com/index/walk"
 	"tchaik.com/store"
 )
 
-// Library is a type which encompases the components which form a full library.
+// Library is a type which encompases the components which form a full library.  All
+// components can be replaced by calling Update, so access should be through the Library
+// methods.
 type Library struct {
-	index.Library
+	sync.RWMutex
 
+	lib         index.Library
 	collections map[string]index.Collection
+	depths      map[string]int // number of levels above the albums in each collection
 	filters     map[string]index.Filter
 	recent      Lister
 	searcher    index.Searcher
+
+	opts      LibraryOptions
+	listeners libraryListeners
+}
+
+// LibraryOptions is a type which contains options used to build the components of a
+// Library.
+type LibraryOptions struct {
+	// FuzzySearch enables typo-tolerant search: search words are also matched against
+	// words in the index within a small edit distance.
+	FuzzySearch bool
+
+	// SearchIndex is the path of a persisted search index.  If set, the search index is
+	// loaded from the file (and rebuilt and rewritten if it is missing or out of date).
+	SearchIndex string
+
+	// SearchIndexSource identifies the library data (i.e. the size and modification time of
+	// the library file, see index.LibraryFileSource), and is used to check that the
+	// persisted search index is up to date without reading every track.  If empty, the
+	// index is checked against the root collection (see index.CollectionSource).  It must
+	// not be set for libraries which are changed by Update.
+	SearchIndexSource string
+
+	// Collections are the names of the alternative root collections to build in addition
+	// to "Root" (see rootCollections).
+	Collections []string
+}
+
+// rootCollections are the alternative root collections which can be enabled using
+// LibraryOptions: tracks are grouped by each of the collectors in turn and then by album.
+var rootCollections = map[string][]index.Collector{
+	"Composer": {index.ByEach(attr.Strings("Composer"))},
+	"Artist":   {index.ByEach(attr.Strings("Artist"))},
+	"Genre":    {index.By(attr.String("Genre"))},
+	"Decade":   {index.ByDecade(attr.Int("Year")), index.By(attr.Int("Year"))},
 }
 
-func NewLibrary(l index.Library) Library {
+// NewLibrary creates a new Library from the index.Library.
+func NewLibrary(l index.Library, opts LibraryOptions) *Library {
+	lib := &Library{
+		opts: opts,
+		listeners: libraryListeners{
+			m: make(map[interface{}]func()),
+		},
+	}
+	lib.set(l)
+	return lib
+}
+
+// set builds all the components of the Library from the index.Library and then replaces
+// the existing components.
+func (l *Library) set(lib index.Library) {
 	fmt.Printf("Building root collection...")
-	root := buildRootCollection(l)
+	root := index.NewRootCollection(lib)
 	fmt.Println("done.")
 
-	fmt.Printf("Processing artist names and composers...")
-	rootSplit := index.SubTransform(root, index.SplitList("Artist", "Composer"))
+	fmt.Printf("Processing artist, composer and conductor names...")
+	rootSplit := index.SubTransform(root, index.SplitList("Artist", "Composer", "Conductor"))
 	fmt.Println("done.")
 
-	return Library{
-		Library: l,
-		collections: map[string]index.Collection{
-			"Root": root,
-		},
-		filters: map[string]index.Filter{
-			"Artist":   newBootstrapFilter(rootSplit, attr.Strings("Artist")),
-			"Composer": newBootstrapFilter(rootSplit, attr.Strings("Composer")),
-		},
-		recent:   &bootstrapRecent{root: root, n: 150},
-		searcher: newBootstrapSearcher(root),
+	collections := map[string]index.Collection{
+		"Root": root,
+	}
+	depths := map[string]int{
+		"Root": 0,
+	}
+	for _, name := range l.opts.Collections {
+		cs, ok := rootCollections[name]
+		if !ok {
+			fmt.Printf("Unknown collection: %v\n", name)
+			continue
+		}
+		fmt.Printf("Building %v collection...", name)
+		collections[name] = index.NewNestedCollection(lib, cs...)
+		depths[name] = len(cs)
+		fmt.Println("done.")
+	}
+
+	filters := map[string]index.Filter{
+		"Artist":    newBootstrapFilter(rootSplit, attr.Strings("Artist")),
+		"Composer":  newBootstrapFilter(rootSplit, attr.Strings("Composer")),
+		"Conductor": newBootstrapFilter(rootSplit, attr.Strings("Conductor")),
+		"Orchestra": newBootstrapFilter(root, attr.String("Orchestra")),
+		"Catalogue": newBootstrapFilter(root, attr.String("CatalogueSystem")),
+	}
+	recent := &bootstrapRecent{root: root, n: 150}
+	searcher := newBootstrapSearcher(root, l.opts)
+
+	l.Lock()
+	old := l.searcher
+	l.lib = lib
+	l.collections = collections
+	l.depths = depths
+	l.filters = filters
+	l.recent = recent
+	l.searcher = searcher
+	l.Unlock()
+
+	// Close the search index file of the replaced searcher.
+	if c, ok := old.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// Update replaces the underlying index.Library (rebuilding all collections, filters and
+// indexes) and then notifies all listeners that the Library has changed.
+func (l *Library) Update(lib index.Library) {
+	l.set(lib)
+	l.listeners.notify()
+}
+
+// AddListener adds a function which will be called (with the given key) after each
+// call to Update.
+func (l *Library) AddListener(key interface{}, fn func()) {
+	l.listeners.add(key, fn)
+}
+
+// RemoveListener removes the listener with the given key.
+func (l *Library) RemoveListener(key interface{}) {
+	l.listeners.remove(key)
+}
+
+// Tracks implements index.Library.
+func (l *Library) Tracks() []index.Track {
+	l.RLock()
+	defer l.RUnlock()
+
+	return l.lib.Tracks()
+}
+
+// Track implements index.Library.
+func (l *Library) Track(id string) (index.Track, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	return l.lib.Track(id)
+}
+
+// Collection returns the collection with the given name, or nil if no such collection
+// exists.
+func (l *Library) Collection(name string) index.Collection {
+	l.RLock()
+	defer l.RUnlock()
+
+	return l.collections[name]
+}
+
+// Filter returns the filter with the given name, and true if successful, false otherwise.
+func (l *Library) Filter(name string) (index.Filter, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	f, ok := l.filters[name]
+	return f, ok
+}
+
+// Recent returns the list of recently added paths.
+func (l *Library) Recent() []index.Path {
+	l.RLock()
+	recent := l.recent
+	l.RUnlock()
+
+	return recent.List()
+}
+
+// Search implements index.Searcher.
+func (l *Library) Search(input string) []index.Path {
+	l.RLock()
+	searcher := l.searcher
+	l.RUnlock()
+
+	return searcher.Search(input)
+}
+
+// SearchScored implements index.ScoredSearcher.
+func (l *Library) SearchScored(input string) []index.ScoredPath {
+	l.RLock()
+	searcher := l.searcher
+	l.RUnlock()
+
+	return index.SearchScored(searcher, input)
+}
+
+// libraryListeners is a set of functions which are called when the Library changes.
+type libraryListeners struct {
+	sync.Mutex
+	m map[interface{}]func()
+}
+
+func (ll *libraryListeners) add(key interface{}, fn func()) {
+	ll.Lock()
+	defer ll.Unlock()
+
+	ll.m[key] = fn
+}
+
+func (ll *libraryListeners) remove(key interface{}) {
+	ll.Lock()
+	defer ll.Unlock()
+
+	delete(ll.m, key)
+}
+
+func (ll *libraryListeners) notify() {
+	ll.Lock()
+	fns := make([]func(), 0, len(ll.m))
+	for _, fn := range ll.m {
+		fns = append(fns, fn)
+	}
+	ll.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// watchLibrary watches the directory tree under path and updates the Library whenever
+// files are added, changed or removed.
+func watchLibrary(l *Library, path string) error {
+	w, err := walk.NewWatcher(path, watchDelay)
+	if err != nil {
+		return err
 	}
+
+	go func() {
+		for range w.C {
+			lib, s := walk.Update(l, path, walk.Options{ContentID: contentID})
+			if len(s.Added) == 0 && len(s.Changed) == 0 && len(s.Removed) == 0 {
+				continue
+			}
+			fmt.Printf("Library changed: %d added, %d changed, %d removed.\n", len(s.Added), len(s.Changed), len(s.Removed))
+			l.Update(index.Convert(lib, "ID"))
+		}
+	}()
+	return nil
 }
 
 type libraryFileSystem struct {
@@ -78,7 +297,9 @@ func (l *Library) Fetch(p index.Path) (index.Group, index.Key, error) {
 		return nil, "", fmt.Errorf("invalid path: %v\n", p)
 	}
 
-	root := l.collections[string(p[0])]
+	l.RLock()
+	root, depth := l.collections[string(p[0])], l.depths[string(p[0])]
+	l.RUnlock()
 	if root == nil {
 		return nil, "", fmt.Errorf("unknown collection: %#v", p[0])
 	}
@@ -87,20 +308,21 @@ func (l *Library) Fetch(p index.Path) (index.Group, index.Key, error) {
 		return root, p[0], nil
 	}
 
-	g, err := l.Build(root, p[1:])
+	g, err := l.Build(index.NestedGroups(root, depth), p[1:])
 	if err != nil {
 		return nil, "", fmt.Errorf("error in Fetch: %v (path: %#v)", err, p[1:])
 	}
 	return g, p[1], nil
 }
 
-// Build fetches a Group from the index.Collection given by the Path.
+// Build fetches a Group from the index.Collection given by the Path.  The collection should
+// transform its groups when they are fetched (see index.RootGroups and index.NestedGroups).
 func (l *Library) Build(c index.Collection, p index.Path) (index.Group, error) {
 	if len(p) == 0 {
 		return c, nil
 	}
 
-	g, err := index.GroupFromPath(&rootCollection{c}, p)
+	g, err := index.GroupFromPath(c, p)
 	if err != nil {
 		return nil, err
 	}
@@ -111,14 +333,23 @@ func (l *Library) Build(c index.Collection, p index.Path) (index.Group, error) {
 // FileSystem wraps the http.FileSystem in a library lookup which will translate /ID
 // requests into their corresponding track paths.
 func (l *Library) FileSystem(fs store.FileSystem) store.FileSystem {
-	return store.Trace(&libraryFileSystem{fs, l.Library}, "libraryFileSystem")
+	return store.Trace(&libraryFileSystem{fs, l}, "libraryFileSystem")
+}
+
+// ExpandScoredPaths constructs a collection (group) whose sub-groups are taken from the
+// "Root" collection, each with its search score.
+func (l *Library) ExpandScoredPaths(paths []index.ScoredPath) index.Group {
+	return &Group{
+		Group: index.NewScoredPathsCollection(l.Collection("Root"), paths),
+		Key:   index.Key("Root"),
+	}
 }
 
 // ExpandPaths constructs a collection (group) whose sub-groups are taken from the "Root"
 // collection.
 func (l *Library) ExpandPaths(paths []index.Path) index.Group {
 	return &Group{
-		Group: index.NewPathsCollection(l.collections["Root"], paths),
+		Group: index.NewPathsCollection(l.Collection("Root"), paths),
 		Key:   index.Key("Root"),
 	}
 }
@@ -14,6 +14,48 @@ A common use case is to begin by use using an existing iTunes Library file:
 
   tchaik -itlXML /path/to/iTunesMusicLibrary.xml
 
+When the library is built from a directory tree (using -path), the tree is watched for changes and the library is
+updated whenever files are added, changed or removed.  Connected clients are notified of each change.
+
+  tchaik -path /path/to/music
+
+Use -content-id to identify tracks by their audio data rather than their file path, so that track IDs don't change
+when files are moved or re-tagged (see tchmigrate to remap play history etc after a library has been reorganised).
+
+When using a Tchaik library file (-lib), the search index is read from the file alongside it (see tchimport)
+and is rebuilt automatically if it is missing or the library file has changed.  Only the index header is read on
+startup: the entries for each word are read from the file when they are first searched for.
+
+To share a server between several people, use a credentials file (a JSON object mapping user names to
+passwords).  Each user has their own play history, favourites, checklist, ratings, playlists and cursors, which are
+stored in a sub-directory of -user-data named after the user.
+
+  tchaik -lib lib.tch -users users.json
+
+By default, play history, favourites, checklist, ratings, playlists and cursors are stored in JSON files which are rewritten
+after every change.  Use -db to store them in a database instead (see tchmigrate to import existing JSON files).
+
+  tchaik -lib lib.tch -db tchaik.db
+
+Smart playlists are defined by a set of rules (see playlist.Smart) and are re-evaluated against the library, play
+history, favourites, checklist and ratings whenever any of them change.
+
+Playlists can be imported and exported as M3U8, PLS or XSPF files using the REST API (see tchplaylist):
+
+  GET /api/playlists/Default.xspf
+
+As well as the "Root" collection (albums), the library can be browsed by composer, artist, genre or decade (then
+year), each leading to albums.  Use -collections to choose which of these are built (none by default):
+
+  tchaik -lib lib.tch -collections Composer,Decade
+
+Only paths in the Root collection can be added to playlists, cursors and the play queue, or used with history,
+favourites, checklist and ratings.
+
+Search input can use field qualifiers, quoted phrases, numeric ranges and negation (see index.ParseQuery):
+
+  composer:bach "cello suite" year:1720..1750 -organ
+
 */
 package main
 
@@ -24,10 +66,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"tchaik.com/index"
-	"tchaik.com/index/attr"
-
 	"tchaik.com/index/itl"
 	"tchaik.com/index/walk"
 	"tchaik.com/store"
@@ -36,14 +78,21 @@ import (
 
 var debug bool
 var itlXML, tchLib, walkPath string
+var watchDelay time.Duration
+var contentID bool
+var fuzzySearch bool
+var searchIndexPath string
+var collections string
 
-var playHistoryPath, favouritesPath, checklistPath, playlistPath, cursorPath string
+var playHistoryPath, favouritesPath, checklistPath, ratingsPath, playlistPath, cursorPath string
+var dbPath string
 
 var listenAddr string
 var uiDir string
 var certFile, keyFile string
 
 var authUser, authPassword string
+var usersPath, userDataDir string
 
 var traceListenAddr string
 
@@ -57,17 +106,27 @@ func init() {
 	flag.StringVar(&itlXML, "itlXML", "", "iTunes Library XML `file`")
 	flag.StringVar(&tchLib, "lib", "", "Tchaik library `file`")
 	flag.StringVar(&walkPath, "path", "", "`directory` containing music files")
+	flag.BoolVar(&contentID, "content-id", false, "use the SHA1 sum of the audio data of each file in -path as the track ID (instead of the file path)")
+	flag.DurationVar(&watchDelay, "watch-delay", 2*time.Second, "`delay` after changes to files in -path before the library is updated")
+
+	flag.BoolVar(&fuzzySearch, "fuzzy-search", false, "match misspelled words in search input")
+	flag.StringVar(&collections, "collections", "", "comma-separated `list` of collections to browse in addition to Root (Composer, Artist, Genre, Decade)")
+	flag.StringVar(&searchIndexPath, "search-index", "", "search index `file` (defaults to <lib>.idx when using -lib), rebuilt if out of date")
 
 	flag.StringVar(&playHistoryPath, "play-history", "history.json", "play history `file`")
 	flag.StringVar(&favouritesPath, "favourites", "favourites.json", "favourites `file`")
 	flag.StringVar(&checklistPath, "checklist", "checklist.json", "checklist `file`")
+	flag.StringVar(&ratingsPath, "ratings", "ratings.json", "ratings `file`")
 	flag.StringVar(&playlistPath, "playlists", "playlists.json", "playlists `file`")
 	flag.StringVar(&cursorPath, "cursors", "cursors.json", "cursors `file`")
+	flag.StringVar(&dbPath, "db", "", "database `file` to use for play history, favourites, checklist, ratings, playlists and cursors (instead of JSON files)")
 
 	flag.StringVar(&uiDir, "ui-dir", "ui", "UI asset `directory`")
 
 	flag.StringVar(&authUser, "auth-user", "", "`user` to use for HTTP authentication (set to enable)")
 	flag.StringVar(&authPassword, "auth-password", "", "`password` to use for HTTP authentication")
+	flag.StringVar(&usersPath, "users", "", "credentials `file` (JSON object mapping user names to passwords) to enable multiple users")
+	flag.StringVar(&userDataDir, "user-data", "users", "`directory` containing the history, favourites, checklist, ratings, playlists and cursors of each user (see -users)")
 
 	flag.StringVar(&traceListenAddr, "trace-listen", "", "bind `address` for trace HTTP server")
 }
@@ -124,7 +183,7 @@ func readLibrary() (index.Library, error) {
 
 	case walkPath != "":
 		fmt.Printf("Walking %v...\n", walkPath)
-		lib = walk.NewLibrary(walkPath)
+		lib = walk.NewLibrary(walkPath, walk.Options{ContentID: contentID})
 		fmt.Println("Finished walking.")
 	}
 
@@ -134,12 +193,6 @@ func readLibrary() (index.Library, error) {
 	return lib, nil
 }
 
-func buildRootCollection(l index.Library) index.Collection {
-	root := index.Collect(l, index.By(attr.String("Album")))
-	index.SortKeysByGroupName(root)
-	return root
-}
-
 func main() {
 	flag.Parse()
 
@@ -167,13 +220,57 @@ func main() {
 		}()
 	}
 
-	lib := NewLibrary(l)
-	meta, err := loadLocalMeta()
+	if searchIndexPath == "" && tchLib != "" {
+		searchIndexPath = tchLib + ".idx"
+	}
+
+	var cols []string
+	for _, x := range strings.Split(collections, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			cols = append(cols, x)
+		}
+	}
+
+	var searchIndexSource string
+	if tchLib != "" {
+		fi, err := os.Stat(tchLib)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		searchIndexSource = index.LibraryFileSource(fi)
+	}
+
+	lib := NewLibrary(l, LibraryOptions{
+		FuzzySearch:       fuzzySearch,
+		SearchIndex:       searchIndexPath,
+		SearchIndexSource: searchIndexSource,
+		Collections:       cols,
+	})
+	if walkPath != "" {
+		fmt.Printf("Watching %v for changes...", walkPath)
+		err = watchLibrary(lib, walkPath)
+		if err != nil {
+			fmt.Printf("\nerror watching %v: %v\n", walkPath, err)
+			os.Exit(1)
+		}
+		fmt.Println("done.")
+	}
+
+	if usersPath != "" && authUser != "" {
+		fmt.Println("error: must only specify one of -users or -auth-user")
+		os.Exit(1)
+	}
+
+	users, err := loadUsers(usersPath, userDataDir)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	h := NewHandler(lib, meta, mediaFileSystem, artworkFileSystem)
+	for _, m := range users.meta {
+		lib.AddListener(m, m.invalidateSmartPlaylists)
+	}
+	h := NewHandler(lib, users, mediaFileSystem, artworkFileSystem)
 
 	if certFile != "" && keyFile != "" {
 		fmt.Printf("Web server is running on https://%v\n", listenAddr)
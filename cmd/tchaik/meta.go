@@ -6,49 +6,78 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"tchaik.com/index"
 	"tchaik.com/index/checklist"
 	"tchaik.com/index/cursor"
+	"tchaik.com/index/db"
 	"tchaik.com/index/favourite"
 	"tchaik.com/index/history"
 	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
 )
 
-// Meta is a container for extra metadata which wraps the central media library.
-// TODO: Refactor meta to be user-specific.
+// Meta is a container for extra metadata which wraps the central media library.  Each
+// user has their own Meta (see Users).
 type Meta struct {
 	history    history.Store
 	favourites favourite.Store
 	checklist  checklist.Store
+	ratings    rating.Store
 	playlists  playlist.Store
 	cursors    cursor.Store
+
+	smart smartPlaylists
+}
+
+// metaPath returns the path of the meta store file p within the directory dir.  If dir
+// is empty then p is returned unchanged.
+func metaPath(dir, p string) string {
+	if dir == "" {
+		return p
+	}
+	return filepath.Join(dir, filepath.Base(p))
 }
 
-func loadLocalMeta() (*Meta, error) {
+// loadMeta loads the meta stores from the directory dir (using the file names given by
+// the store flags).  If dir is empty then the store flags are used as given.  If -db is
+// set then the stores are loaded from the database instead.
+func loadMeta(dir string) (*Meta, error) {
+	if dbPath != "" {
+		return loadDBMeta(metaPath(dir, dbPath))
+	}
+
 	fmt.Printf("Loading play history...")
-	playHistoryStore, err := history.NewStore(playHistoryPath)
+	playHistoryStore, err := history.NewStore(metaPath(dir, playHistoryPath))
 	if err != nil {
 		return nil, fmt.Errorf("error loading play history: %v", err)
 	}
 	fmt.Println("done.")
 
 	fmt.Printf("Loading favourites...")
-	favouriteStore, err := favourite.NewStore(favouritesPath)
+	favouriteStore, err := favourite.NewStore(metaPath(dir, favouritesPath))
 	if err != nil {
 		return nil, fmt.Errorf("\nerror loading favourites: %v", err)
 	}
 	fmt.Println("done.")
 
 	fmt.Printf("Loading checklist...")
-	checklistStore, err := checklist.NewStore(checklistPath)
+	checklistStore, err := checklist.NewStore(metaPath(dir, checklistPath))
 	if err != nil {
 		return nil, fmt.Errorf("\nerror loading checklist: %v", err)
 	}
 	fmt.Println("done.")
 
+	fmt.Printf("Loading ratings...")
+	ratingStore, err := rating.NewStore(metaPath(dir, ratingsPath))
+	if err != nil {
+		return nil, fmt.Errorf("\nerror loading ratings: %v", err)
+	}
+	fmt.Println("done.")
+
 	fmt.Printf("Loading playlists...")
-	playlistStore, err := playlist.NewStore(playlistPath)
+	playlistStore, err := playlist.NewStore(metaPath(dir, playlistPath))
 	if err != nil {
 		return nil, fmt.Errorf("\nerror loading playlists: %v", err)
 	}
@@ -59,7 +88,7 @@ func loadLocalMeta() (*Meta, error) {
 	fmt.Println("done")
 
 	fmt.Printf("Loading cursors...")
-	cursorStore, err := cursor.NewStore(cursorPath)
+	cursorStore, err := cursor.NewStore(metaPath(dir, cursorPath))
 	if err != nil {
 		return nil, fmt.Errorf("\nerror loading cursor: %v", err)
 	}
@@ -69,11 +98,58 @@ func loadLocalMeta() (*Meta, error) {
 		history:    playHistoryStore,
 		favourites: favouriteStore,
 		checklist:  checklistStore,
+		ratings:    ratingStore,
 		playlists:  playlistStore,
 		cursors:    cursorStore,
 	}, nil
 }
 
+// loadDBMeta loads the meta stores from the database at path.
+func loadDBMeta(path string) (*Meta, error) {
+	fmt.Printf("Opening database %v...", path)
+	d, err := db.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("\n%v", err)
+	}
+
+	m := &Meta{}
+	m.history, err = d.HistoryStore()
+	if err != nil {
+		return nil, fmt.Errorf("\nerror loading play history: %v", err)
+	}
+
+	m.favourites, err = d.FavouriteStore()
+	if err != nil {
+		return nil, fmt.Errorf("\nerror loading favourites: %v", err)
+	}
+
+	m.checklist, err = d.ChecklistStore()
+	if err != nil {
+		return nil, fmt.Errorf("\nerror loading checklist: %v", err)
+	}
+
+	m.ratings, err = d.RatingStore()
+	if err != nil {
+		return nil, fmt.Errorf("\nerror loading ratings: %v", err)
+	}
+
+	m.playlists, err = d.PlaylistStore()
+	if err != nil {
+		return nil, fmt.Errorf("\nerror loading playlists: %v", err)
+	}
+	// TODO(dhowden): remove this once we can better intialise the "Default" playlist
+	if p := m.playlists.Get("Default"); p == nil {
+		m.playlists.Set("Default", &playlist.Playlist{})
+	}
+
+	m.cursors, err = d.CursorStore()
+	if err != nil {
+		return nil, fmt.Errorf("\nerror loading cursors: %v", err)
+	}
+	fmt.Println("done.")
+	return m, nil
+}
+
 type metaFieldGrp struct {
 	index.Group
 
@@ -106,6 +182,10 @@ func newMetaField(g index.Group, field string, value bool) index.Group {
 	if !value {
 		return g
 	}
+	return newMetaValueField(g, field, value)
+}
+
+func newMetaValueField(g index.Group, field string, value interface{}) index.Group {
 	if c, ok := g.(index.Collection); ok {
 		// TODO(dhowden): currently need to maintain the underlying interface type
 		// so that it can be correctly transmitted, need a better way of doing this.
@@ -125,5 +205,9 @@ func newMetaField(g index.Group, field string, value bool) index.Group {
 // Annotate adds any meta information to the Group (identified by Path).
 func (m *Meta) Annotate(p index.Path, g index.Group) index.Group {
 	g = newMetaField(g, "Favourite", m.favourites.Get(p))
-	return newMetaField(g, "Checklist", m.checklist.Get(p))
+	g = newMetaField(g, "Checklist", m.checklist.Get(p))
+	if r := m.ratings.Get(p); r != rating.None {
+		g = newMetaValueField(g, "Rating", r)
+	}
+	return g
 }
@@ -0,0 +1,204 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"tchaik.com/index"
+	"tchaik.com/index/playlist"
+)
+
+// playlistHandler is a http.Handler which imports and exports the playlists of the
+// authenticated user as M3U8, PLS or XSPF files.  Paths are relative to the handler:
+//
+//  GET ""         list the playlist names (JSON)
+//  GET "<name>"   export the playlist
+//  PUT "<name>"   import the playlist from the request body (replacing any existing
+//                 playlist with the same name)
+//
+// The format is given by the "format" query parameter, or by the extension of the name
+// (i.e. "Default.xspf"), and defaults to M3U8.  Exported playlists refer to tracks by their
+// URLs on this server (i.e. "http://localhost:8080/track/<ID>"), which are also accepted by
+// imports.
+type playlistHandler struct {
+	lib   *Library
+	users *Users
+}
+
+// playlistImport is the response to a playlist import.
+type playlistImport struct {
+	Name    string   `json:"name"`
+	Tracks  int      `json:"tracks"`
+	Missing []string `json:"missing"`
+}
+
+// playlistFormat returns the playlist name and format from the request.
+func playlistFormat(r *http.Request) (string, playlist.Format, error) {
+	name := r.URL.Path
+	if x := r.URL.Query().Get("format"); x != "" {
+		f, ok := playlist.ParseFormat(x)
+		if !ok {
+			return "", "", fmt.Errorf("invalid playlist format: %v", x)
+		}
+		return name, f, nil
+	}
+
+	ext := path.Ext(name)
+	if f, ok := playlist.ParseFormat(ext); ok && ext != "" {
+		return strings.TrimSuffix(name, ext), f, nil
+	}
+	return name, playlist.M3U8, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *playlistHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := h.users.RequestMeta(r)
+	if m == nil {
+		http.Error(w, "no data for user", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path == "" {
+		if r.Method != "GET" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		names := m.playlists.Names()
+		sort.Strings(names)
+		writeJSON(w, names)
+		return
+	}
+
+	name, f, err := playlistFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		h.export(m, name, f, w, r)
+	case "PUT":
+		h.importPlaylist(m, name, f, w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *playlistHandler) export(m *Meta, name string, f playlist.Format, w http.ResponseWriter, r *http.Request) {
+	root := index.RootGroups(h.lib.Collection("Root"))
+	err := m.refreshSmartPlaylists(root)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error refreshing smart playlists: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	p := m.playlists.Get(name)
+	if p == nil {
+		http.Error(w, "invalid playlist name", http.StatusNotFound)
+		return
+	}
+
+	entries, err := playlist.Export(p, root, baseURL(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error exporting playlist: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	err = playlist.Encode(buf, f, name, entries)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error encoding playlist: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", f.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+string(f)))
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func (h *playlistHandler) importPlaylist(m *Meta, name string, f playlist.Format, w http.ResponseWriter, r *http.Request) {
+	if p := m.playlists.Get(name); p != nil && p.Smart() != nil {
+		http.Error(w, "cannot replace smart playlist", http.StatusConflict)
+		return
+	}
+
+	entries, err := playlist.Decode(r.Body, f)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding playlist: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var before []*playlist.Item
+	if p := m.playlists.Get(name); p != nil {
+		before = p.Items()
+	}
+
+	root := index.RootGroups(h.lib.Collection("Root"))
+	p, missing := playlist.Import(entries, root)
+	err = m.playlists.Set(name, p)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error saving playlist: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The items of the imported playlist are all new, so this clears the position of the
+	// cursor of the replaced playlist (see cursor.Sync).
+	if cur := m.cursors.Get(name); cur != nil {
+		err = cur.Sync(before, p, root)
+		if err1 := m.cursors.Set(name, cur); err == nil {
+			err = err1
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error updating cursor: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := playlistImport{
+		Name:    name,
+		Tracks:  len(entries) - len(missing),
+		Missing: make([]string, len(missing)),
+	}
+	for i, e := range missing {
+		result.Missing[i] = e.Location
+	}
+	writeJSON(w, result)
+}
+
+// baseURL returns the URL of the server from the request (i.e. "http://localhost:8080").
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// writeJSON writes x to w as JSON.
+func writeJSON(w http.ResponseWriter, x interface{}) {
+	b, err := json.Marshal(x)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error encoding JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	if err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
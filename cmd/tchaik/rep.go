@@ -35,6 +35,7 @@ func (g *Group) MarshalJSON() ([]byte, error) {
 		ID:          g.Field("ID"),
 		Favourite:   g.Field("Favourite"),
 		Checklist:   g.Field("Checklist"),
+		Rating:      g.Field("Rating"),
 	}
 
 	if c, ok := g.Group.(index.Collection); ok {
@@ -102,6 +103,7 @@ func buildCollection(h group, c index.Collection) group {
 			Key:         k,
 			AlbumArtist: g.Field("AlbumArtist"),
 			Artist:      g.Field("Artist"),
+			Score:       g.Field("Score"),
 		})
 	}
 	return h
@@ -190,37 +192,8 @@ type group struct {
 	Kind        interface{}   `json:"kind,omitempty"`
 	Favourite   interface{}   `json:"favourite,omitempty"`
 	Checklist   interface{}   `json:"checklist,omitempty"`
+	Rating      interface{}   `json:"rating,omitempty"`
+	Score       interface{}   `json:"score,omitempty"`
 	Groups      []group       `json:"groups,omitempty"`
 	Tracks      []index.Track `json:"tracks,omitempty"`
 }
-
-type rootCollection struct {
-	index.Collection
-}
-
-func (r *rootCollection) Get(k index.Key) index.Group {
-	g := r.Collection.Get(k)
-	if g == nil {
-		return g
-	}
-
-	index.Sort(g.Tracks(), index.MultiSort(index.SortByString("Kind"), index.SortByInt("DiscNumber"), index.SortByInt("TrackNumber")))
-	g = index.Transform(g, index.SplitList("Artist", "AlbumArtist", "Composer"))
-	g = index.Transform(g, index.TrimTrackNumPrefix)
-	c := index.Collect(g, index.ByPrefix("Name"))
-	g = index.SubTransform(c, index.TrimEnumPrefix)
-	g = index.SumGroupIntAttr("TotalTime", g)
-	commonFields := []attr.Interface{
-		attr.String("Album"),
-		attr.Strings("Artist"),
-		attr.Strings("AlbumArtist"),
-		attr.Strings("Composer"),
-		attr.String("Kind"),
-		attr.Int("Year"),
-		attr.Int("BitRate"),
-		attr.Int("DiscNumber"),
-	}
-	g = index.CommonGroupAttr(commonFields, g)
-	g = index.RemoveEmptyCollections(g)
-	return g
-}
@@ -0,0 +1,86 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"tchaik.com/index"
+)
+
+// loadSearchIndex reads the search index from path, and checks that it was built from
+// the library data identified by source (or the root collection if source is empty).  If
+// path is empty, or the index is missing or out of date, then a new index is built (and
+// written to path if set).
+func loadSearchIndex(path, source string, root index.Collection) *index.SearchIndex {
+	if path == "" {
+		return index.BuildSearchIndex(root, source, index.SearchFields, index.SearchBoosts, index.SearchPrefixSize)
+	}
+
+	if source == "" {
+		source = index.CollectionSource(root, index.SearchFields)
+	}
+	checksum := index.SearchIndexChecksum(source, index.SearchFields, index.SearchBoosts, index.SearchPrefixSize)
+	si, err := readSearchIndex(path)
+	if err == nil {
+		if si.Checksum == checksum {
+			return si
+		}
+		si.Close()
+	}
+
+	switch {
+	case os.IsNotExist(err):
+		fmt.Printf("Search index %v does not exist, building...", path)
+	case err != nil:
+		fmt.Printf("Error reading search index %v: %v, rebuilding...", path, err)
+	default:
+		fmt.Printf("Search index %v is out of date, rebuilding...", path)
+	}
+	si = index.BuildSearchIndex(root, source, index.SearchFields, index.SearchBoosts, index.SearchPrefixSize)
+	fmt.Println("done.")
+
+	err = writeSearchIndex(path, si)
+	if err != nil {
+		fmt.Printf("error writing search index %v: %v\n", path, err)
+	}
+	return si
+}
+
+func readSearchIndex(path string) (*index.SearchIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file is kept open: postings are read from it as they are needed.
+	si, err := index.ReadSearchIndex(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return si, nil
+}
+
+// writeSearchIndex writes the search index to a temporary file alongside path, and
+// then renames it so that path is never left partially written.
+func writeSearchIndex(path string, si *index.SearchIndex) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = index.WriteSearchIndex(si, f)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,167 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/cursor"
+	"tchaik.com/index/playlist"
+)
+
+// smartPlaylists records which of the smart playlists of a Meta are up to date.  Smart
+// playlists are marked as stale when the library or meta data changes, and are then
+// re-evaluated the next time they are fetched.  Cursors are synced onto the new items of
+// their playlists (see refreshSmartPlaylist).
+type smartPlaylists struct {
+	sync.Mutex
+	fresh map[string]bool
+}
+
+// smartMeta implements playlist.Meta using the meta stores.
+type smartMeta struct {
+	*Meta
+}
+
+// Rating implements playlist.Meta.
+func (m smartMeta) Rating(p index.Path) int { return int(m.ratings.Get(p)) }
+
+// Plays implements playlist.Meta.
+func (m smartMeta) Plays(p index.Path) []time.Time { return m.history.Get(p) }
+
+// Favourite implements playlist.Meta.
+func (m smartMeta) Favourite(p index.Path) bool { return m.favourites.Get(p) }
+
+// Checklist implements playlist.Meta.
+func (m smartMeta) Checklist(p index.Path) bool { return m.checklist.Get(p) }
+
+// invalidateSmartPlaylists marks the smart playlists as stale.
+func (m *Meta) invalidateSmartPlaylists() {
+	m.smart.Lock()
+	defer m.smart.Unlock()
+
+	m.smart.fresh = nil
+}
+
+// refreshSmartPlaylists re-evaluates the stale smart playlists against the root collection.
+func (m *Meta) refreshSmartPlaylists(root index.Collection) error {
+	m.smart.Lock()
+	defer m.smart.Unlock()
+
+	if m.smart.fresh == nil {
+		m.smart.fresh = make(map[string]bool)
+	}
+
+	now := time.Now()
+	for _, name := range m.playlists.Names() {
+		if m.smart.fresh[name] {
+			continue
+		}
+		err := m.refreshSmartPlaylist(name, root, now)
+		if err != nil {
+			return err
+		}
+		m.smart.fresh[name] = true
+	}
+	return nil
+}
+
+// refreshSmartPlaylist re-evaluates the smart playlist name.  The playlist is replaced
+// rather than changed in place, as cursors may be reading its items.  The current track of
+// its cursor is kept in the playlist (even if it no longer matches the rules) so that the
+// cursor can move on from it, and the cursor is synced onto the new items.
+func (m *Meta) refreshSmartPlaylist(name string, root index.Collection, now time.Time) error {
+	old := m.playlists.Get(name)
+	if old == nil || old.Smart() == nil {
+		return nil
+	}
+	p := old.Copy()
+	p.Refresh(root, smartMeta{m}, now)
+
+	cur := m.cursors.Get(name)
+	var current cursor.Position
+	if cur != nil {
+		current = cur.Copy().Current
+	}
+
+	var before []*playlist.Item
+	if !current.Empty() {
+		before = refreshedItems(old.Items(), p.Items())
+		keepCurrent(p, before, current)
+	}
+
+	err := m.playlists.Set(name, p)
+	if err != nil || current.Empty() {
+		return err
+	}
+
+	err = cur.Sync(before, p, root)
+	if err1 := m.cursors.Set(name, cur); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// keepCurrent makes sure that the refreshed playlist p contains the current track of a
+// cursor, where before maps the items before the refresh to those of p (see
+// refreshedItems).  If the item of the current track is no longer in p, then the track is
+// added as an item of its own (before the next item which is still in p).
+func keepCurrent(p *playlist.Playlist, before []*playlist.Item, current cursor.Position) {
+	if current.Index < 0 || current.Index >= len(before) {
+		return
+	}
+
+	items := p.Items()
+	if x := before[current.Index]; x != nil {
+		for i, y := range items {
+			if x == y {
+				if !x.Path().Equal(current.Path) {
+					p.Include(i, current.Path)
+				}
+				return
+			}
+		}
+	}
+
+	n := len(items)
+	for _, x := range before[current.Index+1:] {
+		if x == nil {
+			continue
+		}
+		for i, y := range items {
+			if x == y {
+				n = i
+				break
+			}
+		}
+		break
+	}
+	if p.Insert(n, current.Path) == nil {
+		before[current.Index] = p.Items()[n]
+	}
+}
+
+// refreshedItems returns the items of a refreshed playlist which correspond to the items
+// before the refresh (i.e. the "before" items for cursor.Sync): the item with the same path,
+// or nil if there isn't one.
+func refreshedItems(before, after []*playlist.Item) []*playlist.Item {
+	items := make(map[string][]*playlist.Item)
+	for _, x := range after {
+		k := x.Path().Encode()
+		items[k] = append(items[k], x)
+	}
+
+	result := make([]*playlist.Item, len(before))
+	for i, x := range before {
+		k := x.Path().Encode()
+		if xs := items[k]; len(xs) > 0 {
+			result[i] = xs[0]
+			items[k] = xs[1:]
+		}
+	}
+	return result
+}
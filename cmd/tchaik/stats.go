@@ -0,0 +1,148 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/history"
+	"tchaik.com/index/migrate"
+)
+
+// statsHandler is a http.Handler which serves play history statistics for the authenticated
+// user as JSON.  Query parameters:
+//
+//  from   start of the time range (RFC3339 or YYYY-MM-DD, default: all time)
+//  to     end of the time range, exclusive (RFC3339 or YYYY-MM-DD, default: now)
+//  n      maximum number of items in each list (default: 10)
+//  depth  number of path keys to aggregate plays by (default: 2, i.e. root groups)
+type statsHandler struct {
+	lib   *Library
+	users *Users
+}
+
+// statsCount is a play count for a path, along with the name of the group or track it
+// represents.
+type statsCount struct {
+	Path  index.Path `json:"path"`
+	Name  string     `json:"name,omitempty"`
+	Count int        `json:"count"`
+}
+
+type stats struct {
+	From   *time.Time     `json:"from,omitempty"`
+	To     *time.Time     `json:"to,omitempty"`
+	Plays  int            `json:"plays"`
+	Paths  int            `json:"paths"`
+	Top    []statsCount   `json:"top"`
+	Recent []statsCount   `json:"recent"`
+	Daily  map[string]int `json:"daily"`
+}
+
+// parseStatsTime parses the time value x, which can either be in RFC3339 format or a date
+// (YYYY-MM-DD, UTC).  An empty string returns the zero time.
+func parseStatsTime(x string) (time.Time, error) {
+	if x == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, x); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", x)
+}
+
+func parseStatsInt(x string, def int) (int, error) {
+	if x == "" {
+		return def, nil
+	}
+	return strconv.Atoi(x)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *statsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := s.users.RequestMeta(r)
+	if m == nil {
+		http.Error(w, "no data for user", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	from, err := parseStatsTime(q.Get("from"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid value for 'from': %v", err), http.StatusBadRequest)
+		return
+	}
+	to, err := parseStatsTime(q.Get("to"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid value for 'to': %v", err), http.StatusBadRequest)
+		return
+	}
+	n, err := parseStatsInt(q.Get("n"), 10)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid value for 'n': %v", err), http.StatusBadRequest)
+		return
+	}
+	depth, err := parseStatsInt(q.Get("depth"), 2)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid value for 'depth': %v", err), http.StatusBadRequest)
+		return
+	}
+
+	events := m.history.Range(from, to)
+	counts := history.Counts(events, depth)
+	pathCounts := make(map[string]int, len(counts))
+	for _, c := range counts {
+		pathCounts[c.Path.Encode()] = c.Count
+	}
+
+	result := stats{
+		Plays: len(events),
+		Paths: len(counts),
+		Daily: make(map[string]int),
+	}
+	if !from.IsZero() {
+		result.From = &from
+	}
+	if !to.IsZero() {
+		result.To = &to
+	}
+
+	root := index.RootGroups(s.lib.Collection("Root"))
+	for _, c := range history.Top(counts, n) {
+		result.Top = append(result.Top, statsCount{
+			Path:  c.Path,
+			Name:  pathName(root, c.Path),
+			Count: c.Count,
+		})
+	}
+	for _, p := range history.Recent(events, depth, n) {
+		result.Recent = append(result.Recent, statsCount{
+			Path:  p,
+			Name:  pathName(root, p),
+			Count: pathCounts[p.Encode()],
+		})
+	}
+	for _, e := range events {
+		result.Daily[e.Time.UTC().Format("2006-01-02")]++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// pathName returns the name of the group or track at the path p in the root collection, or
+// the empty string if there is no such group or track.
+func pathName(root index.Collection, p index.Path) string {
+	name, _ := migrate.Name(root, p)
+	return name
+}
@@ -0,0 +1,129 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dhowden/httpauth"
+)
+
+// Users is a set of user accounts, each of which has its own Meta.  When there are no
+// user accounts, all requests share the same Meta.
+type Users struct {
+	creds map[string]string // user -> password, nil if authentication is disabled
+	meta  map[string]*Meta  // user -> Meta, "" for the shared Meta
+}
+
+// loadUsers creates the Users using the credentials file (if set) or the single user
+// (if set).  When using a credentials file, the meta stores for each user are loaded from
+// a sub-directory of dir named after the user (created if it doesn't exist), otherwise
+// the meta stores are shared and loaded using the store flags.
+func loadUsers(credsFile, dir string) (*Users, error) {
+	if credsFile == "" {
+		m, err := loadMeta("")
+		if err != nil {
+			return nil, err
+		}
+
+		u := &Users{
+			meta: map[string]*Meta{"": m},
+		}
+		if authUser != "" {
+			u.creds = map[string]string{
+				authUser: authPassword,
+			}
+		}
+		return u, nil
+	}
+
+	creds, err := readCredentials(credsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(creds))
+	for user := range creds {
+		names = append(names, user)
+	}
+	sort.Strings(names)
+
+	u := &Users{
+		creds: creds,
+		meta:  make(map[string]*Meta, len(creds)),
+	}
+	for _, user := range names {
+		userDir := filepath.Join(dir, user)
+		err := os.MkdirAll(userDir, 0700)
+		if err != nil {
+			return nil, fmt.Errorf("error creating directory for user %v: %v", user, err)
+		}
+
+		fmt.Printf("Loading data for user %v:\n", user)
+		m, err := loadMeta(userDir)
+		if err != nil {
+			return nil, fmt.Errorf("error loading data for user %v: %v", user, err)
+		}
+		u.meta[user] = m
+	}
+	return u, nil
+}
+
+// readCredentials reads a credentials file: a JSON object which maps user names to
+// passwords.
+func readCredentials(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open credentials file: %v", err)
+	}
+	defer f.Close()
+
+	var creds map[string]string
+	err = json.NewDecoder(f).Decode(&creds)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing credentials file: %v", err)
+	}
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("credentials file %v does not contain any users", path)
+	}
+
+	for user, password := range creds {
+		if user == "" || user != filepath.Base(user) || user == "." || user == ".." {
+			return nil, fmt.Errorf("invalid user name in credentials file: %#v", user)
+		}
+		if password == "" {
+			return nil, fmt.Errorf("empty password for user %v in credentials file", user)
+		}
+	}
+	return creds, nil
+}
+
+// Checker returns the httpauth.Checker used to authenticate requests.
+func (u *Users) Checker() httpauth.Checker {
+	if u.creds == nil {
+		return httpauth.Skip
+	}
+	return httpauth.Creds(u.creds)
+}
+
+// Meta returns the Meta for the user.  When there are no user accounts the shared Meta is
+// returned for any user, otherwise nil is returned if the user doesn't exist.
+func (u *Users) Meta(user string) *Meta {
+	if m, ok := u.meta[user]; ok {
+		return m
+	}
+	return u.meta[""]
+}
+
+// RequestMeta returns the Meta for the user authenticated in the request (see Meta).
+func (u *Users) RequestMeta(r *http.Request) *Meta {
+	user, _, _ := r.BasicAuth()
+	return u.Meta(user)
+}
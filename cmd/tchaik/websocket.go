@@ -5,16 +5,21 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sort"
+	"time"
 
 	"golang.org/x/net/websocket"
 
 	"tchaik.com/index"
 	"tchaik.com/index/cursor"
+	"tchaik.com/index/history"
 	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
 	"tchaik.com/player"
 )
 
@@ -88,23 +93,62 @@ func (c Command) getPath(f string) (index.Path, error) {
 	return index.PathFromJSONInterface(raw)
 }
 
-// sameSearcher is a light wrapper around a index.Searher which caches the path
-// slice returned by Search and sets the attribute `same` to true when subsequent
+// getOptionalPath is like getPath, but returns a nil path (and no error) if f isn't in the
+// data map.
+func (c Command) getOptionalPath(f string) (index.Path, error) {
+	if _, ok := c.Data[f]; !ok {
+		return nil, nil
+	}
+	return c.getPath(f)
+}
+
+// checkRootPath returns an error if the path isn't in the "Root" collection: playlists,
+// cursors, the play queue and the meta stores only refer to paths in "Root" (see
+// index.RootGroups).
+func checkRootPath(p index.Path) error {
+	if len(p) > 0 && p[0] != "Root" {
+		return fmt.Errorf("invalid path %v: only paths in the Root collection can be used, not %v", p, p[0])
+	}
+	return nil
+}
+
+// getSmart returns the smart playlist rules in the field f, which is nil if the value
+// is null.
+func (c Command) getSmart(f string) (*playlist.Smart, error) {
+	raw, err := c.get(f)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var s playlist.Smart
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, fmt.Errorf("expected '%s' to be smart playlist rules: %v", f, err)
+	}
+	return &s, nil
+}
+
+// sameSearcher is a light wrapper around a index.Searher which caches the scored path
+// slice returned by SearchScored and sets the attribute `same` to true when subsequent
 // searches return the same result (and hence does not need to be re-transmitted).
 type sameSearcher struct {
 	index.Searcher
-	paths []index.Path
+	paths []index.ScoredPath
 	same  bool
 }
 
-// Search implements index.Searcher.
-func (r *sameSearcher) Search(input string) []index.Path {
-	paths := r.Searcher.Search(input)
+// SearchScored implements index.ScoredSearcher.
+func (r *sameSearcher) SearchScored(input string) []index.ScoredPath {
+	paths := index.SearchScored(r.Searcher, input)
 	r.same = false
 	if len(r.paths) == len(paths) {
 		r.same = true
 		for i, path := range r.paths {
-			if path[1] != paths[i][1] {
+			if path.Path[1] != paths[i].Path[1] || path.Score != paths[i].Score {
 				r.same = false
 				break
 			}
@@ -114,15 +158,22 @@ func (r *sameSearcher) Search(input string) []index.Path {
 	return paths
 }
 
+// Search implements index.Searcher.
+func (r *sameSearcher) Search(input string) []index.Path {
+	return index.ScoredPathsToPaths(r.SearchScored(input))
+}
+
 const (
 	// Player Actions
 	ActionKey    = "KEY"
 	ActionPlayer = "PLAYER"
+	ActionQueue  = "QUEUE"
 
 	// Path Actions
 	ActionRecordPlay   = "RECORD_PLAY"
 	ActionSetFavourite = "SET_FAVOURITE"
 	ActionSetChecklist = "SET_CHECKLIST"
+	ActionSetRating    = "SET_RATING"
 
 	// Playlist Actions
 	ActionPlaylist = "PLAYLIST"
@@ -137,6 +188,9 @@ const (
 	ActionFilterList    = "FILTER_LIST"
 	ActionFilterPaths   = "FILTER_PATHS"
 	ActionFetchPathList = "FETCH_PATHLIST"
+
+	// Notifications
+	ActionLibraryChanged = "LIBRARY_CHANGED"
 )
 
 type websocketHandlerFunc func(c Command, r *Response) error
@@ -158,9 +212,18 @@ func (w *websocketMux) Handle(c Command, r *Response) error {
 }
 
 // NewWebsocketHandler creates a websocket handler for the library, players and history.
-func NewWebsocketHandler(l Library, m *Meta, p *player.Players) http.Handler {
+// The history, favourites, checklist, ratings, playlists and cursors used by each connection are
+// those of the authenticated user.
+func NewWebsocketHandler(l *Library, u *Users, p *player.Players) http.Handler {
 	return websocket.Handler(func(ws *websocket.Conn) {
 		defer ws.Close()
+
+		m := u.RequestMeta(ws.Request())
+		if m == nil {
+			log.Printf("socket error: no data for user")
+			return
+		}
+
 		mux := &websocketMux{
 			m: make(map[string]websocketHandlerFunc),
 		}
@@ -172,15 +235,17 @@ func NewWebsocketHandler(l Library, m *Meta, p *player.Players) http.Handler {
 			meta:    m,
 			players: p,
 			searcher: &sameSearcher{
-				Searcher: l.searcher,
+				Searcher: l,
 			},
 		}
 
 		mux.HandleFunc(ActionKey, h.key)
 		mux.HandleFunc(ActionPlayer, h.player)
+		mux.HandleFunc(ActionQueue, h.queue)
 		mux.HandleFunc(ActionRecordPlay, h.recordPlay)
 		mux.HandleFunc(ActionSetFavourite, h.setFavourite)
 		mux.HandleFunc(ActionSetChecklist, h.setChecklist)
+		mux.HandleFunc(ActionSetRating, h.setRating)
 		mux.HandleFunc(ActionPlaylist, h.playlist)
 		mux.HandleFunc(ActionCursor, h.cursor)
 		mux.HandleFunc(ActionFetch, h.collectionList)
@@ -197,7 +262,7 @@ type websocketHandler struct {
 	*websocket.Conn
 	mux      *websocketMux
 	players  *player.Players
-	lib      Library
+	lib      *Library
 	searcher *sameSearcher
 	meta     *Meta
 
@@ -207,6 +272,9 @@ type websocketHandler struct {
 func (h *websocketHandler) handle() {
 	defer h.players.Remove(h.playerKey)
 
+	h.lib.AddListener(h, h.libraryChanged)
+	defer h.lib.RemoveListener(h)
+
 	var err error
 	for {
 		var c Command
@@ -243,6 +311,18 @@ func (h *websocketHandler) handle() {
 	}
 }
 
+// libraryChanged notifies the client that the library has changed, and so any cached
+// collections, filters, lists and search results are stale.
+func (h *websocketHandler) libraryChanged() {
+	err := websocket.JSON.Send(h.Conn, &Response{
+		Action: ActionLibraryChanged,
+		Data:   struct{}{},
+	})
+	if err != nil {
+		log.Printf("error sending library changed notification: %v", err)
+	}
+}
+
 // Response is a type which represnets a response to a Websocket Command.
 type Response struct {
 	Action string      `json:"action"`
@@ -277,6 +357,78 @@ func (h *websocketHandler) player(c Command, resp *Response) error {
 	return r.Apply(p)
 }
 
+var queueActions = map[string]player.Action{
+	"ADD":       player.QueueAdd,
+	"PLAY_NEXT": player.QueuePlayNext,
+	"CLEAR":     player.QueueClear,
+}
+
+// queue handles actions on the play queue of a player (by default the player of this
+// connection).  All actions respond with the queued paths.
+func (h *websocketHandler) queue(c Command, resp *Response) error {
+	action, err := c.getString("action")
+	if err != nil {
+		return err
+	}
+
+	key, _ := c.getString("key")
+	if key == "" {
+		key = h.playerKey
+	}
+	q := h.players.Queue(key)
+	if q == nil {
+		return fmt.Errorf("invalid player key: %v", key)
+	}
+
+	if action != "FETCH" {
+		a, ok := queueActions[action]
+		if !ok {
+			return fmt.Errorf("unknown queue action: %v", action)
+		}
+		path, err := c.getOptionalPath("path")
+		if err != nil {
+			return err
+		}
+		if err := checkRootPath(path); err != nil {
+			return err
+		}
+
+		err = player.QueueAction{Action: a, Path: path}.Apply(q)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp.Data = q
+	return nil
+}
+
+// nextQueued removes the next track from the play queue of this connection's player, and
+// returns its path.  Groups in the queue are replaced by their tracks.  Returns nil if the
+// queue is empty.
+func (h *websocketHandler) nextQueued(root index.Collection) index.Path {
+	q := h.players.Queue(h.playerKey)
+	if q == nil {
+		return nil
+	}
+
+	p, _ := q.Next(func(p index.Path) ([]index.Path, error) {
+		paths, err := playlist.TrackPaths(p, root)
+		if err != nil {
+			log.Printf("skipping invalid queued path %v: %v", p, err)
+		}
+		return paths, err
+	})
+	return p
+}
+
+// queuedCursor is the response to a cursor NEXT action when a track from the play queue is
+// to be played instead of advancing the cursor.
+type queuedCursor struct {
+	*cursor.Cursor
+	Queued index.Path `json:"queued"`
+}
+
 func (h *websocketHandler) key(c Command, resp *Response) error {
 	key, err := c.getString("key")
 	if err != nil {
@@ -296,6 +448,10 @@ func (h *websocketHandler) recordPlay(c Command, resp *Response) error {
 	if err != nil {
 		return err
 	}
+	if err := checkRootPath(p); err != nil {
+		return err
+	}
+	defer h.meta.invalidateSmartPlaylists()
 	return h.meta.history.Add(p)
 }
 
@@ -304,10 +460,14 @@ func (h *websocketHandler) setFavourite(c Command, resp *Response) error {
 	if err != nil {
 		return err
 	}
+	if err := checkRootPath(p); err != nil {
+		return err
+	}
 	value, err := c.getBool("value")
 	if err != nil {
 		return err
 	}
+	defer h.meta.invalidateSmartPlaylists()
 	return h.meta.favourites.Set(p, value)
 }
 
@@ -316,13 +476,37 @@ func (h *websocketHandler) setChecklist(c Command, resp *Response) error {
 	if err != nil {
 		return err
 	}
+	if err := checkRootPath(p); err != nil {
+		return err
+	}
 	value, err := c.getBool("value")
 	if err != nil {
 		return err
 	}
+	defer h.meta.invalidateSmartPlaylists()
 	return h.meta.checklist.Set(p, value)
 }
 
+func (h *websocketHandler) setRating(c Command, resp *Response) error {
+	p, err := c.getPath("path")
+	if err != nil {
+		return err
+	}
+	if err := checkRootPath(p); err != nil {
+		return err
+	}
+	value, err := c.getInt("value")
+	if err != nil {
+		return err
+	}
+	v := rating.Value(value)
+	if value < 0 || !v.IsValid() {
+		return fmt.Errorf("invalid rating value: %d", value)
+	}
+	defer h.meta.invalidateSmartPlaylists()
+	return h.meta.ratings.Set(p, v)
+}
+
 func (h *websocketHandler) cursor(c Command, resp *Response) error {
 	name, err := c.getString("name")
 	if err != nil {
@@ -334,25 +518,47 @@ func (h *websocketHandler) cursor(c Command, resp *Response) error {
 		return err
 	}
 
+	if action == "NEXT" {
+		// The play queue is drained before the cursor advances.
+		if p := h.nextQueued(index.RootGroups(h.lib.Collection("Root"))); p != nil {
+			resp.Data = queuedCursor{
+				Cursor: h.meta.cursors.Get(name).Copy(),
+				Queued: p,
+			}
+			return nil
+		}
+	}
+
 	if action != "FETCH" {
-		path, _ := c.getPath("path")
+		root := index.RootGroups(h.lib.Collection("Root"))
+		path, err := c.getOptionalPath("path")
+		if err != nil {
+			return err
+		}
+		if err := checkRootPath(path); err != nil {
+			return err
+		}
 		index, _ := c.getInt("index")
 
+		shuffle, _ := c.getString("shuffle")
+		repeat, _ := c.getString("repeat")
+
 		ra := cursor.RepAction{
-			Name:   name,
-			Action: cursor.Action(action),
-			Path:   path,
-			Index:  index,
+			Name:    name,
+			Action:  cursor.Action(action),
+			Path:    path,
+			Index:   index,
+			Shuffle: cursor.Shuffle(shuffle),
+			Repeat:  cursor.Repeat(repeat),
 		}
 
-		root := &rootCollection{h.lib.collections["Root"]}
 		err = ra.Apply(h.meta.cursors, h.meta.playlists, root)
 		if err != nil {
 			return err
 		}
 	}
 
-	resp.Data = h.meta.cursors.Get(name)
+	resp.Data = h.meta.cursors.Get(name).Copy()
 	return nil
 }
 
@@ -367,30 +573,92 @@ func (h *websocketHandler) playlist(c Command, resp *Response) error {
 		return err
 	}
 
+	root := index.RootGroups(h.lib.Collection("Root"))
 	if action != "FETCH" {
-		path, err := c.getPath("path")
-		if err != nil {
-			return err
-		}
-		index, _ := c.getInt("index")
-
 		ra := playlist.RepAction{
 			Name:   name,
 			Action: playlist.Action(action),
-			Path:   path,
-			Index:  index,
+		}
+
+		if action == "SET_SMART" {
+			ra.Smart, err = c.getSmart("smart")
+			if err != nil {
+				return err
+			}
+			h.meta.invalidateSmartPlaylists()
+		} else {
+			ra.Path, err = c.getOptionalPath("path")
+			if err != nil {
+				return err
+			}
+			if err := checkRootPath(ra.Path); err != nil {
+				return err
+			}
+			ra.Index, _ = c.getInt("index")
+			ra.To, _ = c.getInt("to")
+			ra.NewName, _ = c.getString("newName")
+		}
+
+		var before []*playlist.Item
+		if p := h.meta.playlists.Get(name); p != nil {
+			before = p.Items()
 		}
 
 		err = ra.Apply(h.meta.playlists)
 		if err != nil {
 			return err
 		}
+
+		err = h.syncCursor(action, name, ra.NewName, before, root)
+		if err != nil {
+			return err
+		}
+		if action == "RENAME" {
+			name = ra.NewName
+		}
 	}
 
+	err = h.meta.refreshSmartPlaylists(root)
+	if err != nil {
+		return err
+	}
 	resp.Data = h.meta.playlists.Get(name)
 	return nil
 }
 
+// syncCursor updates the cursor of the playlist name after the playlist action, so that it
+// stays on the same item when items are moved, and follows the playlist when it's renamed.
+func (h *websocketHandler) syncCursor(action, name, newName string, before []*playlist.Item, root index.Collection) error {
+	cur := h.meta.cursors.Get(name)
+	if cur == nil {
+		return nil
+	}
+
+	switch action {
+	case "DELETE":
+		return h.meta.cursors.Delete(name)
+	case "RENAME":
+		err := h.meta.cursors.Set(newName, cur)
+		if err != nil {
+			return err
+		}
+		return h.meta.cursors.Delete(name)
+	case "DUPLICATE":
+		return nil
+	}
+
+	p := h.meta.playlists.Get(name)
+	if p == nil {
+		return nil
+	}
+	err := cur.Sync(before, p, root)
+	err1 := h.meta.cursors.Set(name, cur)
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
 func (h *websocketHandler) collectionList(c Command, resp *Response) error {
 	p, err := c.getPath("path")
 	if err != nil {
@@ -422,7 +690,7 @@ func (h *websocketHandler) filterList(c Command, resp *Response) error {
 		return err
 	}
 
-	filter, ok := h.lib.filters[filterName]
+	filter, ok := h.lib.Filter(filterName)
 	if !ok {
 		return fmt.Errorf("invalid filter name: %#v", filterName)
 	}
@@ -453,7 +721,7 @@ func (h *websocketHandler) filterPaths(c Command, resp *Response) error {
 		return err
 	}
 
-	filter, ok := h.lib.filters[filterName]
+	filter, ok := h.lib.Filter(filterName)
 	if !ok {
 		return fmt.Errorf("invalid filter name: %#v", filterName)
 	}
@@ -508,6 +776,74 @@ func filterByRootLister(l Lister, paths []index.Path) []index.Path {
 	return result
 }
 
+// filterRootPaths returns the paths which refer to groups in the root collection (paths in
+// the play history may refer to groups which are no longer in the library).
+func filterRootPaths(root index.Collection, paths []index.Path) []index.Path {
+	result := make([]index.Path, 0, len(paths))
+	for _, p := range paths {
+		if len(p) == 2 && p[0] == "Root" && root.Get(p[1]) != nil {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// historyListSize is the maximum number of paths in the "mostPlayed" and "recentlyPlayed"
+// path lists.
+const historyListSize = 50
+
+// historySince returns the start of the time range covering the last n days, or the zero
+// time (i.e. all time) if n is zero or less.
+func historySince(n int) time.Time {
+	if n <= 0 {
+		return time.Time{}
+	}
+	return time.Now().UTC().AddDate(0, 0, -n)
+}
+
+// topRating is the minimum rating of paths in the "toprated" path list.
+const topRating rating.Value = 4
+
+// sortByRootRating filters paths to those which have a rating of at least min, and then
+// sorts them by decreasing rating (otherwise preserving the order of paths).  The rating of
+// a path is the highest rating of any of the rated paths with the same first two keys.
+func sortByRootRating(s rating.Store, paths []index.Path, min rating.Value) []index.Path {
+	ratings := make(map[string]rating.Value)
+	for _, p := range s.List() {
+		if len(p) > 1 {
+			k := fmt.Sprintf("%v", p[:2])
+			if v := s.Get(p); v > ratings[k] {
+				ratings[k] = v
+			}
+		}
+	}
+
+	result := make([]ratedPath, 0, len(paths))
+	for _, p := range paths {
+		if v := ratings[fmt.Sprintf("%v", p)]; v >= min {
+			result = append(result, ratedPath{p, v})
+		}
+	}
+	sort.Stable(ratedPaths(result))
+
+	out := make([]index.Path, len(result))
+	for i, x := range result {
+		out[i] = x.path
+	}
+	return out
+}
+
+type ratedPath struct {
+	path  index.Path
+	value rating.Value
+}
+
+type ratedPaths []ratedPath
+
+func (r ratedPaths) Len() int           { return len(r) }
+func (r ratedPaths) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r ratedPaths) Less(i, j int) bool { return r[i].value > r[j].value }
+
 func (h *websocketHandler) fetchPathList(c Command, resp *Response) error {
 	name, err := c.getString("name")
 	if err != nil {
@@ -517,15 +853,43 @@ func (h *websocketHandler) fetchPathList(c Command, resp *Response) error {
 	var paths []index.Path
 	switch name {
 	case "recent":
-		paths = h.lib.recent.List()
+		paths = h.lib.Recent()
 
 	case "favourite":
-		paths = index.CollectionPaths(h.lib.collections["Root"], []index.Key{"Root"})
+		paths = index.CollectionPaths(h.lib.Collection("Root"), []index.Key{"Root"})
 		paths = filterByRootLister(h.meta.favourites, paths)
 
 	case "checklist":
-		paths = index.CollectionPaths(h.lib.collections["Root"], []index.Key{"Root"})
+		paths = index.CollectionPaths(h.lib.Collection("Root"), []index.Key{"Root"})
 		paths = filterByRootLister(h.meta.checklist, paths)
+
+	case "rated":
+		paths = index.CollectionPaths(h.lib.Collection("Root"), []index.Key{"Root"})
+		paths = sortByRootRating(h.meta.ratings, paths, rating.None+1)
+
+	case "toprated":
+		paths = index.CollectionPaths(h.lib.Collection("Root"), []index.Key{"Root"})
+		paths = sortByRootRating(h.meta.ratings, paths, topRating)
+
+	case "mostPlayed":
+		days, _ := c.getInt("days")
+		counts := history.Counts(h.meta.history.Range(historySince(days), time.Time{}), 2)
+		paths = make([]index.Path, len(counts))
+		for i, x := range counts {
+			paths[i] = x.Path
+		}
+		paths = filterRootPaths(h.lib.Collection("Root"), paths)
+		if len(paths) > historyListSize {
+			paths = paths[:historyListSize]
+		}
+
+	case "recentlyPlayed":
+		days, _ := c.getInt("days")
+		paths = history.Recent(h.meta.history.Range(historySince(days), time.Time{}), 2, 0)
+		paths = filterRootPaths(h.lib.Collection("Root"), paths)
+		if len(paths) > historyListSize {
+			paths = paths[:historyListSize]
+		}
 	}
 
 	resp.Data = struct {
@@ -544,12 +908,12 @@ func (h *websocketHandler) search(c Command, resp *Response) error {
 		return err
 	}
 
-	paths := h.searcher.Search(input)
+	paths := h.searcher.SearchScored(input)
 	if h.searcher.same {
 		return nil
 	}
 
-	resp.Data = h.lib.ExpandPaths(paths)
+	resp.Data = h.lib.ExpandScoredPaths(paths)
 	return nil
 }
 
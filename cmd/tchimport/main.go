@@ -17,6 +17,31 @@ metadata will be added to the library.  Any errors are logged to stdout. As no o
 the SHA1 sum of the file path is used as the ID.
 
   tchimport -path <directory-path> -out lib.tch
+
+Rescanning a large directory tree can take a long time. To update an existing Tchaik library in place, only
+re-reading files which have been added or changed (by size or modification time) since the library was built,
+use -update.  Tracks whose files have been removed are dropped from the library, and a summary of the changes
+is written to stdout.
+
+  tchimport -path <directory-path> -out lib.tch -update
+
+The SHA1 sum of the file path changes whenever files are moved or renamed.  Use -content-id to use the SHA1 sum of the
+audio data of each file (ignoring metadata tags) as the ID instead.  This has to read the whole of each file, so is
+much slower.  When changing a library with -update, tracks are re-read if they were read with a different -content-id
+setting.
+
+  tchimport -path <directory-path> -out lib.tch -content-id
+
+Playlists, ratings, loved tracks and last plays from the iTunes Library can also be imported into the Tchaik
+meta data stores (playlists, ratings, favourites and play history) using -itl-meta.  The store flags default to
+the same values as tchaik (use -db to import into a database instead of JSON files).  Existing playlists with the
+same names are replaced.  iTunes only records the time of the last play of each track, so the iTunes play counts
+are kept in the library (as the ImportedPlayCount attribute) rather than in the play history.
+
+  tchimport -itlXML <itunes-library> -out lib.tch -itl-meta
+
+A search index for the library is also written alongside the library (lib.tch.idx), so that tchaik doesn't need
+to build one on startup.  Use -search-index=false to disable.
 */
 package main
 
@@ -32,11 +57,27 @@ import (
 
 var itlXML, path string
 var out string
+var update bool
+var contentID bool
+var searchIndex bool
+
+var itlMeta bool
+var dbPath, playHistoryPath, favouritesPath, ratingsPath, playlistPath string
 
 func init() {
 	flag.StringVar(&itlXML, "itlXML", "", "iTunes Music Library XML `file`")
 	flag.StringVar(&path, "path", "", "`directory` containing music files")
 	flag.StringVar(&out, "out", "", "output `file` (Tchaik library binary format)")
+	flag.BoolVar(&searchIndex, "search-index", true, "also write a search index for the library to <out>.idx")
+	flag.BoolVar(&contentID, "content-id", false, "use the SHA1 sum of the audio data of each file as the track ID (requires -path)")
+	flag.BoolVar(&update, "update", false, "update the library in -out in place, only reading new or changed files (requires -path)")
+
+	flag.BoolVar(&itlMeta, "itl-meta", false, "also import playlists, ratings, loved tracks and last plays into the meta stores (requires -itlXML)")
+	flag.StringVar(&dbPath, "db", "", "database `file` to import meta data into (instead of JSON files)")
+	flag.StringVar(&playHistoryPath, "play-history", "history.json", "play history `file`")
+	flag.StringVar(&favouritesPath, "favourites", "favourites.json", "favourites `file`")
+	flag.StringVar(&ratingsPath, "ratings", "ratings.json", "ratings `file`")
+	flag.StringVar(&playlistPath, "playlists", "playlists.json", "playlists `file`")
 }
 
 func main() {
@@ -52,13 +93,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	if update && path == "" {
+		fmt.Println("must specify -path when using -update, see -help for more details")
+		os.Exit(1)
+	}
+
+	if contentID && path == "" {
+		fmt.Println("must specify -path when using -content-id, see -help for more details")
+		os.Exit(1)
+	}
+
+	if itlMeta && itlXML == "" {
+		fmt.Println("must specify -itlXML when using -itl-meta, see -help for more details")
+		os.Exit(1)
+	}
+
 	var l index.Library
+	var meta *itl.Meta
 	var err error
 	switch {
 	case itlXML != "":
-		l, err = importXML(itlXML)
+		l, meta, err = importXML(itlXML)
+	case update:
+		l, err = updateLibrary(path)
 	case path != "":
-		l = walk.NewLibrary(path)
+		l = walk.NewLibrary(path, walk.Options{ContentID: contentID})
 	}
 
 	if err != nil {
@@ -66,32 +125,133 @@ func main() {
 		os.Exit(1)
 	}
 
-	err = writeLibrary(index.Convert(l, "ID"))
+	l = index.Convert(l, "ID")
+	err = writeLibrary(l)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+
+	if searchIndex {
+		err = writeSearchIndex(l)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if itlMeta {
+		err = importMeta(l, meta)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 }
 
+// writeSearchIndex builds the search index for the library and writes it alongside out.
+// The index is built from the same root collection as tchaik uses (tracks grouped by
+// album), and its checksum is computed from the library file (which must already have
+// been written), otherwise tchaik will rebuild it.
+func writeSearchIndex(l index.Library) error {
+	fi, err := os.Stat(out)
+	if err != nil {
+		return err
+	}
+
+	root := index.NewRootCollection(l)
+	si := index.BuildSearchIndex(root, index.LibraryFileSource(fi), index.SearchFields, index.SearchBoosts, index.SearchPrefixSize)
+
+	idx := out + ".idx"
+	tmp := idx + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = index.WriteSearchIndex(si, f)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, idx)
+}
+
+// writeLibrary writes the library to a temporary file alongside out, and then renames
+// it so that out is never left partially written.
 func writeLibrary(l index.Library) error {
-	f, err := os.Create(out)
+	tmp := out + ".tmp"
+	f, err := os.Create(tmp)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return index.WriteTo(l, f)
+
+	err = index.WriteTo(l, f)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, out)
 }
 
-func importXML(itlXML string) (index.Library, error) {
-	f, err := os.Open(itlXML)
+// updateLibrary reads the existing library from out (if it exists) and updates it
+// with the changes in the directory tree under path.
+func updateLibrary(path string) (index.Library, error) {
+	l, err := readLibrary(out)
 	if err != nil {
 		return nil, err
 	}
+
+	l, s := walk.Update(l, path, walk.Options{ContentID: contentID})
+	for _, x := range s.Added {
+		fmt.Printf("added: %v\n", x)
+	}
+	for _, x := range s.Changed {
+		fmt.Printf("changed: %v\n", x)
+	}
+	for _, x := range s.Removed {
+		fmt.Printf("removed: %v\n", x)
+	}
+	fmt.Printf("Completed: %d added, %d changed, %d removed.\n", len(s.Added), len(s.Changed), len(s.Removed))
+	return l, nil
+}
+
+// readLibrary reads the Tchaik library from the given file.  If the file does not exist
+// then an empty library is returned.
+func readLibrary(lib string) (index.Library, error) {
+	f, err := os.Open(lib)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index.Convert(emptyLibrary{}, "ID"), nil
+		}
+		return nil, fmt.Errorf("could not open Tchaik library file: %v", err)
+	}
 	defer f.Close()
 
-	l, err := itl.ReadFrom(f)
+	l, err := index.ReadFrom(f)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error parsing Tchaik library file: %v", err)
 	}
 	return l, nil
 }
+
+type emptyLibrary struct{}
+
+func (emptyLibrary) Tracks() []index.Track            { return nil }
+func (emptyLibrary) Track(string) (index.Track, bool) { return nil, false }
+
+func importXML(itlXML string) (index.Library, *itl.Meta, error) {
+	f, err := os.Open(itlXML)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return itl.ReadWithMeta(f)
+}
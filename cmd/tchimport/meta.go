@@ -0,0 +1,87 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"tchaik.com/index"
+	"tchaik.com/index/db"
+	"tchaik.com/index/favourite"
+	"tchaik.com/index/history"
+	"tchaik.com/index/itl"
+	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
+)
+
+// openStores opens the meta data stores given by the store flags.
+func openStores() (itl.Stores, func() error, error) {
+	var s itl.Stores
+	if dbPath != "" {
+		d, err := db.Open(dbPath)
+		if err != nil {
+			return s, nil, err
+		}
+		if s.History, err = d.HistoryStore(); err != nil {
+			d.Close()
+			return s, nil, fmt.Errorf("error loading play history: %v", err)
+		}
+		if s.Favourites, err = d.FavouriteStore(); err != nil {
+			d.Close()
+			return s, nil, fmt.Errorf("error loading favourites: %v", err)
+		}
+		if s.Ratings, err = d.RatingStore(); err != nil {
+			d.Close()
+			return s, nil, fmt.Errorf("error loading ratings: %v", err)
+		}
+		if s.Playlists, err = d.PlaylistStore(); err != nil {
+			d.Close()
+			return s, nil, fmt.Errorf("error loading playlists: %v", err)
+		}
+		return s, d.Close, nil
+	}
+
+	var err error
+	if s.History, err = history.NewStore(playHistoryPath); err != nil {
+		return s, nil, fmt.Errorf("error loading play history: %v", err)
+	}
+	if s.Favourites, err = favourite.NewStore(favouritesPath); err != nil {
+		return s, nil, fmt.Errorf("error loading favourites: %v", err)
+	}
+	if s.Ratings, err = rating.NewStore(ratingsPath); err != nil {
+		return s, nil, fmt.Errorf("error loading ratings: %v", err)
+	}
+	if s.Playlists, err = playlist.NewStore(playlistPath); err != nil {
+		return s, nil, fmt.Errorf("error loading playlists: %v", err)
+	}
+	return s, func() error { return nil }, nil
+}
+
+// importMeta imports the iTunes user data into the meta data stores, using the paths of the
+// tracks in the library l.
+func importMeta(l index.Library, m *itl.Meta) error {
+	s, closeFn, err := openStores()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Importing iTunes playlists, ratings, loved tracks and last plays...")
+	root := index.RootGroups(index.NewRootCollection(l))
+	sum, err := m.Import(root, s)
+	if err1 := closeFn(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		fmt.Println()
+		return err
+	}
+	fmt.Println("done.")
+
+	fmt.Printf("Imported %d ratings, %d favourites, %d last plays and %d playlists.\n", sum.Ratings, sum.Favourites, sum.Plays, sum.Playlists)
+	if sum.Missing > 0 {
+		fmt.Printf("Skipped %d tracks which aren't in the library.\n", sum.Missing)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+tchmigrate is a tool which imports the JSON files used by tchaik to store play history, favourites, checklist,
+ratings, playlists and cursors into a database (see the -db option of tchaik).
+
+The file flags default to the same values as tchaik, and any files which don't exist are skipped.  Existing values
+in the database with the same paths (or names) are overwritten.
+
+  tchmigrate -db tchaik.db
+
+When using multiple users (see the -users option of tchaik) run tchmigrate in each user's directory.
+
+  tchmigrate -db users/alice/tchaik.db -play-history users/alice/history.json ...
+
+The stores refer to tracks and groups by their paths in the library, which change when files are moved or albums are
+renamed.  Given the library from before the changes (-old-lib) and the updated library (-lib), tchmigrate rewrites
+the paths in each of the stores (the JSON files, or the database if -db is set) so that they refer to the same tracks
+and groups in the updated library.  Tracks are matched by ID, or by location if their ID has changed (see the
+-content-id option of tchimport).  Paths which can't be matched are left unchanged and listed on stdout.
+
+  tchmigrate -old-lib lib.tch.old -lib lib.tch
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"tchaik.com/index/db"
+)
+
+var dbPath string
+var playHistoryPath, favouritesPath, checklistPath, ratingsPath, playlistPath, cursorPath string
+var oldLibPath, libPath string
+
+func init() {
+	flag.StringVar(&dbPath, "db", "", "database `file` to import into (created if it doesn't exist)")
+	flag.StringVar(&oldLibPath, "old-lib", "", "Tchaik library `file` which the paths in the stores refer to (requires -lib)")
+	flag.StringVar(&libPath, "lib", "", "Tchaik library `file` to remap the paths in the stores to (requires -old-lib)")
+
+	flag.StringVar(&playHistoryPath, "play-history", "history.json", "play history `file`")
+	flag.StringVar(&favouritesPath, "favourites", "favourites.json", "favourites `file`")
+	flag.StringVar(&checklistPath, "checklist", "checklist.json", "checklist `file`")
+	flag.StringVar(&ratingsPath, "ratings", "ratings.json", "ratings `file`")
+	flag.StringVar(&playlistPath, "playlists", "playlists.json", "playlists `file`")
+	flag.StringVar(&cursorPath, "cursors", "cursors.json", "cursors `file`")
+}
+
+func main() {
+	flag.Parse()
+
+	if (oldLibPath == "") != (libPath == "") {
+		fmt.Println("must specify both -old-lib and -lib, see -help for more details")
+		os.Exit(1)
+	}
+
+	if libPath != "" {
+		var d *db.DB
+		if dbPath != "" {
+			var err error
+			d, err = db.Open(dbPath)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		err := remap(d, oldLibPath, libPath)
+		if d != nil {
+			d.Close()
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if dbPath == "" {
+		fmt.Println("must specify -db, see -help for more details")
+		os.Exit(1)
+	}
+
+	d, err := db.Open(dbPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer d.Close()
+
+	imports := []struct {
+		name, path, bucket string
+	}{
+		{"play history", playHistoryPath, db.HistoryBucket},
+		{"favourites", favouritesPath, db.FavouriteBucket},
+		{"checklist", checklistPath, db.ChecklistBucket},
+		{"ratings", ratingsPath, db.RatingBucket},
+		{"playlists", playlistPath, db.PlaylistBucket},
+		{"cursors", cursorPath, db.CursorBucket},
+	}
+
+	for _, x := range imports {
+		if x.path == "" {
+			continue
+		}
+		err := importFile(d, x.path, x.bucket)
+		if err != nil {
+			if os.IsNotExist(err) {
+				fmt.Printf("Skipping %v: %v does not exist.\n", x.name, x.path)
+				continue
+			}
+			fmt.Printf("error importing %v from %v: %v\n", x.name, x.path, err)
+			d.Close()
+			os.Exit(1)
+		}
+	}
+}
+
+func importFile(d *db.DB, path, bucket string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("Importing %v...", path)
+	n, err := d.Import(bucket, f)
+	if err != nil {
+		fmt.Println()
+		return err
+	}
+	fmt.Printf("done (%d values).\n", n)
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"tchaik.com/index"
+	"tchaik.com/index/db"
+	"tchaik.com/index/migrate"
+)
+
+// readRoot reads the Tchaik library from the file and returns its root collection (the one
+// used to create paths for the meta stores).
+func readRoot(path string) (index.Collection, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open Tchaik library file: %v", err)
+	}
+	defer f.Close()
+
+	l, err := index.ReadFrom(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Tchaik library file %v: %v", path, err)
+	}
+	return index.RootGroups(index.NewRootCollection(l)), nil
+}
+
+// storeFiles returns the paths of the JSON files given by the store flags.
+func storeFiles() migrate.Files {
+	return migrate.Files{
+		History:    playHistoryPath,
+		Favourites: favouritesPath,
+		Checklist:  checklistPath,
+		Ratings:    ratingsPath,
+		Playlists:  playlistPath,
+		Cursors:    cursorPath,
+	}
+}
+
+// remap rewrites the paths in all the meta stores (in the database d, or the JSON files if
+// d is nil) so that they refer to the same tracks and groups in the library newLib as they
+// did in oldLib.
+func remap(d *db.DB, oldLib, newLib string) error {
+	from, err := readRoot(oldLib)
+	if err != nil {
+		return err
+	}
+	to, err := readRoot(newLib)
+	if err != nil {
+		return err
+	}
+	m := migrate.NewPaths(from, to)
+
+	fmt.Printf("Remapping paths...")
+	var s *migrate.Stores
+	if d != nil {
+		s, err = migrate.ReadDB(d)
+	} else {
+		s, err = migrate.ReadFiles(storeFiles())
+	}
+	if err != nil {
+		fmt.Println()
+		return err
+	}
+
+	s.Rewrite(m.Rewrite)
+	if d != nil {
+		err = s.WriteDB(d)
+	} else {
+		err = s.WriteFiles(storeFiles())
+	}
+	if err != nil {
+		fmt.Println()
+		return err
+	}
+	fmt.Println("done.")
+
+	sum := m.Summary()
+	fmt.Printf("Completed: %d paths changed, %d paths not found in %v.\n", sum.Changed, len(sum.Missing), newLib)
+	for _, p := range sum.Missing {
+		fmt.Printf("not found: %v\n", p)
+	}
+	return nil
+}
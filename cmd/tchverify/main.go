@@ -9,6 +9,17 @@ the associated media file exists.
 All configuration is done through command line parameters, see --help flag for details.
 
 NB: this tool cannot verify the content of remote stores, it only supports local stores.
+
+With -meta, tchverify instead checks the play history, favourites, checklist, ratings, playlists
+and cursors (the JSON files, or the database if -db is set) for paths which no longer exist in
+the library, i.e. after albums have been renamed or tracks removed.  Given the library which the
+paths were created with (-old-lib), the best-guess replacement for each path is found by
+matching tracks (see tchmigrate), or otherwise by fuzzy matching the names of its groups and
+tracks.  Without -old-lib no replacements can be found, so paths can only be listed (or pruned).
+Use -rewrite (which requires -old-lib) to replace paths with their best-guess replacements, and
+-prune to remove paths which can't be replaced.
+
+  tchverify -lib lib.tch -meta -old-lib lib.tch.old -rewrite
 */
 package main
 
@@ -25,23 +36,59 @@ import (
 var itlXML, tchLib string
 var trimPathPrefix, addPathPrefix string
 
+var meta, rewrite, prune bool
+var oldLibPath string
+var playHistoryPath, favouritesPath, checklistPath, ratingsPath, playlistPath, cursorPath string
+var dbPath string
+
 func init() {
 	flag.StringVar(&itlXML, "itlXML", "", "iTunes Library XML `file`")
 	flag.StringVar(&tchLib, "lib", "", "Tchaik library `file`")
 
 	flag.StringVar(&trimPathPrefix, "trim-path-prefix", "", "remove `prefix` from every path")
 	flag.StringVar(&addPathPrefix, "add-path-prefix", "", "add `prefix` to every path")
+
+	flag.BoolVar(&meta, "meta", false, "check the paths in the meta stores instead of the media files")
+	flag.StringVar(&oldLibPath, "old-lib", "", "Tchaik library `file` which the paths in the meta stores were created with (required to find replacements)")
+	flag.BoolVar(&rewrite, "rewrite", false, "replace paths which don't exist with their best-guess replacement (requires -meta and -old-lib)")
+	flag.BoolVar(&prune, "prune", false, "remove paths which don't exist and can't be replaced (requires -meta)")
+	flag.StringVar(&playHistoryPath, "play-history", "history.json", "play history `file`")
+	flag.StringVar(&favouritesPath, "favourites", "favourites.json", "favourites `file`")
+	flag.StringVar(&checklistPath, "checklist", "checklist.json", "checklist `file`")
+	flag.StringVar(&ratingsPath, "ratings", "ratings.json", "ratings `file`")
+	flag.StringVar(&playlistPath, "playlists", "playlists.json", "playlists `file`")
+	flag.StringVar(&cursorPath, "cursors", "cursors.json", "cursors `file`")
+	flag.StringVar(&dbPath, "db", "", "database `file` containing the meta stores (instead of JSON files)")
 }
 
 func main() {
 	flag.Parse()
 
+	if (rewrite || prune) && !meta {
+		fmt.Println("must specify -meta when using -rewrite or -prune, see -help for more details")
+		os.Exit(1)
+	}
+
+	if rewrite && oldLibPath == "" {
+		fmt.Println("must specify -old-lib when using -rewrite (replacements can't be found without it), see -help for more details")
+		os.Exit(1)
+	}
+
 	l, err := readLibrary()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	if meta {
+		err = verifyMeta(l)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	tracks := l.Tracks()
 	fmt.Printf("Checking %d tracks...\n", len(tracks))
 
@@ -0,0 +1,148 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"tchaik.com/index"
+	"tchaik.com/index/db"
+	"tchaik.com/index/migrate"
+)
+
+// storeFiles returns the paths of the JSON files given by the store flags.
+func storeFiles() migrate.Files {
+	return migrate.Files{
+		History:    playHistoryPath,
+		Favourites: favouritesPath,
+		Checklist:  checklistPath,
+		Ratings:    ratingsPath,
+		Playlists:  playlistPath,
+		Cursors:    cursorPath,
+	}
+}
+
+// readStores reads the meta stores from the database (if -db is set) or the JSON files.
+func readStores() (*migrate.Stores, *db.DB, error) {
+	if dbPath != "" {
+		d, err := db.Open(dbPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		s, err := migrate.ReadDB(d)
+		if err != nil {
+			d.Close()
+			return nil, nil, err
+		}
+		return s, d, nil
+	}
+
+	s, err := migrate.ReadFiles(storeFiles())
+	return s, nil, err
+}
+
+// writeStores writes the meta stores back to the database d (if non-nil) or the JSON files.
+func writeStores(s *migrate.Stores, d *db.DB) error {
+	if d != nil {
+		return s.WriteDB(d)
+	}
+	return s.WriteFiles(storeFiles())
+}
+
+// readOldRoot reads the library given by -old-lib (if set) and returns its root collection.
+func readOldRoot() (index.Collection, error) {
+	if oldLibPath == "" {
+		return nil, nil
+	}
+	f, err := os.Open(oldLibPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open Tchaik library file: %v", err)
+	}
+	defer f.Close()
+
+	l, err := index.ReadFrom(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Tchaik library file: %v", err)
+	}
+	return index.RootGroups(index.NewRootCollection(l)), nil
+}
+
+// verifyMeta lists the paths used by the meta stores which don't exist in the library l,
+// with the best-guess replacement for each (when -old-lib is set), and rewrites or prunes
+// them if -rewrite or -prune are set.
+func verifyMeta(l index.Library) error {
+	root := index.RootGroups(index.NewRootCollection(l))
+	from, err := readOldRoot()
+	if err != nil {
+		return err
+	}
+	var m *migrate.Paths
+	if from != nil {
+		m = migrate.NewPaths(from, root)
+	}
+
+	s, d, err := readStores()
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		defer d.Close()
+	}
+
+	orphans := s.Orphans(root)
+	fmt.Printf("Found %d path(s) which don't exist in the library.\n", len(orphans))
+	if from == nil && len(orphans) > 0 {
+		fmt.Println("No replacements can be suggested without -old-lib.")
+	}
+
+	replacements := make(map[string]index.Path)
+	for _, o := range orphans {
+		name := ""
+		if from != nil {
+			name, _ = migrate.Name(from, o.Path)
+		}
+		if m != nil {
+			if np, ok := m.Guess(o.Path); ok {
+				replacements[o.Path.Encode()] = np
+				newName, _ := migrate.Name(root, np)
+				fmt.Printf("%v: %v (%q) -> %v (%q)\n", o.Store, o.Path, name, np, newName)
+				continue
+			}
+		}
+		if name != "" {
+			fmt.Printf("%v: %v (%q) -> no replacement found\n", o.Store, o.Path, name)
+			continue
+		}
+		fmt.Printf("%v: %v -> no replacement found\n", o.Store, o.Path)
+	}
+
+	if !rewrite && !prune || len(orphans) == 0 {
+		return nil
+	}
+
+	var rewritten, pruned int
+	s.Rewrite(func(p index.Path) (index.Path, bool) {
+		if len(p) == 0 || migrate.Exists(root, p) {
+			return p, true
+		}
+		if np, ok := replacements[p.Encode()]; ok && rewrite {
+			rewritten++
+			return np, true
+		}
+		if prune {
+			pruned++
+			return nil, false
+		}
+		return p, true
+	})
+
+	err = writeStores(s, d)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Completed: %d path(s) rewritten, %d path(s) pruned.\n", rewritten, pruned)
+	return nil
+}
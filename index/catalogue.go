@@ -0,0 +1,219 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Catalogue is a catalogue number of a work, i.e. BWV 1007 or Op. 27 No. 2.
+type Catalogue struct {
+	// System is the catalogue: "BWV", "K.", "Hob.", "RV", "D." or "Op.".
+	System string
+
+	// Number is the catalogue number.  For Hob. the first value is the group (a roman
+	// numeral), for Op. the second value (if any) is the number within the opus.
+	Number []int
+
+	// Suffix is the letter suffix of the first value of Number, i.e. "a" in K. 331a.
+	Suffix string
+}
+
+// String returns the canonical representation of the catalogue number.
+func (c Catalogue) String() string {
+	if len(c.Number) == 0 {
+		return ""
+	}
+	switch {
+	case c.System == "Hob." && len(c.Number) > 1:
+		return fmt.Sprintf("Hob. %v%v:%d", toNumeral(c.Number[0]), c.Suffix, c.Number[1])
+	case c.System == "Op." && len(c.Number) > 1:
+		return fmt.Sprintf("Op. %d%v No. %d", c.Number[0], c.Suffix, c.Number[1])
+	}
+	return fmt.Sprintf("%v %d%v", c.System, c.Number[0], c.Suffix)
+}
+
+// Less returns true if c is ordered before d: by system, and then by number.
+func (c Catalogue) Less(d Catalogue) bool {
+	if c.System != d.System {
+		return c.System < d.System
+	}
+	for i := 0; i < len(c.Number) && i < len(d.Number); i++ {
+		if c.Number[i] != d.Number[i] {
+			return c.Number[i] < d.Number[i]
+		}
+		if i == 0 && c.Suffix != d.Suffix {
+			return c.Suffix < d.Suffix
+		}
+	}
+	return len(c.Number) < len(d.Number)
+}
+
+// catalogueParser is a pattern which matches a catalogue number of a system.  Groups of
+// the pattern are the first number, its suffix and (optionally) a second number.
+type catalogueParser struct {
+	system string
+	re     *regexp.Regexp
+	first  parseFn
+
+	// key is true if the system could be mistaken for a key name (i.e. "Suite in D 1."),
+	// in which case matches which follow "in" are ignored, and matches without a period
+	// must have a catalogue-sized (at least two digit) number.
+	key bool
+}
+
+// catalogueParsers is the list of catalogue patterns, in order of precedence: the
+// catalogue of a composer is used in preference to opus numbers.
+var catalogueParsers = []catalogueParser{
+	{"BWV", regexp.MustCompile(`(?i)\bBWV\.?\s*(\d+)([a-z]?)\b`), parseUInt, false},
+	{"K.", regexp.MustCompile(`\bK(?:V|\.)?\s*(\d+)([a-z]?)\b`), parseUInt, false},
+	{"Hob.", regexp.MustCompile(`(?i)\bHob\.?\s*([IVXL]+)([a-z]?)\s*[:.]?\s*(\d+)\b`), parseNumeral, false},
+	{"RV", regexp.MustCompile(`\bRV\.?\s*(\d+)([a-z]?)\b`), parseUInt, false},
+	{"D.", regexp.MustCompile(`\bD\.?\s*(\d+)([a-z]?)\b`), parseUInt, true},
+	{"Op.", regexp.MustCompile(`(?i)\bOp(?:us|\.)?\s*(\d+)([a-z]?)(?:,?\s*No\.?\s*(\d+))?\b`), parseUInt, false},
+}
+
+// ParseCatalogue extracts the catalogue number from the string (i.e. a track or album
+// name).  Returns false if the string doesn't contain a recognised catalogue number.
+func ParseCatalogue(s string) (Catalogue, bool) {
+	for _, p := range catalogueParsers {
+		for _, m := range p.re.FindAllStringSubmatchIndex(s, -1) {
+			if c, ok := p.parse(s, m); ok {
+				return c, true
+			}
+		}
+	}
+	return Catalogue{}, false
+}
+
+// keyPrefix matches the end of a string which could be followed by a key name.
+var keyPrefix = regexp.MustCompile(`(?i)\bin\s*$`)
+
+// parse returns the catalogue number of the match m (submatch indices) in s.
+func (p catalogueParser) parse(s string, m []int) (Catalogue, bool) {
+	group := func(i int) string {
+		if m[2*i] < 0 {
+			return ""
+		}
+		return s[m[2*i]:m[2*i+1]]
+	}
+
+	if p.key {
+		if keyPrefix.MatchString(s[:m[0]]) {
+			return Catalogue{}, false
+		}
+		if !strings.Contains(group(0), ".") && len(group(1)) < 2 {
+			return Catalogue{}, false
+		}
+	}
+
+	n, err := p.first(group(1))
+	if err != nil {
+		return Catalogue{}, false
+	}
+	c := Catalogue{
+		System: p.system,
+		Number: []int{int(n)},
+		Suffix: group(2),
+	}
+	if len(m) > 6 && group(3) != "" {
+		x, err := strconv.Atoi(group(3))
+		if err != nil {
+			return Catalogue{}, false
+		}
+		c.Number = append(c.Number, x)
+	}
+	return c, true
+}
+
+// numerals are the values and symbols used to write roman numerals.
+var numerals = []struct {
+	n int
+	s string
+}{
+	{50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+}
+
+// toNumeral returns the upper-case roman numeral for n (which is assumed to be less than
+// 90, as used in the Hob. catalogue).
+func toNumeral(n int) string {
+	var s string
+	for _, x := range numerals {
+		for n >= x.n {
+			s += x.s
+			n -= x.n
+		}
+	}
+	return s
+}
+
+// catalogueTrack is a wrapper around a Track which adds the attributes "Catalogue" (the
+// canonical catalogue number, i.e. "BWV 1007"), "CatalogueSystem" (i.e. "BWV") and
+// "CatalogueNumber" (the first number, i.e. 1007) parsed from its name or album name (see
+// ParseCatalogue).  The attributes are zero values if the track doesn't have a catalogue
+// number.
+type catalogueTrack struct {
+	Track
+	c  Catalogue
+	ok bool
+}
+
+// GetString implements Track.
+func (t *catalogueTrack) GetString(k string) string {
+	switch k {
+	case "Catalogue":
+		return t.c.String()
+	case "CatalogueSystem":
+		return t.c.System
+	}
+	return t.Track.GetString(k)
+}
+
+// GetInt implements Track.
+func (t *catalogueTrack) GetInt(k string) int {
+	if k == "CatalogueNumber" {
+		if !t.ok {
+			return 0
+		}
+		return t.c.Number[0]
+	}
+	return t.Track.GetInt(k)
+}
+
+// catalogue returns the catalogue number of the track, and false if it doesn't have one.
+func catalogue(t Track) (Catalogue, bool) {
+	if ct, ok := t.(*catalogueTrack); ok {
+		return ct.c, ct.ok
+	}
+	if c, ok := ParseCatalogue(t.GetString("Name")); ok {
+		return c, true
+	}
+	return ParseCatalogue(t.GetString("Album"))
+}
+
+// catalogueTracks returns the tracks with catalogue attributes added (see catalogueTrack).
+func catalogueTracks(tracks []Track) []Track {
+	result := make([]Track, len(tracks))
+	for i, t := range tracks {
+		c, ok := catalogue(t)
+		result[i] = &catalogueTrack{Track: t, c: c, ok: ok}
+	}
+	return result
+}
+
+// SortByCatalogue is a LessFn which orders tracks by their catalogue number (see
+// ParseCatalogue and Catalogue.Less).  Tracks with a catalogue number are ordered before
+// those without.
+func SortByCatalogue(s, t Track) bool {
+	sc, sok := catalogue(s)
+	tc, tok := catalogue(t)
+	if !sok || !tok {
+		return sok && !tok
+	}
+	return sc.Less(tc)
+}
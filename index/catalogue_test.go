@@ -0,0 +1,79 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCatalogue(t *testing.T) {
+	tests := []struct {
+		in  string
+		out Catalogue
+		str string
+		ok  bool
+	}{
+		{"Cello Suite No. 1 in G major, BWV 1007: I. Prélude", Catalogue{"BWV", []int{1007}, ""}, "BWV 1007", true},
+		{"Mass in B minor, bwv232", Catalogue{"BWV", []int{232}, ""}, "BWV 232", true},
+		{"Eine kleine Nachtmusik, K. 525", Catalogue{"K.", []int{525}, ""}, "K. 525", true},
+		{"Piano Sonata No. 11, KV 331a", Catalogue{"K.", []int{331}, "a"}, "K. 331a", true},
+		{"Piano Sonata in C major, Hob. XVI:50", Catalogue{"Hob.", []int{16, 50}, ""}, "Hob. XVI:50", true},
+		{"The Four Seasons: Spring, RV 269", Catalogue{"RV", []int{269}, ""}, "RV 269", true},
+		{"Piano Sonata in B-flat, D. 960", Catalogue{"D.", []int{960}, ""}, "D. 960", true},
+		{"Piano Sonata No. 14, Op. 27, No. 2", Catalogue{"Op.", []int{27, 2}, ""}, "Op. 27 No. 2", true},
+		{"Symphony No. 5 in C minor, op.67", Catalogue{"Op.", []int{67}, ""}, "Op. 67", true},
+		{"Sonata in D, K. 311", Catalogue{"K.", []int{311}, ""}, "K. 311", true},
+		{"Symphony in D major", Catalogue{}, "", false},
+		{"Suite in D 1. Overture", Catalogue{}, "", false},
+		{"Suite in D. 2. Air", Catalogue{}, "", false},
+		{"Trumpet Concerto in D 2 movements", Catalogue{}, "", false},
+		{"Erlkönig, D 328", Catalogue{"D.", []int{328}, ""}, "D. 328", true},
+		{"Impromptu in G-flat, D. 899 No. 3", Catalogue{"D.", []int{899}, ""}, "D. 899", true},
+		{"Dance D 1", Catalogue{}, "", false},
+		{"Top 40", Catalogue{}, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseCatalogue(tt.in)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("ParseCatalogue(%#v) = (%#v, %v), expected: (%#v, %v)", tt.in, got, ok, tt.out, tt.ok)
+		}
+		if s := got.String(); s != tt.str {
+			t.Errorf("ParseCatalogue(%#v).String() = %#v, expected: %#v", tt.in, s, tt.str)
+		}
+	}
+}
+
+func TestSortByCatalogue(t *testing.T) {
+	tracks := []Track{
+		testTrack{Name: "Sonata"},
+		testTrack{Name: "Cello Suite No. 2, BWV 1008"},
+		testTrack{Name: "Toccata and Fugue, BWV 565"},
+		testTrack{Name: "Prelude", Album: "Cello Suite No. 1, BWV 1007"},
+		testTrack{Name: "Serenade, K. 525"},
+	}
+	Sort(tracks, SortByCatalogue)
+
+	var got []string
+	for _, x := range tracks {
+		got = append(got, x.GetString("Name"))
+	}
+	expected := []string{"Toccata and Fugue, BWV 565", "Prelude", "Cello Suite No. 2, BWV 1008", "Serenade, K. 525", "Sonata"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Sort(tracks, SortByCatalogue) = %#v, expected: %#v", got, expected)
+	}
+
+	tracks = catalogueTracks(tracks)
+	if got := tracks[1].GetString("Catalogue"); got != "BWV 1007" {
+		t.Errorf("GetString(\"Catalogue\") = %#v, expected: %#v", got, "BWV 1007")
+	}
+	if got := tracks[1].GetInt("CatalogueNumber"); got != 1007 {
+		t.Errorf("GetInt(\"CatalogueNumber\") = %d, expected: %d", got, 1007)
+	}
+	if got := tracks[4].GetString("CatalogueSystem"); got != "" {
+		t.Errorf("GetString(\"CatalogueSystem\") = %#v, expected: %#v", got, "")
+	}
+}
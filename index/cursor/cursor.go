@@ -7,7 +7,9 @@ package cursor
 
 import (
 	"fmt"
+	"math/rand"
 	"sync"
+	"time"
 
 	"tchaik.com/index"
 	"tchaik.com/index/playlist"
@@ -24,14 +26,63 @@ func (p Position) Empty() bool {
 	return len(p.Path) == 0
 }
 
+// Shuffle is a type which represents the shuffle mode of a cursor.
+type Shuffle string
+
+// Shuffle modes.
+const (
+	ShuffleOff    Shuffle = ""
+	ShuffleTracks Shuffle = "tracks" // play the tracks in a random order
+	ShuffleGroups Shuffle = "groups" // play the groups (i.e. works, albums) in a random order
+)
+
+// Valid returns true iff the Shuffle is a valid mode.
+func (s Shuffle) Valid() bool {
+	return s == ShuffleOff || s == ShuffleTracks || s == ShuffleGroups
+}
+
+// Repeat is a type which represents the repeat mode of a cursor.
+type Repeat string
+
+// Repeat modes.
+const (
+	RepeatOff      Repeat = ""
+	RepeatPlaylist Repeat = "playlist" // repeat the playlist when the last track has played
+	RepeatGroup    Repeat = "group"    // repeat the group containing the current track
+)
+
+// Valid returns true iff the Repeat is a valid mode.
+func (r Repeat) Valid() bool {
+	return r == RepeatOff || r == RepeatPlaylist || r == RepeatGroup
+}
+
+// rnd is the source of random numbers for shuffling.
+var rnd = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func perm(n int) []int {
+	rnd.Lock()
+	defer rnd.Unlock()
+	return rnd.Perm(n)
+}
+
 // Cursor is a moveable marker on a playlist.
 type Cursor struct {
-	sync.Mutex // protects Current, Next and Previous
+	sync.Mutex // protects Current, Next, Previous, Shuffle, Repeat and Order
 
 	Current  Position `json:"current"`
 	Next     Position `json:"next"`
 	Previous Position `json:"previous"`
 
+	Shuffle Shuffle `json:"shuffle,omitempty"`
+	Repeat  Repeat  `json:"repeat,omitempty"`
+
+	// Order is the shuffled play order of the playlist (empty if the cursor isn't
+	// shuffled).
+	Order []Position `json:"order,omitempty"`
+
 	p *playlist.Playlist
 	c index.Collection
 }
@@ -45,15 +96,96 @@ func NewCursor(p *playlist.Playlist, c index.Collection) *Cursor {
 	}
 }
 
-// Set sets the value of the playlist cursor to the current position and index.Path.
-func (c *Cursor) Set(i int, p index.Path) {
+// Copy returns a copy of the cursor, taken under its lock (i.e. so that the copy can be
+// marshalled while the cursor is in use).  The copy shares the playlist and collection of
+// the cursor.
+func (c *Cursor) Copy() *Cursor {
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return &Cursor{
+		Current:  c.Current,
+		Next:     c.Next,
+		Previous: c.Previous,
+		Shuffle:  c.Shuffle,
+		Repeat:   c.Repeat,
+		Order:    append([]Position(nil), c.Order...),
+		p:        c.p,
+		c:        c.c,
+	}
+}
+
+// Set sets the value of the playlist cursor to the current position and index.Path.  Returns
+// an error if the next or previous positions could not be found.
+func (c *Cursor) Set(i int, p index.Path) error {
 	c.Lock()
+	defer c.Unlock()
+
 	c.Current = Position{Index: i, Path: p}
-	c.Next, _ = c.next(c.Current)
-	c.Previous, _ = c.prev(c.Current)
+	c.Order = nil
+	return c.update()
+}
+
+// SetMode sets the shuffle and repeat modes of the cursor.  Changing the shuffle mode
+// creates a new play order starting from the current track.
+func (c *Cursor) SetMode(s Shuffle, r Repeat) error {
+	if !s.Valid() {
+		return fmt.Errorf("invalid shuffle mode: %v", s)
+	}
+	if !r.Valid() {
+		return fmt.Errorf("invalid repeat mode: %v", r)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if s != c.Shuffle {
+		c.Order = nil
+	}
+	c.Shuffle = s
+	c.Repeat = r
+	return c.update()
+}
+
+// attach sets the playlist and collection used by the cursor (which aren't persisted).
+func (c *Cursor) attach(p *playlist.Playlist, col index.Collection) {
+	c.Lock()
+	c.p = p
+	c.c = col
 	c.Unlock()
 }
 
+// update recomputes the Next and Previous positions, and the play order if the cursor is
+// shuffled.  Returns the first error encountered, leaving the positions which could not be
+// computed empty.  Assumes that the lock is held.
+func (c *Cursor) update() error {
+	c.Next = Position{}
+	c.Previous = Position{}
+	if c.Current.Empty() {
+		return nil
+	}
+
+	if c.Shuffle != ShuffleOff && indexOfPosition(c.Order, c.Current) == -1 {
+		order, err := c.shuffle()
+		if err != nil {
+			return err
+		}
+		c.Order = order
+	}
+
+	var err, err1 error
+	c.Next, err = c.next(c.Current)
+	c.Previous, err1 = c.prev(c.Current)
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
 // Forward moves the cursor forwards.  Returns an error if the next track could not be found,
 // and sets the Next item to be empty.
 func (c *Cursor) Forward() (err error) {
@@ -84,6 +216,91 @@ func (c *Cursor) Backward() (err error) {
 	return
 }
 
+// Sync updates the cursor after the items of its playlist have been changed, where before
+// is the list of items before the change.  The cursor follows the current item to its new
+// index, and is cleared if the item has been removed from the playlist.  A shuffled play
+// order is kept (see syncOrder).  The playlist and collection replace those used by the
+// cursor.
+func (c *Cursor) Sync(before []*playlist.Item, p *playlist.Playlist, col index.Collection) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.p = p
+	c.c = col
+
+	if c.Current.Empty() {
+		return nil
+	}
+
+	n := -1
+	if c.Current.Index >= 0 && c.Current.Index < len(before) {
+		item := before[c.Current.Index]
+		for i, x := range p.Items() {
+			if x == item {
+				n = i
+				break
+			}
+		}
+	}
+
+	if n == -1 {
+		c.Current = Position{}
+		c.Next = Position{}
+		c.Previous = Position{}
+		c.Order = nil
+		return nil
+	}
+
+	c.Current.Index = n
+	if c.Shuffle != ShuffleOff {
+		order, err := c.syncOrder(before)
+		if err != nil {
+			return err
+		}
+		c.Order = order
+	}
+	return c.update()
+}
+
+// syncOrder returns the play order with its positions moved to the new indices of their
+// items, where before is the list of items before the change.  Positions which are no longer
+// in the playlist are dropped, and new positions are appended in playlist order.  Assumes
+// that the lock is held.
+func (c *Cursor) syncOrder(before []*playlist.Item) ([]Position, error) {
+	positions, err := c.positions()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[*playlist.Item]int)
+	for i, x := range c.p.Items() {
+		items[x] = i
+	}
+
+	order := make([]Position, 0, len(positions))
+	for _, x := range c.Order {
+		if x.Index < 0 || x.Index >= len(before) {
+			continue
+		}
+		n, ok := items[before[x.Index]]
+		if !ok {
+			continue
+		}
+		x.Index = n
+		if indexOfPosition(positions, x) == -1 {
+			continue
+		}
+		order = append(order, x)
+	}
+
+	for _, x := range positions {
+		if indexOfPosition(order, x) == -1 {
+			order = append(order, x)
+		}
+	}
+	return order, nil
+}
+
 func (c *Cursor) paths(n int) ([]index.Path, error) {
 	items := c.p.Items()
 	item := items[n]
@@ -98,7 +315,119 @@ func (c *Cursor) pathIndex(p Position) ([]index.Path, int, error) {
 	return paths, index.IndexOfPath(paths, p.Path), nil
 }
 
+// positions returns all the positions in the playlist, in order.
+func (c *Cursor) positions() ([]Position, error) {
+	if c.p == nil {
+		return nil, fmt.Errorf("cursor has no playlist")
+	}
+
+	var result []Position
+	for n := range c.p.Items() {
+		paths, err := c.paths(n)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			result = append(result, Position{Path: p, Index: n})
+		}
+	}
+	return result, nil
+}
+
+// indexOfPosition returns the index of p in positions, or -1 if it isn't present.
+func indexOfPosition(positions []Position, p Position) int {
+	for i, x := range positions {
+		if x.Index == p.Index && x.Path.Equal(p.Path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameGroup returns true if the positions are in the same playlist item and group.
+func sameGroup(p, q Position) bool {
+	if p.Index != q.Index || len(p.Path) != len(q.Path) || len(p.Path) == 0 {
+		return false
+	}
+	return p.Path[:len(p.Path)-1].Equal(q.Path[:len(q.Path)-1])
+}
+
+// shuffle returns a new play order for the playlist starting with the current position (or
+// its group when shuffling groups).  When shuffling groups, the tracks in each group are
+// kept in order.
+func (c *Cursor) shuffle() ([]Position, error) {
+	positions, err := c.positions()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups [][]Position
+	for i, x := range positions {
+		if c.Shuffle == ShuffleGroups && i > 0 && sameGroup(positions[i-1], x) {
+			groups[len(groups)-1] = append(groups[len(groups)-1], x)
+			continue
+		}
+		groups = append(groups, []Position{x})
+	}
+
+	order := make([]Position, 0, len(positions))
+	for _, n := range perm(len(groups)) {
+		g := groups[n]
+		if indexOfPosition(g, c.Current) != -1 {
+			order = append(append([]Position{}, g...), order...)
+			continue
+		}
+		order = append(order, g...)
+	}
+	return order, nil
+}
+
+// order returns the play order of the playlist.
+func (c *Cursor) order() ([]Position, error) {
+	if c.Shuffle != ShuffleOff {
+		return c.Order, nil
+	}
+	return c.positions()
+}
+
+// step returns the position d steps (1 or -1) from p in the play order, honouring the
+// repeat mode.
+func (c *Cursor) step(p Position, d int) (Position, error) {
+	order, err := c.order()
+	if err != nil {
+		return Position{}, err
+	}
+
+	i := indexOfPosition(order, p)
+	if i == -1 {
+		return Position{}, fmt.Errorf("didn't find path: %v", p.Path)
+	}
+
+	if c.Repeat == RepeatGroup {
+		for j := 1; j <= len(order); j++ {
+			x := order[(i+d*j+len(order))%len(order)]
+			if sameGroup(x, p) {
+				return x, nil
+			}
+		}
+		return p, nil
+	}
+
+	i += d
+	if i < 0 || i >= len(order) {
+		if c.Repeat != RepeatPlaylist {
+			return Position{}, nil
+		}
+		i = (i + len(order)) % len(order)
+	}
+	return order[i], nil
+}
+
 func (c *Cursor) next(p Position) (Position, error) {
+	if c.Shuffle != ShuffleOff || c.Repeat != RepeatOff {
+		return c.step(p, 1)
+	}
+
 	paths, i, err := c.pathIndex(p)
 	if err != nil {
 		return Position{}, err
@@ -130,6 +459,10 @@ func (c *Cursor) next(p Position) (Position, error) {
 }
 
 func (c *Cursor) prev(p Position) (Position, error) {
+	if c.Shuffle != ShuffleOff || c.Repeat != RepeatOff {
+		return c.step(p, -1)
+	}
+
 	paths, i, err := c.pathIndex(p)
 	if err != nil {
 		return Position{}, err
@@ -0,0 +1,253 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cursor
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"tchaik.com/index"
+	"tchaik.com/index/attr"
+	"tchaik.com/index/indextest"
+	"tchaik.com/index/playlist"
+)
+
+var testTracks = indextest.Library{
+	{Name: "A1", Album: "A"},
+	{Name: "A2", Album: "A"},
+	{Name: "A3", Album: "A"},
+	{Name: "B1", Album: "B"},
+	{Name: "B2", Album: "B"},
+	{Name: "C1", Album: "C"},
+}
+
+// testCursor returns a cursor on a playlist with an item for each album (ordered A, B, C),
+// and the collection.
+func testCursor() (*Cursor, index.Collection) {
+	c := index.Collect(testTracks, index.By(attr.String("Album")))
+
+	keys := make(map[string]index.Key)
+	for _, k := range c.Keys() {
+		keys[c.Get(k).Name()] = k
+	}
+
+	p := &playlist.Playlist{}
+	for _, x := range []string{"A", "B", "C"} {
+		p.Add(index.Path{"Root", keys[x]})
+	}
+	return NewCursor(p, c), c
+}
+
+// trackName returns the name of the track at the position.
+func trackName(t *testing.T, c index.Collection, p Position) string {
+	if p.Empty() {
+		return ""
+	}
+	g, err := index.GroupFromPath(c, p.Path[1:len(p.Path)-1])
+	if err != nil {
+		t.Fatalf("unexpected error from GroupFromPath: %v", err)
+	}
+	i, err := strconv.Atoi(string(p.Path[len(p.Path)-1]))
+	if err != nil {
+		t.Fatalf("unexpected error parsing track index: %v", err)
+	}
+	return g.Tracks()[i].GetString("Name")
+}
+
+// play moves the cursor forward n times, and returns the names of the tracks (including the
+// initial track).
+func play(t *testing.T, cur *Cursor, c index.Collection, n int) []string {
+	names := []string{trackName(t, c, cur.Current)}
+	for i := 0; i < n; i++ {
+		if err := cur.Forward(); err != nil {
+			t.Fatalf("unexpected error from Forward: %v", err)
+		}
+		names = append(names, trackName(t, c, cur.Current))
+	}
+	return names
+}
+
+// start sets the cursor to the first track of item n.
+func start(t *testing.T, cur *Cursor, n int) {
+	paths, err := playlist.Paths(cur.p.Items()[n], cur.c)
+	if err != nil {
+		t.Fatalf("unexpected error from Paths: %v", err)
+	}
+	if err := cur.Set(n, paths[0]); err != nil {
+		t.Fatalf("unexpected error from Set: %v", err)
+	}
+}
+
+// orderNames returns the names of the tracks in the play order of the cursor.
+func orderNames(t *testing.T, cur *Cursor, c index.Collection) []string {
+	var names []string
+	for _, p := range cur.Order {
+		names = append(names, trackName(t, c, p))
+	}
+	return names
+}
+
+func TestCursorRepeatGroup(t *testing.T) {
+	cur, c := testCursor()
+	start(t, cur, 1)
+	if err := cur.SetMode(ShuffleOff, RepeatGroup); err != nil {
+		t.Fatalf("unexpected error from SetMode: %v", err)
+	}
+
+	got := play(t, cur, c, 4)
+	expected := []string{"B1", "B2", "B1", "B2", "B1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("play() = %v, expected: %v", got, expected)
+	}
+
+	if err := cur.Backward(); err != nil {
+		t.Fatalf("unexpected error from Backward: %v", err)
+	}
+	if name := trackName(t, c, cur.Current); name != "B2" {
+		t.Errorf("Backward() current = %v, expected: %v", name, "B2")
+	}
+}
+
+func TestCursorRepeatPlaylist(t *testing.T) {
+	cur, c := testCursor()
+	start(t, cur, 2)
+	if err := cur.SetMode(ShuffleOff, RepeatPlaylist); err != nil {
+		t.Fatalf("unexpected error from SetMode: %v", err)
+	}
+
+	got := play(t, cur, c, 2)
+	expected := []string{"C1", "A1", "A2"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("play() = %v, expected: %v", got, expected)
+	}
+}
+
+func TestCursorShuffleGroups(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		cur, c := testCursor()
+		start(t, cur, 1)
+		if err := cur.SetMode(ShuffleGroups, RepeatOff); err != nil {
+			t.Fatalf("unexpected error from SetMode: %v", err)
+		}
+
+		got := play(t, cur, c, 5)
+		if !cur.Next.Empty() {
+			t.Errorf("expected no next track after the last track, got: %v", trackName(t, c, cur.Next))
+		}
+		if got[0] != "B1" || got[1] != "B2" {
+			t.Errorf("play() = %v, expected to start with current group", got)
+		}
+
+		// Tracks in each album must be played together and in order.
+		rest := got[2:]
+		if !reflect.DeepEqual(rest, []string{"A1", "A2", "A3", "C1"}) && !reflect.DeepEqual(rest, []string{"C1", "A1", "A2", "A3"}) {
+			t.Errorf("play() = %v, expected albums to be kept together", got)
+		}
+	}
+}
+
+func TestCursorShuffleTracks(t *testing.T) {
+	cur, c := testCursor()
+	start(t, cur, 0)
+	if err := cur.SetMode(ShuffleTracks, RepeatPlaylist); err != nil {
+		t.Fatalf("unexpected error from SetMode: %v", err)
+	}
+
+	got := play(t, cur, c, 6)
+	if got[0] != "A1" || got[6] != "A1" {
+		t.Errorf("play() = %v, expected to start and end with A1", got)
+	}
+	seen := make(map[string]bool)
+	for _, x := range got[:6] {
+		seen[x] = true
+	}
+	if len(seen) != len(testTracks) {
+		t.Errorf("play() = %v, expected to play all tracks once", got)
+	}
+}
+
+func TestCursorSetModeInvalid(t *testing.T) {
+	cur, _ := testCursor()
+	if err := cur.SetMode("random", RepeatOff); err == nil {
+		t.Errorf("expected error for invalid shuffle mode")
+	}
+	if err := cur.SetMode(ShuffleOff, "forever"); err == nil {
+		t.Errorf("expected error for invalid repeat mode")
+	}
+}
+
+func TestCursorSync(t *testing.T) {
+	cur, c := testCursor()
+	start(t, cur, 1)
+
+	before := cur.p.Items()
+	if err := cur.p.Move(1, 0); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	if err := cur.Sync(before, cur.p, c); err != nil {
+		t.Fatalf("unexpected error from Sync: %v", err)
+	}
+	if cur.Current.Index != 0 {
+		t.Errorf("Current.Index = %d, expected: %d", cur.Current.Index, 0)
+	}
+	if name := trackName(t, c, cur.Next); name != "B2" {
+		t.Errorf("Next = %v, expected: %v", name, "B2")
+	}
+
+	before = cur.p.Items()
+	cur.p.Clear()
+	if err := cur.Sync(before, cur.p, c); err != nil {
+		t.Fatalf("unexpected error from Sync: %v", err)
+	}
+	if !cur.Current.Empty() || !cur.Next.Empty() {
+		t.Errorf("expected cursor to be cleared, got: %#v", cur.Current)
+	}
+}
+
+func TestCursorSyncShuffled(t *testing.T) {
+	cur, c := testCursor()
+	start(t, cur, 0)
+	if err := cur.SetMode(ShuffleTracks, RepeatOff); err != nil {
+		t.Fatalf("unexpected error from SetMode: %v", err)
+	}
+	order := orderNames(t, cur, c)
+
+	before := cur.p.Items()
+	if err := cur.p.Move(2, 0); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	if err := cur.Sync(before, cur.p, c); err != nil {
+		t.Fatalf("unexpected error from Sync: %v", err)
+	}
+	if got := orderNames(t, cur, c); !reflect.DeepEqual(got, order) {
+		t.Errorf("Order = %v, expected: %v", got, order)
+	}
+
+	// Remove album B (now at index 2).
+	before = cur.p.Items()
+	paths, err := playlist.Paths(before[2], c)
+	if err != nil {
+		t.Fatalf("unexpected error from Paths: %v", err)
+	}
+	if err := cur.p.Remove(2, paths[0][:2]); err != nil {
+		t.Fatalf("unexpected error from Remove: %v", err)
+	}
+	if err := cur.Sync(before, cur.p, c); err != nil {
+		t.Fatalf("unexpected error from Sync: %v", err)
+	}
+	var expected []string
+	for _, x := range order {
+		if x != "B1" && x != "B2" {
+			expected = append(expected, x)
+		}
+	}
+	if got := orderNames(t, cur, c); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Order = %v, expected: %v", got, expected)
+	}
+	if name := trackName(t, c, cur.Current); name != "A1" {
+		t.Errorf("Current = %v, expected: %v", name, "A1")
+	}
+}
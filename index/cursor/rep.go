@@ -17,19 +17,23 @@ const (
 	ActionSet      Action = "set"
 	ActionNext            = "next"
 	ActionPrevious        = "previous"
+	ActionSetMode         = "setMode"
 )
 
 type RepAction struct {
-	Name   string     `json:"name"`
-	Action Action     `json:"action"`
-	Path   index.Path `json:"path"`
-	Index  int        `json:"index"`
+	Name    string     `json:"name"`
+	Action  Action     `json:"action"`
+	Path    index.Path `json:"path"`
+	Index   int        `json:"index"`
+	Shuffle Shuffle    `json:"shuffle"`
+	Repeat  Repeat     `json:"repeat"`
 }
 
 var actionToAction = map[string]Action{
-	"SET":  ActionSet,
-	"NEXT": ActionNext,
-	"PREV": ActionPrevious,
+	"SET":      ActionSet,
+	"NEXT":     ActionNext,
+	"PREV":     ActionPrevious,
+	"SET_MODE": ActionSetMode,
 }
 
 func (a RepAction) Apply(s Store, ps playlist.Store, collection index.Collection) error {
@@ -45,8 +49,18 @@ func (a RepAction) Apply(s Store, ps playlist.Store, collection index.Collection
 		}
 
 		c := NewCursor(p, collection)
-		c.Set(a.Index, a.Path)
-		return s.Set(a.Name, c)
+		if old := s.Get(a.Name); old != nil {
+			// Keep the play modes of the existing cursor.
+			old.Lock()
+			c.Shuffle, c.Repeat = old.Shuffle, old.Repeat
+			old.Unlock()
+		}
+		err := c.Set(a.Index, a.Path)
+		err1 := s.Set(a.Name, c)
+		if err == nil {
+			err = err1
+		}
+		return err
 	}
 
 	c := s.Get(a.Name)
@@ -54,8 +68,16 @@ func (a RepAction) Apply(s Store, ps playlist.Store, collection index.Collection
 		return fmt.Errorf("invalid cursor name: %v", a.Name)
 	}
 
+	// The playlist and collection aren't persisted, so cursors loaded from the store
+	// won't have them.
+	if p := ps.Get(a.Name); p != nil {
+		c.attach(p, collection)
+	}
+
 	var err error
 	switch action {
+	case ActionSetMode:
+		err = c.SetMode(a.Shuffle, a.Repeat)
 	case ActionPrevious:
 		err = c.Backward()
 	case ActionNext:
@@ -0,0 +1,213 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package db implements the history, favourite, checklist, rating, playlist and cursor
+// stores using an embedded bbolt (BoltDB) database.
+//
+// Each store is kept in its own bucket, with one key for each path (or name) and the value
+// encoded as JSON (the same encoding as the values in the JSON file stores, see Import).
+// Stores are read into memory when created, and each change is written to the database in
+// its own transaction, so only the changed key is rewritten.
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names for each of the stores.
+const (
+	HistoryBucket   = "history"
+	FavouriteBucket = "favourites"
+	ChecklistBucket = "checklist"
+	RatingBucket    = "ratings"
+	PlaylistBucket  = "playlists"
+	CursorBucket    = "cursors"
+)
+
+// DB is a database containing the stores.
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens the database at path, creating it if it doesn't exist.
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening database %v: %v", path, err)
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// bucket returns the named bucket, creating it if it doesn't exist.
+func (d *DB) bucket(name string) (*bucket, error) {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating bucket %v: %v", name, err)
+	}
+	return &bucket{
+		db:   d.db,
+		name: []byte(name),
+	}, nil
+}
+
+// Import reads a JSON object from r and writes each of its values into the named bucket
+// (overwriting any existing values with the same keys) in a single transaction.  This can
+// be used to import the files written by the JSON file stores (i.e. history.NewStore,
+// favourite.NewStore etc).  Returns the number of values imported.
+func (d *DB) Import(name string, r io.Reader) (int, error) {
+	return d.write(name, r, false)
+}
+
+// Replace is like Import, but removes all the existing values in the bucket first.
+func (d *DB) Replace(name string, r io.Reader) (int, error) {
+	return d.write(name, r, true)
+}
+
+// ReplaceAll is like Replace, but replaces each of the named buckets (with the JSON object
+// read from the corresponding io.Reader) in a single transaction, so that either all or none
+// of the buckets are replaced.
+func (d *DB) ReplaceAll(m map[string]io.Reader) error {
+	values := make(map[string]map[string]json.RawMessage, len(m))
+	for name, r := range m {
+		v, err := decodeObject(r)
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		values[name] = v
+	}
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		for name, v := range values {
+			if err := writeBucket(tx, name, v, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// write reads a JSON object from r and writes each of its values into the named bucket in a
+// single transaction, first removing the existing values if clear is true.
+func (d *DB) write(name string, r io.Reader, clear bool) (int, error) {
+	m, err := decodeObject(r)
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		return writeBucket(tx, name, m, clear)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(m), nil
+}
+
+// decodeObject reads a JSON object from r.
+func decodeObject(r io.Reader) (map[string]json.RawMessage, error) {
+	var m map[string]json.RawMessage
+	err := json.NewDecoder(r).Decode(&m)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error decoding JSON: %v", err)
+	}
+	return m, nil
+}
+
+// writeBucket writes each of the values in m into the named bucket, first removing the
+// existing values if clear is true.
+func writeBucket(tx *bolt.Tx, name string, m map[string]json.RawMessage, clear bool) error {
+	if clear {
+		err := tx.DeleteBucket([]byte(name))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	for k, v := range m {
+		err = b.Put([]byte(k), v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export writes the values in the named bucket to w as a JSON object, in the same format as
+// the files written by the JSON file stores (see Import).  Returns the number of values
+// exported.
+func (d *DB) Export(name string, w io.Writer) (int, error) {
+	m := make(map[string]json.RawMessage)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			m[string(k)] = json.RawMessage(append([]byte(nil), v...))
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(m), json.NewEncoder(w).Encode(m)
+}
+
+// bucket is a helper type for reading and writing JSON-encoded values in a bucket.
+type bucket struct {
+	db   *bolt.DB
+	name []byte
+}
+
+// load decodes each of the values in the bucket using the function v, which is called
+// with the key and should return a pointer to decode the value into, and then calls done
+// (if non-nil) with the key.
+func (b *bucket) load(v func(k string) interface{}, done func(k string)) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).ForEach(func(k, data []byte) error {
+			err := json.Unmarshal(data, v(string(k)))
+			if err != nil {
+				return fmt.Errorf("error decoding %s/%s: %v", b.name, k, err)
+			}
+			if done != nil {
+				done(string(k))
+			}
+			return nil
+		})
+	})
+}
+
+// put writes the JSON-encoded value with the given key.
+func (b *bucket) put(k string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).Put([]byte(k), data)
+	})
+}
+
+// delete removes the key.
+func (b *bucket) delete(k string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).Delete([]byte(k))
+	})
+}
@@ -0,0 +1,233 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tchaik.com/index"
+	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
+)
+
+func tempDB(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "tchaik-db")
+	if err != nil {
+		t.Fatalf("unexpected error creating temporary directory: %v", err)
+	}
+	return filepath.Join(dir, "tchaik.db"), func() { os.RemoveAll(dir) }
+}
+
+func TestStoresPersist(t *testing.T) {
+	path, cleanup := tempDB(t)
+	defer cleanup()
+
+	p := index.Path{"Root", "a1b2c3"}
+	q := index.Path{"Root", "d4e5f6"}
+
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error from Open: %v", err)
+	}
+
+	hs, err := d.HistoryStore()
+	if err != nil {
+		t.Fatalf("unexpected error from HistoryStore: %v", err)
+	}
+	hs.Add(p)
+	hs.Add(p)
+
+	fs, err := d.FavouriteStore()
+	if err != nil {
+		t.Fatalf("unexpected error from FavouriteStore: %v", err)
+	}
+	fs.Set(p, true)
+	fs.Set(q, true)
+	fs.Set(q, false)
+
+	rs, err := d.RatingStore()
+	if err != nil {
+		t.Fatalf("unexpected error from RatingStore: %v", err)
+	}
+	rs.Set(q, 4)
+
+	ps, err := d.PlaylistStore()
+	if err != nil {
+		t.Fatalf("unexpected error from PlaylistStore: %v", err)
+	}
+	pl := &playlist.Playlist{}
+	pl.Add(p)
+	ps.Set("Default", pl)
+	ps.Set("Other", &playlist.Playlist{})
+	ps.Delete("Other")
+	d.Close()
+
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error from Open: %v", err)
+	}
+	defer d.Close()
+
+	hs, _ = d.HistoryStore()
+	if got := len(hs.Get(p)); got != 2 {
+		t.Errorf("len(history.Get(%v)) = %d, expected: 2", p, got)
+	}
+
+	fs, _ = d.FavouriteStore()
+	if got := fs.List(); !reflect.DeepEqual(got, []index.Path{p}) {
+		t.Errorf("favourites.List() = %v, expected: %v", got, []index.Path{p})
+	}
+
+	rs, _ = d.RatingStore()
+	if got := rs.Get(q); got != rating.Value(4) {
+		t.Errorf("ratings.Get(%v) = %d, expected: 4", q, got)
+	}
+
+	ps, _ = d.PlaylistStore()
+	if got := ps.Names(); !reflect.DeepEqual(got, []string{"Default"}) {
+		t.Errorf("playlists.Names() = %v, expected: %v", got, []string{"Default"})
+	}
+	if got := len(ps.Get("Default").Items()); got != 1 {
+		t.Errorf("len(playlists.Get(\"Default\").Items()) = %d, expected: 1", got)
+	}
+}
+
+func TestImport(t *testing.T) {
+	path, cleanup := tempDB(t)
+	defer cleanup()
+
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error from Open: %v", err)
+	}
+	defer d.Close()
+
+	n, err := d.Import(ChecklistBucket, strings.NewReader(`{"Root:a1b2c3":true,"Root:d4e5f6":false}`))
+	if err != nil {
+		t.Fatalf("unexpected error from Import: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Import() = %d, expected: 2", n)
+	}
+
+	cs, err := d.ChecklistStore()
+	if err != nil {
+		t.Fatalf("unexpected error from ChecklistStore: %v", err)
+	}
+	if !cs.Get(index.Path{"Root", "a1b2c3"}) {
+		t.Errorf("expected Root:a1b2c3 to be in the checklist")
+	}
+	if cs.Get(index.Path{"Root", "d4e5f6"}) {
+		t.Errorf("expected Root:d4e5f6 not to be in the checklist")
+	}
+
+	_, err = d.Import(HistoryBucket, strings.NewReader(`[1, 2]`))
+	if err == nil {
+		t.Errorf("expected error from Import of invalid JSON object")
+	}
+}
+
+func TestExportReplace(t *testing.T) {
+	path, cleanup := tempDB(t)
+	defer cleanup()
+
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error from Open: %v", err)
+	}
+	defer d.Close()
+
+	_, err = d.Import(FavouriteBucket, strings.NewReader(`{"Root:a1b2c3":true,"Root:d4e5f6":true}`))
+	if err != nil {
+		t.Fatalf("unexpected error from Import: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := d.Export(FavouriteBucket, buf)
+	if err != nil {
+		t.Fatalf("unexpected error from Export: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Export() = %d, expected: 2", n)
+	}
+	if got, expected := strings.TrimSpace(buf.String()), `{"Root:a1b2c3":true,"Root:d4e5f6":true}`; got != expected {
+		t.Errorf("Export() wrote %v, expected: %v", got, expected)
+	}
+
+	_, err = d.Replace(FavouriteBucket, strings.NewReader(`{"Root:g7h8i9":true}`))
+	if err != nil {
+		t.Fatalf("unexpected error from Replace: %v", err)
+	}
+
+	fs, err := d.FavouriteStore()
+	if err != nil {
+		t.Fatalf("unexpected error from FavouriteStore: %v", err)
+	}
+	if got := fs.List(); len(got) != 1 || !got[0].Equal(index.Path{"Root", "g7h8i9"}) {
+		t.Errorf("favourites.List() = %v, expected: %v", got, []index.Path{{"Root", "g7h8i9"}})
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	path, cleanup := tempDB(t)
+	defer cleanup()
+
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error from Open: %v", err)
+	}
+	defer d.Close()
+
+	_, err = d.Import(FavouriteBucket, strings.NewReader(`{"Root:a1b2c3":true}`))
+	if err != nil {
+		t.Fatalf("unexpected error from Import: %v", err)
+	}
+
+	// An invalid object for one bucket must leave all the buckets unchanged.
+	err = d.ReplaceAll(map[string]io.Reader{
+		FavouriteBucket: strings.NewReader(`{"Root:d4e5f6":true}`),
+		ChecklistBucket: strings.NewReader(`[1, 2]`),
+	})
+	if err == nil {
+		t.Errorf("expected error from ReplaceAll of invalid JSON object")
+	}
+	buf := &bytes.Buffer{}
+	if _, err := d.Export(FavouriteBucket, buf); err != nil {
+		t.Fatalf("unexpected error from Export: %v", err)
+	}
+	if got, expected := strings.TrimSpace(buf.String()), `{"Root:a1b2c3":true}`; got != expected {
+		t.Errorf("Export() after failed ReplaceAll wrote %v, expected: %v", got, expected)
+	}
+
+	err = d.ReplaceAll(map[string]io.Reader{
+		FavouriteBucket: strings.NewReader(`{"Root:d4e5f6":true}`),
+		ChecklistBucket: strings.NewReader(`{"Root:g7h8i9":true}`),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error from ReplaceAll: %v", err)
+	}
+
+	for _, x := range []struct {
+		bucket, expected string
+	}{
+		{FavouriteBucket, `{"Root:d4e5f6":true}`},
+		{ChecklistBucket, `{"Root:g7h8i9":true}`},
+	} {
+		buf := &bytes.Buffer{}
+		if _, err := d.Export(x.bucket, buf); err != nil {
+			t.Fatalf("unexpected error from Export: %v", err)
+		}
+		if got := strings.TrimSpace(buf.String()); got != x.expected {
+			t.Errorf("Export(%q) wrote %v, expected: %v", x.bucket, got, x.expected)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/checklist"
+	"tchaik.com/index/cursor"
+	"tchaik.com/index/favourite"
+	"tchaik.com/index/history"
+	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
+)
+
+// HistoryStore creates a history.Store which uses the database.
+func (d *DB) HistoryStore() (history.Store, error) {
+	b, err := d.bucket(HistoryBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &historyStore{
+		m: make(map[string][]time.Time),
+		b: b,
+	}
+	var v []time.Time
+	err = b.load(func(string) interface{} {
+		v = nil
+		return &v
+	}, func(k string) {
+		s.m[k] = v
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type historyStore struct {
+	sync.RWMutex
+
+	m map[string][]time.Time
+	b *bucket
+}
+
+// Add implements history.Store.
+func (s *historyStore) Add(p index.Path) error {
+	s.Lock()
+	defer s.Unlock()
+
+	k := fmt.Sprintf("%v", p)
+	times := append(s.m[k], time.Now().UTC())
+	if err := s.b.put(k, times); err != nil {
+		return err
+	}
+	s.m[k] = times
+	return nil
+}
+
+// AddTimes implements history.Store.
+func (s *historyStore) AddTimes(p index.Path, times []time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	k := fmt.Sprintf("%v", p)
+	times = history.MergeTimes(s.m[k], times)
+	if err := s.b.put(k, times); err != nil {
+		return err
+	}
+	s.m[k] = times
+	return nil
+}
+
+// Get implements history.Store.
+func (s *historyStore) Get(p index.Path) []time.Time {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.m[fmt.Sprintf("%v", p)]
+}
+
+// Range implements history.Store.
+func (s *historyStore) Range(from, to time.Time) []history.Event {
+	s.RLock()
+	defer s.RUnlock()
+
+	return history.RangeMap(s.m, from, to)
+}
+
+// boolStore is a set of paths stored in a bucket.  It implements favourite.Store and
+// checklist.Store.
+type boolStore struct {
+	sync.RWMutex
+
+	m map[string]bool
+	b *bucket
+}
+
+func (d *DB) boolStore(name string) (*boolStore, error) {
+	b, err := d.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &boolStore{
+		m: make(map[string]bool),
+		b: b,
+	}
+	var v bool
+	err = b.load(func(string) interface{} {
+		v = false
+		return &v
+	}, func(k string) {
+		if v {
+			s.m[k] = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FavouriteStore creates a favourite.Store which uses the database.
+func (d *DB) FavouriteStore() (favourite.Store, error) {
+	return d.boolStore(FavouriteBucket)
+}
+
+// ChecklistStore creates a checklist.Store which uses the database.
+func (d *DB) ChecklistStore() (checklist.Store, error) {
+	return d.boolStore(ChecklistBucket)
+}
+
+// Set implements favourite.Store and checklist.Store.
+func (s *boolStore) Set(p index.Path, v bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	k := fmt.Sprintf("%v", p)
+	if v {
+		if err := s.b.put(k, true); err != nil {
+			return err
+		}
+		s.m[k] = true
+		return nil
+	}
+	if err := s.b.delete(k); err != nil {
+		return err
+	}
+	delete(s.m, k)
+	return nil
+}
+
+// Get implements favourite.Store and checklist.Store.
+func (s *boolStore) Get(p index.Path) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.m[fmt.Sprintf("%v", p)]
+}
+
+// List implements favourite.Store and checklist.Store.
+func (s *boolStore) List() []index.Path {
+	s.RLock()
+	defer s.RUnlock()
+
+	result := make([]index.Path, 0, len(s.m))
+	for k := range s.m {
+		result = append(result, index.NewPath(k))
+	}
+	return result
+}
+
+// RatingStore creates a rating.Store which uses the database.
+func (d *DB) RatingStore() (rating.Store, error) {
+	b, err := d.bucket(RatingBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ratingStore{
+		m: make(map[string]rating.Value),
+		b: b,
+	}
+	var v rating.Value
+	err = b.load(func(string) interface{} {
+		return &v
+	}, func(k string) {
+		if v != rating.None {
+			s.m[k] = v
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type ratingStore struct {
+	sync.RWMutex
+
+	m map[string]rating.Value
+	b *bucket
+}
+
+// Set implements rating.Store.
+func (s *ratingStore) Set(p index.Path, v rating.Value) error {
+	s.Lock()
+	defer s.Unlock()
+
+	k := fmt.Sprintf("%v", p)
+	if v == rating.None {
+		if err := s.b.delete(k); err != nil {
+			return err
+		}
+		delete(s.m, k)
+		return nil
+	}
+	if err := s.b.put(k, v); err != nil {
+		return err
+	}
+	s.m[k] = v
+	return nil
+}
+
+// Get implements rating.Store.
+func (s *ratingStore) Get(p index.Path) rating.Value {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.m[fmt.Sprintf("%v", p)]
+}
+
+// List implements rating.Store.
+func (s *ratingStore) List() []index.Path {
+	s.RLock()
+	defer s.RUnlock()
+
+	result := make([]index.Path, 0, len(s.m))
+	for k := range s.m {
+		result = append(result, index.NewPath(k))
+	}
+	return result
+}
+
+// PlaylistStore creates a playlist.Store which uses the database.
+func (d *DB) PlaylistStore() (playlist.Store, error) {
+	b, err := d.bucket(PlaylistBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &playlistStore{
+		m: make(map[string]*playlist.Playlist),
+		b: b,
+	}
+	err = b.load(func(k string) interface{} {
+		p := &playlist.Playlist{}
+		s.m[k] = p
+		return p
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type playlistStore struct {
+	sync.RWMutex
+
+	m map[string]*playlist.Playlist
+	b *bucket
+}
+
+// Names implements playlist.Store.
+func (s *playlistStore) Names() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	n := make([]string, 0, len(s.m))
+	for k := range s.m {
+		n = append(n, k)
+	}
+	return n
+}
+
+// Get implements playlist.Store.
+func (s *playlistStore) Get(name string) *playlist.Playlist {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.m[name]
+}
+
+// Set implements playlist.Store.
+func (s *playlistStore) Set(name string, p *playlist.Playlist) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.b.put(name, p); err != nil {
+		return err
+	}
+	s.m[name] = p
+	return nil
+}
+
+// Delete implements playlist.Store.
+func (s *playlistStore) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.b.delete(name); err != nil {
+		return err
+	}
+	delete(s.m, name)
+	return nil
+}
+
+// CursorStore creates a cursor.Store which uses the database.
+func (d *DB) CursorStore() (cursor.Store, error) {
+	b, err := d.bucket(CursorBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &cursorStore{
+		m: make(map[string]*cursor.Cursor),
+		b: b,
+	}
+	err = b.load(func(k string) interface{} {
+		c := &cursor.Cursor{}
+		s.m[k] = c
+		return c
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type cursorStore struct {
+	sync.RWMutex
+
+	m map[string]*cursor.Cursor
+	b *bucket
+}
+
+// Get implements cursor.Store.
+func (s *cursorStore) Get(name string) *cursor.Cursor {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.m[name]
+}
+
+// Set implements cursor.Store.
+func (s *cursorStore) Set(name string, c *cursor.Cursor) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.b.put(name, c); err != nil {
+		return err
+	}
+	s.m[name] = c
+	return nil
+}
+
+// Delete implements cursor.Store.
+func (s *cursorStore) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.b.delete(name); err != nil {
+		return err
+	}
+	delete(s.m, name)
+	return nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FormatVersion is the version of the library format written by WriteTo.
+const FormatVersion = 1
+
+// formatMagic is written at the start of every library (followed by the format version).
+var formatMagic = []byte("TCHK")
+
+// gzipMagic is the start of every gzip stream, used to identify libraries written in the
+// original gzipped-JSON format (version 0).
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Header is the header of an encoded library.
+type Header struct {
+	// Version is the format version (0 for gzipped-JSON libraries).
+	Version int
+	// Tracks is the number of tracks in the library.
+	Tracks int
+	// Built is the time that the library was written (zero for gzipped-JSON libraries).
+	Built time.Time
+}
+
+// WriteTo writes the Library data to the writer.  The format is a magic string and format
+// version, followed by a gzipped stream of gob-encoded values: the Header and then each
+// of the tracks.
+func WriteTo(l Library, w io.Writer) error {
+	_, err := w.Write(formatMagic)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.BigEndian, uint32(FormatVersion))
+	if err != nil {
+		return err
+	}
+
+	gzw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+
+	tracks := l.Tracks()
+	enc := gob.NewEncoder(gzw)
+	err = enc.Encode(Header{
+		Version: FormatVersion,
+		Tracks:  len(tracks),
+		Built:   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tracks {
+		tt, ok := t.(*track)
+		if !ok {
+			tt = newTrack(t, t.GetString("ID"))
+		}
+		err = enc.Encode(tt)
+		if err != nil {
+			return err
+		}
+	}
+	return gzw.Close()
+}
+
+// maxTracksHint is the largest Header.Tracks value used to pre-size the track map in ReadFrom.
+const maxTracksHint = 1 << 16
+
+// ReadFrom reads the representation of a Library written by WriteTo.  Libraries written
+// in the original gzipped-JSON format are also supported.
+func ReadFrom(r io.Reader) (Library, error) {
+	dec, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	// The track count in the header is only a hint: it can't be trusted to size the map.
+	n := dec.Header().Tracks
+	if n < 0 || n > maxTracksHint {
+		n = maxTracksHint
+	}
+	l := &library{
+		trks: make(map[string]*track, n),
+	}
+	for {
+		t, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.trks[t.ID] = t
+	}
+	return l, nil
+}
+
+// Decoder reads tracks from an encoded Library one at a time, so that a Library can be
+// processed without reading all of it into memory.
+type Decoder struct {
+	header Header
+	gzr    *gzip.Reader
+
+	dec *gob.Decoder // version 1
+
+	legacy []*track // version 0
+}
+
+// NewDecoder creates a Decoder which reads from r, and reads the Header.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(formatMagic))
+	if err != nil {
+		return nil, fmt.Errorf("error reading library header: %v", err)
+	}
+
+	if bytes.HasPrefix(magic, gzipMagic) {
+		return newLegacyDecoder(br)
+	}
+
+	if !bytes.Equal(magic, formatMagic) {
+		return nil, fmt.Errorf("invalid library format")
+	}
+	br.Discard(len(formatMagic))
+
+	var version uint32
+	err = binary.Read(br, binary.BigEndian, &version)
+	if err != nil {
+		return nil, fmt.Errorf("error reading library format version: %v", err)
+	}
+	if version != FormatVersion {
+		return nil, fmt.Errorf("unsupported library format version: %d", version)
+	}
+
+	gzr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Decoder{
+		gzr: gzr,
+		dec: gob.NewDecoder(gzr),
+	}
+	err = d.dec.Decode(&d.header)
+	if err != nil {
+		gzr.Close()
+		return nil, fmt.Errorf("error reading library header: %v", err)
+	}
+	return d, nil
+}
+
+// newLegacyDecoder creates a Decoder for a library in the gzipped-JSON format, which is
+// read in full.
+func newLegacyDecoder(r io.Reader) (*Decoder, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &library{}
+	err = json.NewDecoder(gzr).Decode(l)
+	if err != nil {
+		gzr.Close()
+		return nil, err
+	}
+
+	tracks := make([]*track, 0, len(l.trks))
+	for _, t := range l.trks {
+		tracks = append(tracks, t)
+	}
+
+	return &Decoder{
+		header: Header{
+			Tracks: len(tracks),
+		},
+		gzr:    gzr,
+		legacy: tracks,
+	}, nil
+}
+
+// Header returns the library Header.
+func (d *Decoder) Header() Header {
+	return d.header
+}
+
+// Next returns the next Track in the library, or io.EOF when there are no more tracks.
+func (d *Decoder) Next() (Track, error) {
+	t, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (d *Decoder) next() (*track, error) {
+	if d.dec == nil {
+		if len(d.legacy) == 0 {
+			return nil, io.EOF
+		}
+		t := d.legacy[0]
+		d.legacy = d.legacy[1:]
+		return t, nil
+	}
+
+	t := &track{}
+	err := d.dec.Decode(t)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error reading track: %v", err)
+	}
+	return t, nil
+}
+
+// Close closes the Decoder.  It does not close the underlying io.Reader.
+func (d *Decoder) Close() error {
+	return d.gzr.Close()
+}
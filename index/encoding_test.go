@@ -0,0 +1,117 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	tl := testLibrary{
+		tr: &tr,
+	}
+
+	buf := &bytes.Buffer{}
+	err := WriteTo(Convert(tl, "ID"), buf)
+	if err != nil {
+		t.Fatalf("unexpected error in WriteTo: %v", err)
+	}
+
+	dec, err := NewDecoder(buf)
+	if err != nil {
+		t.Fatalf("unexpected error in NewDecoder: %v", err)
+	}
+	defer dec.Close()
+
+	h := dec.Header()
+	if h.Version != FormatVersion {
+		t.Errorf("h.Version = %d, expected: %d", h.Version, FormatVersion)
+	}
+	if h.Tracks != 1 {
+		t.Errorf("h.Tracks = %d, expected: %d", h.Tracks, 1)
+	}
+	if h.Built.IsZero() {
+		t.Errorf("h.Built is zero, expected build time")
+	}
+
+	got, err := dec.Next()
+	if err != nil {
+		t.Fatalf("unexpected error in Next: %v", err)
+	}
+	if got.GetString("Name") != tr.Name {
+		t.Errorf("got.GetString(\"Name\") = %#v, expected: %#v", got.GetString("Name"), tr.Name)
+	}
+
+	_, err = dec.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF from Next, got: %v", err)
+	}
+}
+
+func TestReadFromLegacyFormat(t *testing.T) {
+	tl := testLibrary{
+		tr: &tr,
+	}
+
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	err := json.NewEncoder(gzw).Encode(Convert(tl, "ID"))
+	if err != nil {
+		t.Fatalf("unexpected error encoding JSON: %v", err)
+	}
+	gzw.Close()
+
+	l, err := ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error in ReadFrom: %v", err)
+	}
+
+	got, ok := l.Track("ID")
+	if !ok {
+		t.Fatalf("expected track with ID %#v", "ID")
+	}
+	if got.GetString("Album") != tr.Album {
+		t.Errorf("got.GetString(\"Album\") = %#v, expected: %#v", got.GetString("Album"), tr.Album)
+	}
+	if got.GetInt("BitRate") != tr.BitRate {
+		t.Errorf("got.GetInt(\"BitRate\") = %d, expected: %d", got.GetInt("BitRate"), tr.BitRate)
+	}
+}
+
+func TestReadFromInvalidFormat(t *testing.T) {
+	_, err := ReadFrom(bytes.NewBufferString("not a library"))
+	if err == nil {
+		t.Errorf("expected error reading invalid library")
+	}
+}
+
+func TestReadFromInvalidHeader(t *testing.T) {
+	for _, n := range []int{-1, 1 << 40} {
+		buf := &bytes.Buffer{}
+		buf.Write(formatMagic)
+		binary.Write(buf, binary.BigEndian, uint32(FormatVersion))
+		gzw := gzip.NewWriter(buf)
+		err := gob.NewEncoder(gzw).Encode(Header{Version: FormatVersion, Tracks: n})
+		if err != nil {
+			t.Fatalf("unexpected error encoding header: %v", err)
+		}
+		gzw.Close()
+
+		l, err := ReadFrom(buf)
+		if err != nil {
+			t.Errorf("[Tracks: %d] unexpected error in ReadFrom: %v", n, err)
+			continue
+		}
+		if len(l.Tracks()) != 0 {
+			t.Errorf("[Tracks: %d] len(l.Tracks()) = %d, expected: 0", n, len(l.Tracks()))
+		}
+	}
+}
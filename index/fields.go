@@ -0,0 +1,76 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import "sync"
+
+// FieldType is a type which represents the type of a Track attribute.
+type FieldType int
+
+// Field types.
+const (
+	StringField FieldType = iota
+	IntField
+)
+
+// ImportedPlayCountField is the name of the extra Track attribute which holds the play
+// count imported from another library (i.e. iTunes, which only keeps the time of the last
+// play, so the count is kept separately from the play history).
+const ImportedPlayCountField = "ImportedPlayCount"
+
+// extraFields is the registry of extra attributes carried by Tracks in addition to the
+// standard attributes.
+var extraFields = struct {
+	sync.RWMutex
+	m map[string]FieldType
+}{
+	m: map[string]FieldType{
+		"Comment":              StringField,
+		"ContentID":            StringField,
+		"Conductor":            StringField,
+		"Orchestra":            StringField,
+		"MusicBrainzTrackID":   StringField,
+		"MusicBrainzAlbumID":   StringField,
+		"MusicBrainzArtistID":  StringField,
+		"BPM":                  IntField,
+		ImportedPlayCountField: IntField,
+	},
+}
+
+// RegisterField registers an extra Track attribute with the given name and type.  Extra
+// attributes are copied by Convert, persisted by WriteTo and can be accessed using the
+// Get methods of any Track in a Library created by Convert or ReadFrom (returning the zero
+// value if the attribute is unset).  String attributes can also be accessed as 'Strings'
+// attributes (see DefaultGetStrings).
+func RegisterField(name string, t FieldType) {
+	extraFields.Lock()
+	defer extraFields.Unlock()
+
+	extraFields.m[name] = t
+}
+
+// IsExtraField returns true if name has been registered as an extra attribute of the
+// given type.
+func IsExtraField(name string, t FieldType) bool {
+	extraFields.RLock()
+	defer extraFields.RUnlock()
+
+	ft, ok := extraFields.m[name]
+	return ok && ft == t
+}
+
+// ExtraFields returns the names of all the extra attributes of the given type.
+func ExtraFields(t FieldType) []string {
+	extraFields.RLock()
+	defer extraFields.RUnlock()
+
+	var fields []string
+	for k, ft := range extraFields.m {
+		if ft == t {
+			fields = append(fields, k)
+		}
+	}
+	return fields
+}
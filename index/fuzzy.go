@@ -0,0 +1,206 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import "sort"
+
+// EditDistance returns the Levenshtein distance between a and b: the minimum number of
+// single character insertions, deletions and substitutions required to change a into b.
+func EditDistance(a, b string) int {
+	return editDistance([]rune(a), []rune(b))
+}
+
+func editDistance(a, b []rune) int {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+
+	// Only keep a single row of the distance matrix.
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			next := min3(row[j]+1, row[j-1]+1, prev+cost)
+			prev = row[j]
+			row[j] = next
+		}
+	}
+	return row[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// bkNode is a node in a BK-tree.  Children are keyed by their edit distance from the node.
+type bkNode struct {
+	word     []rune
+	children map[int]*bkNode
+}
+
+func (n *bkNode) add(w []rune) {
+	for {
+		d := editDistance(n.word, w)
+		if d == 0 {
+			return
+		}
+		c, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*bkNode)
+			}
+			n.children[d] = &bkNode{word: w}
+			return
+		}
+		n = c
+	}
+}
+
+// fuzzyMatch is a word and its edit distance from the search input.
+type fuzzyMatch struct {
+	word string
+	dist int
+}
+
+type fuzzyMatches []fuzzyMatch
+
+func (m fuzzyMatches) Len() int      { return len(m) }
+func (m fuzzyMatches) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m fuzzyMatches) Less(i, j int) bool {
+	if m[i].dist == m[j].dist {
+		return m[i].word < m[j].word
+	}
+	return m[i].dist < m[j].dist
+}
+
+// find appends all the words within distance n of w to the matches.
+func (n *bkNode) find(w []rune, max int, m fuzzyMatches) fuzzyMatches {
+	d := editDistance(n.word, w)
+	if d <= max {
+		m = append(m, fuzzyMatch{string(n.word), d})
+	}
+	for cd, c := range n.children {
+		if d-max <= cd && cd <= d+max {
+			m = c.find(w, max, m)
+		}
+	}
+	return m
+}
+
+// FuzzyExpand is a type which implements Expander by returning all the words within a
+// maximum edit distance of the input.  Words are stored in a BK-tree.
+type FuzzyExpand struct {
+	root *bkNode
+	max  int
+}
+
+// BuildFuzzyExpander builds an Expander which expands input into the list of words within
+// edit distance n (ranked by distance).  The distance is reduced for short input: words
+// shorter than MinPrefix are not expanded, and words of length less than 2*MinPrefix are
+// allowed at most a single edit.
+func BuildFuzzyExpander(words []string, n int) *FuzzyExpand {
+	f := &FuzzyExpand{
+		max: n,
+	}
+	for _, w := range words {
+		r := []rune(w)
+		if f.root == nil {
+			f.root = &bkNode{word: r}
+			continue
+		}
+		f.root.add(r)
+	}
+	return f
+}
+
+// maxDistance returns the maximum edit distance to use for the input.
+func (f *FuzzyExpand) maxDistance(s []rune) int {
+	switch {
+	case len(s) < MinPrefix:
+		return 0
+	case len(s) < 2*MinPrefix && f.max > 1:
+		return 1
+	}
+	return f.max
+}
+
+// Expand returns the list of words within the maximum edit distance of s, ordered by
+// increasing distance.
+func (f *FuzzyExpand) Expand(s string) []string {
+	r := []rune(s)
+	max := f.maxDistance(r)
+	if f.root == nil || max == 0 {
+		return []string{s}
+	}
+
+	m := f.root.find(r, max, nil)
+	sort.Sort(fuzzyMatches(m))
+	result := make([]string, len(m))
+	for i, x := range m {
+		result[i] = x.word
+	}
+	return result
+}
+
+// FuzzyPrefixExpand is an Expander which wraps a PrefixMultiExpand so that input longer than
+// its prefix length is only expanded to words which are within a single edit of the input
+// (compared up to the length of the input), rather than all words with the same prefix.
+type FuzzyPrefixExpand struct {
+	PrefixMultiExpand
+}
+
+// Expand implements Expander.
+func (f FuzzyPrefixExpand) Expand(s string) []string {
+	words := f.PrefixMultiExpand.Expand(s)
+	if len(s) <= f.size {
+		return words
+	}
+
+	r := []rune(s)
+	var result []string
+	for _, w := range words {
+		wr := []rune(w)
+		if len(wr) > len(r) {
+			wr = wr[:len(r)]
+		}
+		if editDistance(r, wr) <= 1 {
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+// MultiExpand is an Expander which combines the expansions of a list of Expanders,
+// removing duplicates (the order of the expansions is preserved).
+type MultiExpand []Expander
+
+// Expand implements Expander.
+func (m MultiExpand) Expand(s string) []string {
+	done := make(map[string]bool)
+	var result []string
+	for _, e := range m {
+		for _, w := range e.Expand(s) {
+			if !done[w] {
+				done[w] = true
+				result = append(result, w)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"bach", "bach", 0},
+		{"bach", "bech", 1},
+		{"kitten", "sitting", 3},
+		{"shostakovitch", "shostakovich", 1},
+		{"rachmaninov", "rachmaninoff", 2},
+		{"dvořák", "dvorak", 2},
+	}
+
+	for ii, tt := range tests {
+		if d := EditDistance(tt.a, tt.b); d != tt.d {
+			t.Errorf("[%d] EditDistance(%#v, %#v) = %d, expected: %d", ii, tt.a, tt.b, d, tt.d)
+		}
+	}
+}
+
+func TestFuzzyExpander(t *testing.T) {
+	words := []string{"prokofiev", "shostakovich", "tchaikovsky", "rachmaninoff", "xenakis", "bach", "bax", "back"}
+
+	tests := []struct {
+		n   int
+		in  string
+		out []string
+	}{
+		{2, "shostakovitch", []string{"shostakovich"}},
+		{2, "rachmaninov", []string{"rachmaninoff"}},
+		{2, "tchaikovsky", []string{"tchaikovsky"}},
+		{2, "tchaikowsky", []string{"tchaikovsky"}},
+		{1, "rachmaninov", nil},
+		{2, "bach", []string{"bach", "back"}},
+		{2, "bac", []string{"bach", "back", "bax"}},
+		{2, "ba", []string{"ba"}},
+		{2, "stravinsky", nil},
+	}
+
+	for ii, tt := range tests {
+		fe := BuildFuzzyExpander(words, tt.n)
+		got := fe.Expand(tt.in)
+		if len(got) == 0 && len(tt.out) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("[%d] Expand(%#v) = %#v, expected: %#v", ii, tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestMultiExpand(t *testing.T) {
+	words := []string{"rachmaninov", "rachmaninoff", "rachel"}
+	e := MultiExpand{
+		BuildPrefixMultiExpander(words, 10),
+		BuildFuzzyExpander(words, 2),
+	}
+
+	got := e.Expand("rachmaninof")
+	expected := []string{"rachmaninoff", "rachmaninov"}
+	if !reflect.DeepEqual(stringSet(got), stringSet(expected)) {
+		t.Errorf("Expand(%#v) = %#v, expected: %#v (compared unordered)", "rachmaninof", got, expected)
+	}
+}
+
+func TestFuzzyPrefixExpand(t *testing.T) {
+	words := []string{"rachmaninov", "rachmaninoff", "rachel", "rachmanova"}
+	p := BuildPrefixMultiExpander(words, 4)
+
+	// Input longer than the prefix length is expanded to all words with the same prefix...
+	expected := []string{"rachmaninov", "rachmaninoff", "rachel", "rachmanova"}
+	if got := p.Expand("rachmaninof"); !reflect.DeepEqual(stringSet(got), stringSet(expected)) {
+		t.Errorf("PrefixMultiExpand.Expand(%#v) = %#v, expected: %#v (compared unordered)", "rachmaninof", got, expected)
+	}
+
+	// ...unless filtered by edit distance.
+	expected = []string{"rachmaninov", "rachmaninoff"}
+	if got := (FuzzyPrefixExpand{p}).Expand("rachmaninof"); !reflect.DeepEqual(stringSet(got), stringSet(expected)) {
+		t.Errorf("FuzzyPrefixExpand.Expand(%#v) = %#v, expected: %#v (compared unordered)", "rachmaninof", got, expected)
+	}
+}
@@ -340,3 +340,63 @@ func (a groupBy) Collect(tracker Tracker) Collection {
 	}
 	return gg
 }
+
+// ByEach is a function which returns a Collector to group a collection using each of the
+// values of the given 'Strings' attribute: tracks with more than one value are added to
+// the group of each value.
+func ByEach(a attr.Interface) Collector {
+	return groupByEach{a}
+}
+
+type groupByEach struct {
+	attr.Interface
+}
+
+// Collect implements Collector
+func (a groupByEach) Collect(tracker Tracker) Collection {
+	name := "by " + a.Name()
+	if tg, ok := tracker.(Group); ok {
+		name = tg.Name()
+	}
+	gg := newCol(name)
+	for _, t := range tracker.Tracks() {
+		v, _ := a.Value(t).([]string)
+		if len(v) == 0 {
+			gg.add("", t)
+			continue
+		}
+		for _, x := range v {
+			gg.add(x, t)
+		}
+	}
+	return gg
+}
+
+// ByDecade is a function which returns a Collector to group a collection by the decade of
+// the given 'Int' (year) attribute, i.e. "1970s".  Tracks without a year are grouped
+// together in a group with an empty name.
+func ByDecade(a attr.Interface) Collector {
+	return groupByDecade{a}
+}
+
+type groupByDecade struct {
+	attr.Interface
+}
+
+// Collect implements Collector
+func (a groupByDecade) Collect(tracker Tracker) Collection {
+	name := "by decade of " + a.Name()
+	if tg, ok := tracker.(Group); ok {
+		name = tg.Name()
+	}
+	gg := newCol(name)
+	for _, t := range tracker.Tracks() {
+		y, _ := a.Value(t).(int)
+		if y == 0 {
+			gg.add("", t)
+			continue
+		}
+		gg.add(fmt.Sprintf("%ds", y-y%10), t)
+	}
+	return gg
+}
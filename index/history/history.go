@@ -3,6 +3,7 @@ package history
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,8 +15,149 @@ import (
 type Store interface {
 	// Add a play event to the store.
 	Add(index.Path) error
+	// AddTimes adds play events with the given times to the store (i.e. when importing play
+	// history from elsewhere).
+	AddTimes(index.Path, []time.Time) error
 	// Get the play events associated to a path.
 	Get(index.Path) []time.Time
+	// Range returns the play events for all paths which are in the time range [from, to),
+	// ordered by time.  A zero from or to leaves that end of the range open.
+	Range(from, to time.Time) []Event
+}
+
+// Event is a play event.
+type Event struct {
+	Path index.Path `json:"path"`
+	Time time.Time  `json:"time"`
+}
+
+type eventsByTime []Event
+
+func (e eventsByTime) Len() int           { return len(e) }
+func (e eventsByTime) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e eventsByTime) Less(i, j int) bool { return e[i].Time.Before(e[j].Time) }
+
+// inRange returns true if t is in the time range [from, to), where a zero from or to leaves
+// that end of the range open.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// RangeMap returns the play events in the map (of paths to play times, as used by the JSON
+// Store) which are in the time range [from, to), ordered by time.  It is intended for use by
+// Store implementations.
+func RangeMap(m map[string][]time.Time, from, to time.Time) []Event {
+	var events []Event
+	for k, times := range m {
+		var p index.Path
+		for _, t := range times {
+			if !inRange(t, from, to) {
+				continue
+			}
+			if p == nil {
+				p = index.NewPath(k)
+			}
+			events = append(events, Event{Path: p, Time: t})
+		}
+	}
+	sort.Stable(eventsByTime(events))
+	return events
+}
+
+type timesByTime []time.Time
+
+func (t timesByTime) Len() int           { return len(t) }
+func (t timesByTime) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t timesByTime) Less(i, j int) bool { return t[i].Before(t[j]) }
+
+// MergeTimes returns the play times x with the times y added (converted to UTC), ordered by
+// time.  It is intended for use by Store implementations of AddTimes.
+func MergeTimes(x, y []time.Time) []time.Time {
+	result := make([]time.Time, 0, len(x)+len(y))
+	result = append(result, x...)
+	for _, t := range y {
+		result = append(result, t.UTC())
+	}
+	sort.Stable(timesByTime(result))
+	return result
+}
+
+// Count is the number of plays of a path.
+type Count struct {
+	Path  index.Path `json:"path"`
+	Count int        `json:"count"`
+}
+
+type countsByCount []Count
+
+func (c countsByCount) Len() int      { return len(c) }
+func (c countsByCount) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c countsByCount) Less(i, j int) bool {
+	if c[i].Count == c[j].Count {
+		return c[i].Path.Encode() < c[j].Path.Encode()
+	}
+	return c[i].Count > c[j].Count
+}
+
+// truncate returns the path truncated to (at most) depth keys.  If depth is zero or less
+// then the path is returned unchanged.
+func truncate(p index.Path, depth int) index.Path {
+	if depth > 0 && len(p) > depth {
+		return p[:depth]
+	}
+	return p
+}
+
+// Counts aggregates the events into play counts for each path, where paths are first
+// truncated to depth keys (so that, for instance, plays of tracks are counted against
+// their album when depth is 2).  If depth is zero or less then paths are not truncated.
+// Counts are ordered by decreasing count.
+func Counts(events []Event, depth int) []Count {
+	idx := make(map[string]int)
+	var counts []Count
+	for _, e := range events {
+		p := truncate(e.Path, depth)
+		k := p.Encode()
+		i, ok := idx[k]
+		if !ok {
+			i = len(counts)
+			idx[k] = i
+			counts = append(counts, Count{Path: p})
+		}
+		counts[i].Count++
+	}
+	sort.Sort(countsByCount(counts))
+	return counts
+}
+
+// Top returns (at most) the first n Counts.  If n is zero or less then all the Counts are
+// returned.
+func Top(counts []Count, n int) []Count {
+	if n > 0 && len(counts) > n {
+		return counts[:n]
+	}
+	return counts
+}
+
+// Recent returns (at most) n distinct paths from the events, most recently played first,
+// where paths are first truncated to depth keys (see Counts).  If n is zero or less then
+// all the distinct paths are returned.  Events are assumed to be ordered by time (see
+// Store.Range).
+func Recent(events []Event, depth, n int) []index.Path {
+	done := make(map[string]bool)
+	var paths []index.Path
+	for i := len(events) - 1; i >= 0; i-- {
+		if n > 0 && len(paths) == n {
+			break
+		}
+		p := truncate(events[i].Path, depth)
+		k := p.Encode()
+		if !done[k] {
+			done[k] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths
 }
 
 // NewStore creates a basic implementation of a play history store, using the given path as the
@@ -50,6 +192,16 @@ func (s *store) Add(p index.Path) error {
 	return s.store.Persist(&s.m)
 }
 
+// AddTimes implements Store.
+func (s *store) AddTimes(p index.Path, times []time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	k := fmt.Sprintf("%v", p)
+	s.m[k] = MergeTimes(s.m[k], times)
+	return s.store.Persist(&s.m)
+}
+
 // Get implements Store.
 func (s *store) Get(p index.Path) []time.Time {
 	s.RLock()
@@ -57,3 +209,11 @@ func (s *store) Get(p index.Path) []time.Time {
 
 	return s.m[fmt.Sprintf("%v", p)]
 }
+
+// Range implements Store.
+func (s *store) Range(from, to time.Time) []Event {
+	s.RLock()
+	defer s.RUnlock()
+
+	return RangeMap(s.m, from, to)
+}
@@ -0,0 +1,111 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tchaik.com/index"
+)
+
+func date(d int) time.Time {
+	return time.Date(2015, time.June, d, 12, 0, 0, 0, time.UTC)
+}
+
+var testHistory = map[string][]time.Time{
+	"Root:a:0": {date(1), date(3)},
+	"Root:a:1": {date(2)},
+	"Root:b:0": {date(4), date(5), date(6)},
+	"Root:c":   {date(7)},
+}
+
+func TestRangeMap(t *testing.T) {
+	tests := []struct {
+		from, to time.Time
+		out      []Event
+	}{
+		{
+			date(3), date(5),
+			[]Event{
+				{index.Path{"Root", "a", "0"}, date(3)},
+				{index.Path{"Root", "b", "0"}, date(4)},
+			},
+		},
+		{
+			date(6), time.Time{},
+			[]Event{
+				{index.Path{"Root", "b", "0"}, date(6)},
+				{index.Path{"Root", "c"}, date(7)},
+			},
+		},
+		{
+			time.Time{}, date(2),
+			[]Event{
+				{index.Path{"Root", "a", "0"}, date(1)},
+			},
+		},
+	}
+
+	for ii, tt := range tests {
+		got := RangeMap(testHistory, tt.from, tt.to)
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("[%d] RangeMap(%v, %v) = %v, expected: %v", ii, tt.from, tt.to, got, tt.out)
+		}
+	}
+
+	if got := len(RangeMap(testHistory, time.Time{}, time.Time{})); got != 7 {
+		t.Errorf("len(RangeMap()) = %d, expected: 7", got)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	events := RangeMap(testHistory, time.Time{}, time.Time{})
+
+	got := Counts(events, 2)
+	expected := []Count{
+		{index.Path{"Root", "a"}, 3},
+		{index.Path{"Root", "b"}, 3},
+		{index.Path{"Root", "c"}, 1},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Counts(events, 2) = %v, expected: %v", got, expected)
+	}
+
+	got = Top(Counts(events, 0), 2)
+	expected = []Count{
+		{index.Path{"Root", "b", "0"}, 3},
+		{index.Path{"Root", "a", "0"}, 2},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Top(Counts(events, 0), 2) = %v, expected: %v", got, expected)
+	}
+}
+
+func TestRecent(t *testing.T) {
+	events := RangeMap(testHistory, time.Time{}, time.Time{})
+
+	got := Recent(events, 2, 0)
+	expected := []index.Path{{"Root", "c"}, {"Root", "b"}, {"Root", "a"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Recent(events, 2, 0) = %v, expected: %v", got, expected)
+	}
+
+	got = Recent(events, 0, 2)
+	expected = []index.Path{{"Root", "c"}, {"Root", "b", "0"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Recent(events, 0, 2) = %v, expected: %v", got, expected)
+	}
+}
+
+func TestMergeTimes(t *testing.T) {
+	local := time.FixedZone("local", 3600)
+	got := MergeTimes([]time.Time{date(1), date(3)}, []time.Time{date(2).In(local), date(4)})
+	expected := []time.Time{date(1), date(2), date(3), date(4)}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("MergeTimes() = %v, expected: %v", got, expected)
+	}
+}
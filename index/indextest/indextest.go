@@ -0,0 +1,72 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package indextest provides index.Track and index.Library implementations for use in tests.
+package indextest
+
+import (
+	"time"
+
+	"tchaik.com/index"
+)
+
+// Track is an index.Track whose attributes are given by its fields.  All other attributes
+// are unset.
+type Track struct {
+	ID, Name, Album, Composer, Location string
+	Year                                int
+}
+
+// GetString implements index.Track.
+func (t Track) GetString(k string) string {
+	switch k {
+	case "ID":
+		return t.ID
+	case "Name":
+		return t.Name
+	case "Album":
+		return t.Album
+	case "Composer":
+		return t.Composer
+	case "Location":
+		return t.Location
+	}
+	return ""
+}
+
+// GetStrings implements index.Track.
+func (t Track) GetStrings(k string) []string { return nil }
+
+// GetInt implements index.Track.
+func (t Track) GetInt(k string) int {
+	if k == "Year" {
+		return t.Year
+	}
+	return 0
+}
+
+// GetTime implements index.Track.
+func (t Track) GetTime(string) time.Time { return time.Time{} }
+
+// Library is an index.Library (and index.Tracker) of tracks, identified by their ID.
+type Library []Track
+
+// Tracks implements index.Library.
+func (l Library) Tracks() []index.Track {
+	result := make([]index.Track, len(l))
+	for i, t := range l {
+		result[i] = t
+	}
+	return result
+}
+
+// Track implements index.Library.
+func (l Library) Track(id string) (index.Track, bool) {
+	for _, t := range l {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return nil, false
+}
@@ -102,6 +102,12 @@ func (t *itlTrack) GetString(name string) string {
 		return html.UnescapeString(t.Genre)
 	case "Kind":
 		return html.UnescapeString(t.Kind)
+	case "Comment":
+		return html.UnescapeString(t.Comments)
+	}
+
+	if index.IsExtraField(name, index.StringField) {
+		return ""
 	}
 
 	tt := reflect.TypeOf(t)
@@ -125,6 +131,9 @@ func (t *itlTrack) GetStrings(name string) []string {
 	case "Artist", "AlbumArtist", "Composer":
 		return index.DefaultGetStrings(t, name)
 	}
+	if index.IsExtraField(name, index.StringField) {
+		return index.DefaultGetStrings(t, name)
+	}
 	panic(fmt.Sprintf("field '%v' is not a []string", name))
 }
 
@@ -147,6 +156,16 @@ func (t *itlTrack) GetInt(name string) int {
 		return t.TotalTime
 	case "BitRate":
 		return t.BitRate
+	case "BPM":
+		return t.BPM
+	case "Size":
+		return t.Size
+	case index.ImportedPlayCountField: // see Meta.Import
+		return t.PlayCount
+	}
+
+	if index.IsExtraField(name, index.IntField) {
+		return 0
 	}
 
 	tt := reflect.TypeOf(t)
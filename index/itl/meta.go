@@ -0,0 +1,225 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package itl
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"time"
+
+	rawitl "github.com/dhowden/itl"
+	"tchaik.com/index"
+	"tchaik.com/index/favourite"
+	"tchaik.com/index/history"
+	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
+)
+
+// Meta is the user data from an iTunes Library: playlists, ratings, loved tracks and play
+// counts.  Tracks are identified by their ID (as used by the Library created by ReadFrom).
+type Meta struct {
+	Tracks    map[string]TrackMeta
+	Playlists []Playlist
+}
+
+// TrackMeta is the user data for a track.  Ratings are on the iTunes scale (0-100, 20 per
+// star).
+type TrackMeta struct {
+	Rating      int
+	AlbumRating int // zero if the album rating is computed from track ratings
+	Loved       bool
+	PlayCount   int
+	PlayDate    time.Time // time of the last play
+}
+
+// Playlist is an iTunes playlist.
+type Playlist struct {
+	Name     string
+	TrackIDs []string
+}
+
+// ReadWithMeta creates a Tchaik Library implementation from an iTunes Music Library passed
+// through an io.Reader (see ReadFrom), and also returns its user data.
+func ReadWithMeta(r io.Reader) (index.Library, *Meta, error) {
+	l, err := rawitl.ReadFromXML(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	lib := &itlLibrary{&l}
+	return lib, newMeta(lib), nil
+}
+
+func newMeta(l *itlLibrary) *Meta {
+	m := &Meta{
+		Tracks: make(map[string]TrackMeta),
+	}
+
+	for _, t := range l.Tracks() {
+		x := t.(*itlTrack)
+		tm := TrackMeta{
+			Rating:    x.Rating,
+			Loved:     x.Loved,
+			PlayCount: x.PlayCount,
+			PlayDate:  x.PlayDateUTC,
+		}
+		if !x.AlbumRatingComputed {
+			tm.AlbumRating = x.AlbumRating
+		}
+		if tm != (TrackMeta{}) {
+			m.Tracks[strconv.Itoa(x.TrackID)] = tm
+		}
+	}
+
+	for _, p := range l.Library.Playlists {
+		// Skip the library, folders and the built-in playlists (Music, Movies, etc).
+		if p.Master || p.Folder || p.DistinguishedKind != 0 || p.Music {
+			continue
+		}
+		ids := make([]string, len(p.PlaylistItems))
+		for i, x := range p.PlaylistItems {
+			ids[i] = strconv.Itoa(x.TrackID)
+		}
+		m.Playlists = append(m.Playlists, Playlist{
+			Name:     html.UnescapeString(p.Name),
+			TrackIDs: ids,
+		})
+	}
+	return m
+}
+
+// Stores are the meta data stores used by Import.  Nil stores are skipped.
+type Stores struct {
+	History    history.Store
+	Favourites favourite.Store
+	Ratings    rating.Store
+	Playlists  playlist.Store
+}
+
+// ImportSummary is a summary of the data written by Import.
+type ImportSummary struct {
+	Ratings    int
+	Favourites int
+	Plays      int // last plays added to the play history
+	Playlists  int
+	Missing    int // tracks (with user data) which weren't found in the collection
+}
+
+// ratingValue converts an iTunes rating (0-100) into a rating.Value.
+func ratingValue(r int) rating.Value {
+	v := rating.Value((r + 10) / 20)
+	if !v.IsValid() {
+		return rating.None
+	}
+	return v
+}
+
+// trackPaths returns a map of track IDs to their paths in the collection c (which has
+// path "Root").
+func trackPaths(c index.Collection) map[string]index.Path {
+	paths := make(map[string]index.Path)
+	index.Walk(c, index.Path{"Root"}, func(t index.Track, p index.Path) error {
+		paths[t.GetString("ID")] = p
+		return nil
+	})
+	return paths
+}
+
+// containsTime returns true if the times include t.
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, x := range times {
+		if x.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Import writes the user data into the stores, using the collection c to find the path of
+// each track.  The collection should be the one used to create paths for the meta stores
+// (see index.RootGroups).  Track ratings and loved tracks are set on the track paths, and
+// album ratings on the album (root group) paths.  iTunes only keeps a play count and the
+// time of the last play, so only the last play is added to the play history (unless it is
+// already there, so importing again doesn't duplicate history): the play count is kept in
+// the library instead (see index.ImportedPlayCountField).  Playlists are created with one item
+// for each track, and replace existing playlists with the same name (except smart
+// playlists, which are skipped).
+func (m *Meta) Import(c index.Collection, s Stores) (ImportSummary, error) {
+	var sum ImportSummary
+	paths := trackPaths(c)
+
+	albums := make(map[string]bool)
+	for id, tm := range m.Tracks {
+		p, ok := paths[id]
+		if !ok {
+			sum.Missing++
+			continue
+		}
+
+		if s.Ratings != nil {
+			if v := ratingValue(tm.Rating); v != rating.None {
+				if err := s.Ratings.Set(p, v); err != nil {
+					return sum, err
+				}
+				sum.Ratings++
+			}
+			album := p[:2]
+			if v := ratingValue(tm.AlbumRating); v != rating.None && !albums[album.Encode()] {
+				albums[album.Encode()] = true
+				if err := s.Ratings.Set(album, v); err != nil {
+					return sum, err
+				}
+				sum.Ratings++
+			}
+		}
+
+		if s.Favourites != nil && tm.Loved {
+			if err := s.Favourites.Set(p, true); err != nil {
+				return sum, err
+			}
+			sum.Favourites++
+		}
+
+		if s.History != nil && tm.PlayCount > 0 && !tm.PlayDate.IsZero() && !containsTime(s.History.Get(p), tm.PlayDate) {
+			if err := s.History.AddTimes(p, []time.Time{tm.PlayDate}); err != nil {
+				return sum, err
+			}
+			sum.Plays++
+		}
+	}
+
+	if s.Playlists == nil {
+		return sum, nil
+	}
+
+	names := make(map[string]bool)
+	for _, x := range m.Playlists {
+		name := x.Name
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("%v (%d)", x.Name, i)
+		}
+		names[name] = true
+
+		if existing := s.Playlists.Get(name); existing != nil && existing.Smart() != nil {
+			continue
+		}
+
+		p := &playlist.Playlist{}
+		for _, id := range x.TrackIDs {
+			if path, ok := paths[id]; ok {
+				p.Add(path)
+			}
+		}
+		if len(p.Items()) == 0 {
+			continue
+		}
+		if err := s.Playlists.Set(name, p); err != nil {
+			return sum, err
+		}
+		sum.Playlists++
+	}
+	return sum, nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package itl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/favourite"
+	"tchaik.com/index/history"
+	"tchaik.com/index/indextest"
+	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
+)
+
+func TestMetaImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tchaik-itl")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var s Stores
+	if s.History, err = history.NewStore(filepath.Join(dir, "history.json")); err != nil {
+		t.Fatal(err)
+	}
+	if s.Favourites, err = favourite.NewStore(filepath.Join(dir, "favourites.json")); err != nil {
+		t.Fatal(err)
+	}
+	if s.Ratings, err = rating.NewStore(filepath.Join(dir, "ratings.json")); err != nil {
+		t.Fatal(err)
+	}
+	if s.Playlists, err = playlist.NewStore(filepath.Join(dir, "playlists.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	lib := indextest.Library{
+		{ID: "1", Name: "Prelude", Album: "Cello Suites"},
+		{ID: "2", Name: "Allemande", Album: "Cello Suites"},
+		{ID: "3", Name: "Symphony No. 1", Album: "Symphonies"},
+	}
+	root := index.RootGroups(index.NewRootCollection(lib))
+	played := time.Date(2014, 3, 1, 20, 0, 0, 0, time.UTC)
+
+	m := &Meta{
+		Tracks: map[string]TrackMeta{
+			"1": {Rating: 80, AlbumRating: 100},
+			"2": {Loved: true, PlayCount: 3, PlayDate: played},
+			"9": {Rating: 20},
+		},
+		Playlists: []Playlist{
+			{Name: "Bach", TrackIDs: []string{"2", "1", "9"}},
+			{Name: "Bach", TrackIDs: []string{"3"}},
+			{Name: "Empty", TrackIDs: []string{"9"}},
+		},
+	}
+
+	sum, err := m.Import(root, s)
+	if err != nil {
+		t.Fatalf("unexpected error from Import: %v", err)
+	}
+	expected := ImportSummary{Ratings: 2, Favourites: 1, Plays: 1, Playlists: 2, Missing: 1}
+	if sum != expected {
+		t.Errorf("Import() = %#v, expected: %#v", sum, expected)
+	}
+
+	paths := trackPaths(root)
+	if r := s.Ratings.Get(paths["1"]); r != 4 {
+		t.Errorf("rating of track 1 = %d, expected: 4", r)
+	}
+	if r := s.Ratings.Get(paths["1"][:2]); r != 5 {
+		t.Errorf("rating of album of track 1 = %d, expected: 5", r)
+	}
+	if !s.Favourites.Get(paths["2"]) {
+		t.Errorf("expected track 2 to be a favourite")
+	}
+	if p := s.Playlists.Get("Bach (2)"); p == nil || len(p.Items()) != 1 {
+		t.Errorf("expected playlist 'Bach (2)' with 1 item, got: %v", p)
+	}
+	if p := s.Playlists.Get("Empty"); p != nil {
+		t.Errorf("expected empty playlist to be skipped")
+	}
+
+	p := s.Playlists.Get("Bach")
+	if p == nil || len(p.Items()) != 2 {
+		t.Fatalf("expected playlist 'Bach' with 2 items, got: %v", p)
+	}
+	got, err := playlist.Paths(p.Items()[0], root)
+	if err != nil || len(got) != 1 || !got[0].Equal(paths["2"]) {
+		t.Errorf("playlist.Paths(first item) = %v (err: %v), expected: %v", got, err, paths["2"])
+	}
+
+	// Importing again shouldn't duplicate the play history.
+	_, err = m.Import(root, s)
+	if err != nil {
+		t.Fatalf("unexpected error from Import: %v", err)
+	}
+	if got := s.History.Get(paths["2"]); len(got) != 1 || !got[0].Equal(played) {
+		t.Errorf("history of track 2 = %v, expected: [%v]", got, played)
+	}
+}
+
+const testLibraryXML = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Tracks</key>
+	<dict>
+		<key>1</key>
+		<dict>
+			<key>Track ID</key><integer>1</integer>
+			<key>Name</key><string>Prelude</string>
+			<key>Album</key><string>Cello Suites</string>
+			<key>Kind</key><string>MPEG audio file</string>
+			<key>Play Count</key><integer>3</integer>
+			<key>Play Date UTC</key><date>2014-03-01T20:00:00Z</date>
+			<key>Rating</key><integer>80</integer>
+			<key>Track Type</key><string>File</string>
+		</dict>
+		<key>2</key>
+		<dict>
+			<key>Track ID</key><integer>2</integer>
+			<key>Name</key><string>Allemande</string>
+			<key>Album</key><string>Cello Suites</string>
+			<key>Kind</key><string>MPEG audio file</string>
+			<key>Track Type</key><string>File</string>
+		</dict>
+	</dict>
+	<key>Playlists</key>
+	<array>
+		<dict>
+			<key>Name</key><string>Library</string>
+			<key>Master</key><true/>
+			<key>Visible</key><false/>
+			<key>Playlist Items</key>
+			<array>
+				<dict><key>Track ID</key><integer>1</integer></dict>
+				<dict><key>Track ID</key><integer>2</integer></dict>
+			</array>
+		</dict>
+		<dict>
+			<key>Name</key><string>Music</string>
+			<key>Distinguished Kind</key><integer>4</integer>
+			<key>Music</key><true/>
+			<key>Playlist Items</key>
+			<array>
+				<dict><key>Track ID</key><integer>1</integer></dict>
+			</array>
+		</dict>
+		<dict>
+			<key>Name</key><string>Bach</string>
+			<key>Folder</key><true/>
+		</dict>
+		<dict>
+			<key>Name</key><string>Favourite Suites</string>
+			<key>Playlist Items</key>
+			<array>
+				<dict><key>Track ID</key><integer>2</integer></dict>
+				<dict><key>Track ID</key><integer>1</integer></dict>
+			</array>
+		</dict>
+	</array>
+</dict>
+</plist>
+`
+
+func TestReadWithMeta(t *testing.T) {
+	_, m, err := ReadWithMeta(strings.NewReader(testLibraryXML))
+	if err != nil {
+		t.Fatalf("unexpected error from ReadWithMeta: %v", err)
+	}
+
+	expectedTracks := map[string]TrackMeta{
+		"1": {Rating: 80, PlayCount: 3, PlayDate: time.Date(2014, 3, 1, 20, 0, 0, 0, time.UTC)},
+	}
+	for id, tm := range m.Tracks {
+		tm.PlayDate = tm.PlayDate.UTC()
+		m.Tracks[id] = tm
+	}
+	if !reflect.DeepEqual(m.Tracks, expectedTracks) {
+		t.Errorf("Tracks = %#v, expected: %#v", m.Tracks, expectedTracks)
+	}
+
+	// The library, built-in playlists and folders are skipped.
+	expectedPlaylists := []Playlist{
+		{Name: "Favourite Suites", TrackIDs: []string{"2", "1"}},
+	}
+	if !reflect.DeepEqual(m.Playlists, expectedPlaylists) {
+		t.Errorf("Playlists = %#v, expected: %#v", m.Playlists, expectedPlaylists)
+	}
+}
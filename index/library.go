@@ -6,10 +6,8 @@
 package index
 
 import (
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
-	"io"
 	"time"
 )
 
@@ -24,7 +22,8 @@ type Library interface {
 }
 
 // Track is an interface which defines methods for retrieving track metadata.  Methods return
-// zero values if attributes are unset and panic on undefined attributes and type mismatches.
+// zero values if attributes are unset (including extra attributes, see RegisterField) and
+// panic on undefined attributes and type mismatches.
 type Track interface {
 	// GetString returns a string value for the given attribute name.  Panics
 	// if no such string attribute exists.
@@ -53,37 +52,62 @@ func Convert(l Library, id string) *library {
 
 	for _, t := range allTracks {
 		identifier := t.GetString(id)
-		tracks[identifier] = &track{
-			// string fields
-			ID:          identifier,
-			Name:        t.GetString("Name"),
-			Album:       t.GetString("Album"),
-			AlbumArtist: t.GetString("AlbumArtist"),
-			Artist:      t.GetString("Artist"),
-			Composer:    t.GetString("Composer"),
-			Genre:       t.GetString("Genre"),
-			Location:    t.GetString("Location"),
-			Kind:        t.GetString("Kind"),
-
-			// integer fields
-			TotalTime:   t.GetInt("TotalTime"),
-			Year:        t.GetInt("Year"),
-			DiscNumber:  t.GetInt("DiscNumber"),
-			TrackNumber: t.GetInt("TrackNumber"),
-			TrackCount:  t.GetInt("TrackCount"),
-			DiscCount:   t.GetInt("DiscCount"),
-			BitRate:     t.GetInt("BitRate"),
-
-			// date fields
-			DateAdded:    t.GetTime("DateAdded"),
-			DateModified: t.GetTime("DateModified"),
-		}
+		tracks[identifier] = newTrack(t, identifier)
 	}
 	return &library{
 		tracks,
 	}
 }
 
+// newTrack creates a new track by copying all the attributes from t (including extra
+// attributes, see RegisterField), using identifier as the value of ID.
+func newTrack(t Track, identifier string) *track {
+	nt := &track{
+		// string fields
+		ID:          identifier,
+		Name:        t.GetString("Name"),
+		Album:       t.GetString("Album"),
+		AlbumArtist: t.GetString("AlbumArtist"),
+		Artist:      t.GetString("Artist"),
+		Composer:    t.GetString("Composer"),
+		Genre:       t.GetString("Genre"),
+		Location:    t.GetString("Location"),
+		Kind:        t.GetString("Kind"),
+
+		// integer fields
+		TotalTime:   t.GetInt("TotalTime"),
+		Year:        t.GetInt("Year"),
+		DiscNumber:  t.GetInt("DiscNumber"),
+		TrackNumber: t.GetInt("TrackNumber"),
+		TrackCount:  t.GetInt("TrackCount"),
+		DiscCount:   t.GetInt("DiscCount"),
+		BitRate:     t.GetInt("BitRate"),
+		Size:        t.GetInt("Size"),
+
+		// date fields
+		DateAdded:    t.GetTime("DateAdded"),
+		DateModified: t.GetTime("DateModified"),
+	}
+
+	for _, f := range ExtraFields(StringField) {
+		if v := t.GetString(f); v != "" {
+			if nt.ExtraStrings == nil {
+				nt.ExtraStrings = make(map[string]string)
+			}
+			nt.ExtraStrings[f] = v
+		}
+	}
+	for _, f := range ExtraFields(IntField) {
+		if v := t.GetInt(f); v != 0 {
+			if nt.ExtraInts == nil {
+				nt.ExtraInts = make(map[string]int)
+			}
+			nt.ExtraInts[f] = v
+		}
+	}
+	return nt
+}
+
 // library is the default internal implementation Library which acts as the data
 // source for all media tracks.
 type library struct {
@@ -114,30 +138,6 @@ func (l *library) UnmarshalJSON(b []byte) error {
 	return json.Unmarshal(b, &l.trks)
 }
 
-// WriteTo writes the Library data to the writer, currently using gzipped-JSON.
-func WriteTo(l Library, w io.Writer) error {
-	gzw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-	if err != nil {
-		return err
-	}
-	defer gzw.Close()
-	enc := json.NewEncoder(gzw)
-	return enc.Encode(l)
-}
-
-// ReadFrom reads the gzipped-JSON representation of a Library.
-func ReadFrom(r io.Reader) (Library, error) {
-	gzr, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
-	}
-	defer gzr.Close()
-	dec := json.NewDecoder(gzr)
-	l := &library{}
-	err = dec.Decode(l)
-	return l, err
-}
-
 // track is the default implementation of the Track interface.
 type track struct {
 	ID          string `json:"id,omitempty"`
@@ -157,9 +157,14 @@ type track struct {
 	TrackCount  int `json:"trackCount,omitempty"`
 	DiscCount   int `json:"discCount,omitempty"`
 	BitRate     int `json:"bitRate,omitempty"`
+	Size        int `json:"size,omitempty"`
 
 	DateAdded    time.Time `json:"dateAdded,omitempty"`
 	DateModified time.Time `json:"dateModified,omitempty"`
+
+	// Extra attributes (see RegisterField).
+	ExtraStrings map[string]string `json:"extraStrings,omitempty"`
+	ExtraInts    map[string]int    `json:"extraInts,omitempty"`
 }
 
 // GetString implements Track.
@@ -184,6 +189,9 @@ func (t *track) GetString(name string) string {
 	case "Kind":
 		return t.Kind
 	}
+	if IsExtraField(name, StringField) {
+		return t.ExtraStrings[name]
+	}
 	panic(fmt.Sprintf("unknown string field '%v'", name))
 }
 
@@ -204,6 +212,9 @@ func (t *track) GetStrings(name string) []string {
 	case "Artist", "AlbumArtist", "Composer":
 		return DefaultGetStrings(t, name)
 	}
+	if IsExtraField(name, StringField) {
+		return DefaultGetStrings(t, name)
+	}
 	panic(fmt.Sprintf("unknown strings field '%v", name))
 }
 
@@ -224,6 +235,11 @@ func (t *track) GetInt(name string) int {
 		return t.DiscCount
 	case "BitRate":
 		return t.BitRate
+	case "Size":
+		return t.Size
+	}
+	if IsExtraField(name, IntField) {
+		return t.ExtraInts[name]
 	}
 	panic(fmt.Sprintf("unknown int field '%v'", name))
 }
@@ -25,6 +25,7 @@ var tr = track{
 	TrackCount:  5,
 	DiscCount:   6,
 	BitRate:     7,
+	Size:        8,
 
 	DateAdded:    time.Now(),
 	DateModified: time.Now(),
@@ -70,7 +71,7 @@ func TestTrack(t *testing.T) {
 		t.Errorf("expected panic from GetStrings, got: %v", y)
 	}()
 
-	intFields := []string{"TotalTime", "Year", "DiscNumber", "TrackNumber", "TrackCount", "DiscCount", "BitRate"}
+	intFields := []string{"TotalTime", "Year", "DiscNumber", "TrackNumber", "TrackCount", "DiscCount", "BitRate", "Size"}
 	for i, f := range intFields {
 		got := tr.GetInt(f)
 		expected := i + 1
@@ -102,6 +103,76 @@ func TestTrack(t *testing.T) {
 	}()
 }
 
+func TestTrackExtraFields(t *testing.T) {
+	et := track{
+		ExtraStrings: map[string]string{
+			"Conductor": "Conductor",
+		},
+		ExtraInts: map[string]int{
+			"BPM": 120,
+		},
+	}
+
+	if got := et.GetString("Conductor"); got != "Conductor" {
+		t.Errorf("et.GetString(%#v) = %#v, expected %#v", "Conductor", got, "Conductor")
+	}
+	if got := et.GetStrings("Conductor"); !reflect.DeepEqual(got, []string{"Conductor"}) {
+		t.Errorf("et.GetStrings(%#v) = %#v, expected %#v", "Conductor", got, []string{"Conductor"})
+	}
+	if got := et.GetInt("BPM"); got != 120 {
+		t.Errorf("et.GetInt(%#v) = %d, expected %d", "BPM", got, 120)
+	}
+
+	// Unset (but valid) extra fields should return zero values.
+	if got := et.GetString("Orchestra"); got != "" {
+		t.Errorf("et.GetString(%#v) = %#v, expected %#v", "Orchestra", got, "")
+	}
+	if got := et.GetStrings("Orchestra"); got != nil {
+		t.Errorf("et.GetStrings(%#v) = %#v, expected nil", "Orchestra", got)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic from type mismatch")
+			}
+		}()
+
+		y := et.GetInt("Conductor")
+		t.Errorf("expected panic from GetInt, got: %v", y)
+	}()
+
+	// Register a field which only this test uses, and remove it afterwards so that the
+	// registry is unchanged for other tests.
+	const testField = "TestExtraFieldsLabel"
+	RegisterField(testField, StringField)
+	defer func() {
+		extraFields.Lock()
+		delete(extraFields.m, testField)
+		extraFields.Unlock()
+	}()
+	if got := et.GetString(testField); got != "" {
+		t.Errorf("et.GetString(%#v) = %#v, expected %#v", testField, got, "")
+	}
+
+	tl := testLibrary{
+		tr: &et,
+	}
+	buf := &bytes.Buffer{}
+	err := WriteTo(Convert(tl, "ID"), buf)
+	if err != nil {
+		t.Fatalf("unexpected error in WriteTo: %v", err)
+	}
+	l, err := ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error in ReadFrom: %v", err)
+	}
+	got := l.Tracks()[0]
+	if got.GetString("Conductor") != "Conductor" || got.GetInt("BPM") != 120 {
+		t.Errorf("Encode -> Decode lost extra fields, got: %#v", got)
+	}
+}
+
 type testLibrary struct {
 	tr *track
 }
@@ -167,6 +238,10 @@ func TestLibraryEncodeDecode(t *testing.T) {
 	gotTrack.DateAdded = gotTrack.DateAdded.Local()
 	gotTrack.DateModified = gotTrack.DateModified.Local()
 
+	// Encoding drops the monotonic clock reading.
+	expectedTrack.DateAdded = expectedTrack.DateAdded.Round(0)
+	expectedTrack.DateModified = expectedTrack.DateModified.Round(0)
+
 	if !reflect.DeepEqual(expectedTrack, gotTrack) {
 		t.Errorf("Encode -> Decode inconsistent, got: %#v, expected: %#v", gotTrack, expectedTrack)
 	}
@@ -0,0 +1,285 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package migrate implements methods for remapping the paths used by the meta data stores
+// (play history, favourites, checklist, ratings, playlists and cursors) when tracks move
+// within the library, i.e. when files have been reorganised or albums have been renamed.
+package migrate
+
+import (
+	"sort"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/cursor"
+	"tchaik.com/index/history"
+	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
+)
+
+// Paths maps paths in one version of a library onto another.
+type Paths struct {
+	from, to index.Collection
+
+	tracks map[string]index.Path // track paths in from -> track paths in to
+	groups map[string]index.Path // group paths in from -> group paths in to (nil if not mapped)
+
+	changed int
+	missing map[string]index.Path
+}
+
+// NewPaths creates a Paths which maps paths in the collection from onto the collection to,
+// where both collections are the one used to create paths for the meta stores (see
+// index.RootGroups).  Tracks are matched by ID, or by Location if their ID isn't in to (i.e.
+// when the library has changed to use content-based IDs, see walk.Options).
+func NewPaths(from, to index.Collection) *Paths {
+	ids := make(map[string]index.Path)
+	locations := make(map[string]index.Path)
+	index.Walk(to, index.Path{"Root"}, func(t index.Track, p index.Path) error {
+		ids[t.GetString("ID")] = p
+		locations[t.GetString("Location")] = p
+		return nil
+	})
+
+	tracks := make(map[string]index.Path)
+	index.Walk(from, index.Path{"Root"}, func(t index.Track, p index.Path) error {
+		if np, ok := ids[t.GetString("ID")]; ok {
+			tracks[p.Encode()] = np
+			return nil
+		}
+		if np, ok := locations[t.GetString("Location")]; ok {
+			tracks[p.Encode()] = np
+		}
+		return nil
+	})
+
+	return &Paths{
+		from:    from,
+		to:      to,
+		tracks:  tracks,
+		groups:  make(map[string]index.Path),
+		missing: make(map[string]index.Path),
+	}
+}
+
+// Path returns the path in the new collection of the group or track with path p in the old
+// collection.  Groups are mapped to the group in the new collection which contains exactly
+// the same tracks (ignoring any tracks which are no longer in the library).  Returns false
+// if there is no such track or group.
+func (m *Paths) Path(p index.Path) (index.Path, bool) {
+	if len(p) < 2 || p[0] != "Root" {
+		return nil, false
+	}
+
+	k := p.Encode()
+	if np, ok := m.tracks[k]; ok {
+		return np, true
+	}
+	np, ok := m.groups[k]
+	if !ok {
+		np = m.group(p)
+		m.groups[k] = np
+	}
+	return np, np != nil
+}
+
+// group returns the path in the new collection of the group with path p in the old
+// collection, or nil if it can't be mapped.
+func (m *Paths) group(p index.Path) index.Path {
+	g, _, ok := lookup(m.from, p)
+	if !ok || g == nil {
+		return nil
+	}
+
+	var prefix index.Path
+	n := 0
+	index.Walk(g, p, func(_ index.Track, tp index.Path) error {
+		np, ok := m.tracks[tp.Encode()]
+		if !ok {
+			return nil
+		}
+		parent := np[:len(np)-1]
+		if n == 0 {
+			prefix = parent
+		} else {
+			prefix = commonPrefix(prefix, parent)
+		}
+		n++
+		return nil
+	})
+	if len(prefix) < 2 {
+		return nil
+	}
+
+	// Prefer a group at the same depth as the original: the tracks could all be within
+	// one sub-group (i.e. an album containing a single work).
+	if len(prefix) > len(p) && m.count(prefix[:len(p)]) == n {
+		return prefix[:len(p)]
+	}
+	if m.count(prefix) == n {
+		return prefix
+	}
+	return nil
+}
+
+// count returns the number of tracks in the group with path p in the new collection.
+func (m *Paths) count(p index.Path) int {
+	g, _, ok := lookup(m.to, p)
+	if !ok || g == nil {
+		return -1
+	}
+	n := 0
+	index.Walk(g, p, func(index.Track, index.Path) error {
+		n++
+		return nil
+	})
+	return n
+}
+
+// commonPrefix returns the longest common prefix of p and q.
+func commonPrefix(p, q index.Path) index.Path {
+	i := 0
+	for i < len(p) && i < len(q) && p[i] == q[i] {
+		i++
+	}
+	return p[:i]
+}
+
+// Rewrite returns the new path for p, or p if it can't be mapped (so that it can be fixed
+// later, see Guess).  Implements RewriteFn.
+func (m *Paths) Rewrite(p index.Path) (index.Path, bool) {
+	if len(p) == 0 {
+		return p, true
+	}
+	np, ok := m.Path(p)
+	if !ok {
+		m.missing[p.Encode()] = p
+		return p, true
+	}
+	if !np.Equal(p) {
+		m.changed++
+	}
+	return np, true
+}
+
+// Summary is a summary of the paths rewritten by Paths.
+type Summary struct {
+	Changed int          // number of paths which were changed
+	Missing []index.Path // paths which couldn't be mapped (and so were left unchanged)
+}
+
+// Summary returns a summary of all the paths which have been rewritten.
+func (m *Paths) Summary() Summary {
+	missing := make([]index.Path, 0, len(m.missing))
+	for _, p := range m.missing {
+		missing = append(missing, p)
+	}
+	sort.Sort(index.PathSlice(missing))
+
+	return Summary{
+		Changed: m.changed,
+		Missing: missing,
+	}
+}
+
+// RewriteFn is a function which returns the new path for p, or false if p should be
+// removed.
+type RewriteFn func(p index.Path) (index.Path, bool)
+
+// History rewrites the paths in the play history (as stored by history.NewStore).  Play
+// times are merged when paths are rewritten to the same path.
+func History(h map[string][]time.Time, fn RewriteFn) map[string][]time.Time {
+	result := make(map[string][]time.Time, len(h))
+	for k, times := range h {
+		p, ok := fn(index.NewPath(k))
+		if !ok {
+			continue
+		}
+		nk := p.Encode()
+		result[nk] = history.MergeTimes(result[nk], times)
+	}
+	return result
+}
+
+// Bools rewrites the paths in the map (as stored by favourite.NewStore and
+// checklist.NewStore).  Values are combined using "or" when paths are rewritten to the same
+// path.
+func Bools(b map[string]bool, fn RewriteFn) map[string]bool {
+	result := make(map[string]bool, len(b))
+	for k, v := range b {
+		p, ok := fn(index.NewPath(k))
+		if !ok {
+			continue
+		}
+		nk := p.Encode()
+		result[nk] = result[nk] || v
+	}
+	return result
+}
+
+// Ratings rewrites the paths in the ratings (as stored by rating.NewStore).  The highest
+// rating is kept when paths are rewritten to the same path.
+func Ratings(r map[string]rating.Value, fn RewriteFn) map[string]rating.Value {
+	result := make(map[string]rating.Value, len(r))
+	for k, v := range r {
+		p, ok := fn(index.NewPath(k))
+		if !ok {
+			continue
+		}
+		nk := p.Encode()
+		if _, done := result[nk]; !done || v > result[nk] {
+			result[nk] = v
+		}
+	}
+	return result
+}
+
+// Playlist rewrites the paths of the items in the playlist (see playlist.RewritePaths).
+// Returns the new index of each item (-1 if it was removed), which should be passed to
+// Cursor for the cursor of the playlist.
+func Playlist(p *playlist.Playlist, fn RewriteFn) []int {
+	return p.RewritePaths(fn)
+}
+
+// Cursor rewrites the paths of the positions of the cursor, where items gives the new index
+// of each item in the playlist (see Playlist), or is nil if the items haven't changed.
+// Positions are cleared if their path or item is removed.
+func Cursor(c *cursor.Cursor, fn RewriteFn, items []int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.Current = position(c.Current, fn, items)
+	c.Next = position(c.Next, fn, items)
+	c.Previous = position(c.Previous, fn, items)
+
+	order := make([]cursor.Position, 0, len(c.Order))
+	for _, x := range c.Order {
+		if x = position(x, fn, items); !x.Empty() {
+			order = append(order, x)
+		}
+	}
+	if len(c.Order) > 0 {
+		c.Order = order
+	}
+}
+
+// position returns the position rewritten using fn and items (see Cursor), or an empty
+// position if it has been removed.
+func position(x cursor.Position, fn RewriteFn, items []int) cursor.Position {
+	if x.Empty() {
+		return x
+	}
+	i := x.Index
+	if items != nil {
+		if i < 0 || i >= len(items) || items[i] == -1 {
+			return cursor.Position{}
+		}
+		i = items[i]
+	}
+	p, ok := fn(x.Path)
+	if !ok {
+		return cursor.Position{}
+	}
+	return cursor.Position{Path: p, Index: i}
+}
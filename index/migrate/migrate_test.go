@@ -0,0 +1,139 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/indextest"
+)
+
+func root(l indextest.Library) index.Collection {
+	return index.RootGroups(index.NewRootCollection(l))
+}
+
+// trackPath returns the path of the track with the ID in the collection.
+func trackPath(c index.Collection, id string) index.Path {
+	var path index.Path
+	index.Walk(c, index.Path{"Root"}, func(t index.Track, p index.Path) error {
+		if t.GetString("ID") == id {
+			path = p
+		}
+		return nil
+	})
+	return path
+}
+
+func TestPaths(t *testing.T) {
+	from := root(indextest.Library{
+		{ID: "a", Name: "Prelude", Album: "Suite", Location: "/music/Suite/01.mp3"},
+		{ID: "b", Name: "Allemande", Album: "Suite", Location: "/music/Suite/02.mp3"},
+		{ID: "c", Name: "Sonata", Album: "Sonatas", Location: "/music/Sonatas/01.mp3"},
+		{ID: "d", Name: "Partita", Album: "Partitas", Location: "/music/Partitas/01.mp3"},
+	})
+	to := root(indextest.Library{
+		{ID: "a", Name: "Prelude", Album: "Cello Suite", Location: "/music/Bach/Cello Suite/01.mp3"},
+		{ID: "b", Name: "Allemande", Album: "Cello Suite", Location: "/music/Bach/Cello Suite/02.mp3"},
+		{ID: "c2", Name: "Sonata", Album: "Sonatas", Location: "/music/Sonatas/01.mp3"},
+	})
+	m := NewPaths(from, to)
+
+	tests := []struct {
+		in, out index.Path
+		ok      bool
+	}{
+		{trackPath(from, "a"), trackPath(to, "a"), true},
+		{trackPath(from, "b"), trackPath(to, "b"), true},
+		{trackPath(from, "c"), trackPath(to, "c2"), true}, // matched by location
+		{trackPath(from, "a")[:2], trackPath(to, "a")[:2], true},
+		{trackPath(from, "d"), nil, false},
+		{trackPath(from, "d")[:2], nil, false},
+		{index.Path{"Root", "missing"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := m.Path(tt.in)
+		if ok != tt.ok || !got.Equal(tt.out) {
+			t.Errorf("Path(%v) = (%v, %v), expected: (%v, %v)", tt.in, got, ok, tt.out, tt.ok)
+		}
+	}
+}
+
+func TestPathsStores(t *testing.T) {
+	from := root(indextest.Library{
+		{ID: "a", Name: "Prelude", Album: "Suite", Location: "/music/Suite/01.mp3"},
+		{ID: "b", Name: "Partita", Album: "Partitas", Location: "/music/Partitas/01.mp3"},
+	})
+	to := root(indextest.Library{
+		{ID: "a", Name: "Prelude", Album: "Cello Suite", Location: "/music/Cello Suite/01.mp3"},
+	})
+	m := NewPaths(from, to)
+
+	a, na := trackPath(from, "a"), trackPath(to, "a")
+	album, newAlbum := a[:2], na[:2]
+	missing := trackPath(from, "b")
+
+	t0 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	h := History(map[string][]time.Time{
+		a.Encode():       {t1, t0},
+		missing.Encode(): {t0},
+	}, m.Rewrite)
+	expectedHistory := map[string][]time.Time{
+		na.Encode():      {t0, t1},
+		missing.Encode(): {t0},
+	}
+	if !reflect.DeepEqual(h, expectedHistory) {
+		t.Errorf("History() = %v, expected: %v", h, expectedHistory)
+	}
+
+	b := Bools(map[string]bool{album.Encode(): true}, m.Rewrite)
+	expectedBools := map[string]bool{newAlbum.Encode(): true}
+	if !reflect.DeepEqual(b, expectedBools) {
+		t.Errorf("Bools() = %v, expected: %v", b, expectedBools)
+	}
+
+	s := m.Summary()
+	if s.Changed != 2 {
+		t.Errorf("Summary().Changed = %d, expected: 2", s.Changed)
+	}
+	if len(s.Missing) != 1 || !s.Missing[0].Equal(missing) {
+		t.Errorf("Summary().Missing = %v, expected: %v", s.Missing, []index.Path{missing})
+	}
+}
+
+func TestGuess(t *testing.T) {
+	from := root(indextest.Library{
+		{ID: "a", Name: "Prelude", Album: "Cello Suites", Location: "/old/01.mp3"},
+		{ID: "b", Name: "Allemande", Album: "Cello Suites", Location: "/old/02.mp3"},
+		{ID: "c", Name: "Sonata", Album: "Sonatas", Location: "/old/03.mp3"},
+	})
+	to := root(indextest.Library{
+		{ID: "x", Name: "Allemande", Album: "Cello Suites (Remastered)", Location: "/new/02.mp3"},
+		{ID: "y", Name: "Prelude", Album: "Cello Suites (Remastered)", Location: "/new/01.mp3"},
+		{ID: "z", Name: "Concerto", Album: "Concertos", Location: "/new/03.mp3"},
+	})
+	m := NewPaths(from, to)
+
+	tests := []struct {
+		in, out index.Path
+		ok      bool
+	}{
+		{trackPath(from, "a"), trackPath(to, "y"), true},
+		{trackPath(from, "b"), trackPath(to, "x"), true},
+		{trackPath(from, "a")[:2], trackPath(to, "y")[:2], true},
+		{trackPath(from, "c"), nil, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := m.Guess(tt.in)
+		if ok != tt.ok || !got.Equal(tt.out) {
+			t.Errorf("Guess(%v) = (%v, %v), expected: (%v, %v)", tt.in, got, ok, tt.out, tt.ok)
+		}
+	}
+}
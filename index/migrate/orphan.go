@@ -0,0 +1,171 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"tchaik.com/index"
+)
+
+// lookup returns the group or track with path p in the collection c (see index.RootGroups).
+// Returns false if there is no such group or track.
+func lookup(c index.Collection, p index.Path) (index.Group, index.Track, bool) {
+	if len(p) < 2 || p[0] != "Root" {
+		return nil, nil, false
+	}
+
+	var g index.Group = c
+	for i, k := range p[1:] {
+		gc, ok := g.(index.Collection)
+		if !ok {
+			// Track paths are the path of their group followed by their index.
+			if i != len(p)-2 {
+				return nil, nil, false
+			}
+			n, err := strconv.Atoi(string(k))
+			tracks := g.Tracks()
+			if err != nil || n < 0 || n >= len(tracks) {
+				return nil, nil, false
+			}
+			return nil, tracks[n], true
+		}
+		if !hasKey(gc, k) {
+			return nil, nil, false
+		}
+		g = gc.Get(k)
+	}
+	return g, nil, true
+}
+
+// hasKey returns true if k is one of the keys of the collection.
+func hasKey(c index.Collection, k index.Key) bool {
+	for _, x := range c.Keys() {
+		if x == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the name of the group or track with path p in the collection c (see
+// index.RootGroups), and false if there is no such group or track.
+func Name(c index.Collection, p index.Path) (string, bool) {
+	g, t, ok := lookup(c, p)
+	if !ok {
+		return "", false
+	}
+	if t != nil {
+		return t.GetString("Name"), true
+	}
+	return g.Name(), true
+}
+
+// Exists returns true if p is the path of a group or track in the collection c.
+func Exists(c index.Collection, p index.Path) bool {
+	_, ok := Name(c, p)
+	return ok
+}
+
+// minSimilarity is the minimum similarity (see similarity) of names matched by Guess.
+const minSimilarity = 0.5
+
+// similarity returns a score between 0 and 1 for how similar the names a and b are (ignoring
+// case), based on their edit distance.  Names which contain the other score at least 0.75.
+func similarity(a, b string) float64 {
+	a, b = strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b))
+	if a == b {
+		return 1
+	}
+	if a == "" || b == "" {
+		return 0
+	}
+
+	n := utf8.RuneCountInString(a)
+	if m := utf8.RuneCountInString(b); m > n {
+		n = m
+	}
+	s := 1 - float64(index.EditDistance(a, b))/float64(n)
+	if (strings.Contains(a, b) || strings.Contains(b, a)) && s < 0.75 {
+		s = 0.75
+	}
+	return s
+}
+
+// Guess returns the best-guess replacement in the new collection for the path p in the old
+// collection: the path given by Path if there is one, otherwise the path found by matching
+// the name of each group (and track) along p with the most similar name at the same level in
+// the new collection.  Returns false if there are no similar enough names (or p isn't in the
+// old collection).
+func (m *Paths) Guess(p index.Path) (index.Path, bool) {
+	if np, ok := m.Path(p); ok {
+		return np, true
+	}
+
+	names := make([]string, 0, len(p))
+	for i := 2; i <= len(p); i++ {
+		name, ok := Name(m.from, p[:i])
+		if !ok {
+			return nil, false
+		}
+		names = append(names, name)
+	}
+
+	np := index.Path{"Root"}
+	var g index.Group = m.to
+	for i, name := range names {
+		c, ok := g.(index.Collection)
+		if !ok {
+			// Only the last name can be a track.
+			if i != len(names)-1 {
+				return nil, false
+			}
+			n, ok := bestTrack(g.Tracks(), name)
+			if !ok {
+				return nil, false
+			}
+			return append(np, index.Key(strconv.Itoa(n))), true
+		}
+
+		k, ok := bestKey(c, name)
+		if !ok {
+			return nil, false
+		}
+		np = append(np, k)
+		g = c.Get(k)
+	}
+	return np, true
+}
+
+// bestKey returns the key of the group in the collection with the name most similar to name.
+func bestKey(c index.Collection, name string) (index.Key, bool) {
+	var best index.Key
+	max := minSimilarity
+	found := false
+	for _, k := range c.Keys() {
+		g := c.Get(k)
+		if g == nil {
+			continue
+		}
+		if s := similarity(g.Name(), name); s > max || !found && s == max {
+			best, max, found = k, s, true
+		}
+	}
+	return best, found
+}
+
+// bestTrack returns the index of the track with the name most similar to name.
+func bestTrack(tracks []index.Track, name string) (int, bool) {
+	best := -1
+	max := minSimilarity
+	for i, t := range tracks {
+		if s := similarity(t.GetString("Name"), name); s > max || best == -1 && s == max {
+			best, max = i, s
+		}
+	}
+	return best, best != -1
+}
@@ -0,0 +1,211 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/cursor"
+	"tchaik.com/index/db"
+	"tchaik.com/index/playlist"
+	"tchaik.com/index/rating"
+)
+
+// Stores is the data of all the meta stores, in the format used by the JSON file stores (and
+// the values in the database, see db.Export).  Nil maps are ignored.
+type Stores struct {
+	History    map[string][]time.Time
+	Favourites map[string]bool
+	Checklist  map[string]bool
+	Ratings    map[string]rating.Value
+	Playlists  map[string]*playlist.Playlist
+	Cursors    map[string]*cursor.Cursor // keyed by playlist name
+}
+
+// Rewrite rewrites all the paths in the stores using fn.  Cursors are updated to account for
+// any items removed from their playlist.
+func (s *Stores) Rewrite(fn RewriteFn) {
+	if s.History != nil {
+		s.History = History(s.History, fn)
+	}
+	if s.Favourites != nil {
+		s.Favourites = Bools(s.Favourites, fn)
+	}
+	if s.Checklist != nil {
+		s.Checklist = Bools(s.Checklist, fn)
+	}
+	if s.Ratings != nil {
+		s.Ratings = Ratings(s.Ratings, fn)
+	}
+
+	items := make(map[string][]int, len(s.Playlists))
+	for name, p := range s.Playlists {
+		items[name] = Playlist(p, fn)
+	}
+	for name, c := range s.Cursors {
+		Cursor(c, fn, items[name])
+	}
+}
+
+// Entry is a path used by one of the stores.
+type Entry struct {
+	Store string // "history", "favourites", "checklist", "ratings", "playlists" or "cursors"
+	Path  index.Path
+}
+
+// Orphans returns the paths used by the stores which don't exist in the collection c (see
+// Exists), ordered by store and then path.  The items of smart playlists are ignored.
+func (s *Stores) Orphans(c index.Collection) []Entry {
+	var result []Entry
+	done := make(map[string]bool)
+	fn := func(store string) RewriteFn {
+		return func(p index.Path) (index.Path, bool) {
+			k := store + " " + p.Encode()
+			if !done[k] && len(p) > 0 && !Exists(c, p) {
+				done[k] = true
+				result = append(result, Entry{Store: store, Path: p})
+			}
+			return p, true
+		}
+	}
+
+	History(s.History, fn("history"))
+	Bools(s.Favourites, fn("favourites"))
+	Bools(s.Checklist, fn("checklist"))
+	Ratings(s.Ratings, fn("ratings"))
+	for _, p := range s.Playlists {
+		if p.Smart() != nil {
+			continue // items are regenerated when the playlist is refreshed
+		}
+		Playlist(p.Copy(), fn("playlists")) // RewritePaths updates the playlist in place
+	}
+	for _, x := range s.Cursors {
+		c := &cursor.Cursor{Current: x.Current, Next: x.Next, Previous: x.Previous, Order: x.Order}
+		Cursor(c, fn("cursors"), nil)
+	}
+
+	sort.Sort(entries(result))
+	return result
+}
+
+type entries []Entry
+
+func (e entries) Len() int      { return len(e) }
+func (e entries) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e entries) Less(i, j int) bool {
+	if e[i].Store != e[j].Store {
+		return e[i].Store < e[j].Store
+	}
+	return e[i].Path.Encode() < e[j].Path.Encode()
+}
+
+// Files are the paths of the JSON files used by each of the stores.  Empty paths are
+// skipped.
+type Files struct {
+	History, Favourites, Checklist, Ratings, Playlists, Cursors string
+}
+
+// store is a store's JSON file (or database bucket) and a pointer to its data.
+type store struct {
+	path, bucket string
+	v            interface{}
+}
+
+func (s *Stores) stores(f Files) []store {
+	return []store{
+		{f.History, db.HistoryBucket, &s.History},
+		{f.Favourites, db.FavouriteBucket, &s.Favourites},
+		{f.Checklist, db.ChecklistBucket, &s.Checklist},
+		{f.Ratings, db.RatingBucket, &s.Ratings},
+		{f.Playlists, db.PlaylistBucket, &s.Playlists},
+		{f.Cursors, db.CursorBucket, &s.Cursors},
+	}
+}
+
+// ReadFiles reads the data of the stores from the JSON files.  Files which don't exist are
+// skipped.
+func ReadFiles(f Files) (*Stores, error) {
+	s := &Stores{}
+	for _, x := range s.stores(f) {
+		if x.path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(x.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(data, x.v); err != nil {
+			return nil, fmt.Errorf("error decoding %v: %v", x.path, err)
+		}
+	}
+	return s, nil
+}
+
+// WriteFiles writes the data of the stores to the JSON files.  Each file is written to a
+// temporary file alongside it which is then renamed, so it is never left partially written.
+// Stores which weren't read (nil maps) are skipped.
+func (s *Stores) WriteFiles(f Files) error {
+	for _, x := range s.stores(f) {
+		if x.path == "" || reflect.ValueOf(x.v).Elem().IsNil() {
+			continue
+		}
+		data, err := json.Marshal(x.v)
+		if err != nil {
+			return err
+		}
+		tmp := x.path + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, x.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadDB reads the data of the stores from the database.
+func ReadDB(d *db.DB) (*Stores, error) {
+	s := &Stores{}
+	for _, x := range s.stores(Files{}) {
+		buf := &bytes.Buffer{}
+		if _, err := d.Export(x.bucket, buf); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(buf.Bytes(), x.v); err != nil {
+			return nil, fmt.Errorf("error decoding %v: %v", x.bucket, err)
+		}
+	}
+	return s, nil
+}
+
+// WriteDB replaces the data of the stores in the database in a single transaction.
+func (s *Stores) WriteDB(d *db.DB) error {
+	m := make(map[string]io.Reader)
+	for _, x := range s.stores(Files{}) {
+		data, err := json.Marshal(x.v)
+		if err != nil {
+			return err
+		}
+		m[x.bucket] = bytes.NewReader(data)
+	}
+	return d.ReplaceAll(m)
+}
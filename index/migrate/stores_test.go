@@ -0,0 +1,64 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"reflect"
+	"testing"
+
+	"tchaik.com/index"
+	"tchaik.com/index/cursor"
+	"tchaik.com/index/indextest"
+	"tchaik.com/index/playlist"
+)
+
+func TestStoresOrphansPrune(t *testing.T) {
+	c := root(indextest.Library{
+		{ID: "a", Name: "Prelude", Album: "Suite", Location: "/music/01.mp3"},
+		{ID: "b", Name: "Allemande", Album: "Suite", Location: "/music/02.mp3"},
+	})
+	a, b := trackPath(c, "a"), trackPath(c, "b")
+	album := a[:2]
+	missing := index.Path{"Root", "000000"}
+	missingTrack := append(album[:2:2], "5")
+
+	p := &playlist.Playlist{}
+	p.Add(missing)
+	p.Add(album)
+	s := &Stores{
+		Favourites: map[string]bool{album.Encode(): true, missing.Encode(): true},
+		Playlists:  map[string]*playlist.Playlist{"Default": p},
+		Cursors: map[string]*cursor.Cursor{
+			"Default": {Current: cursor.Position{Path: b, Index: 1}},
+		},
+		Checklist: map[string]bool{missingTrack.Encode(): true},
+	}
+
+	got := s.Orphans(c)
+	expected := []Entry{
+		{"checklist", missingTrack},
+		{"favourites", missing},
+		{"playlists", missing},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Orphans() = %v, expected: %v", got, expected)
+	}
+
+	s.Rewrite(func(p index.Path) (index.Path, bool) {
+		return p, Exists(c, p)
+	})
+	if len(s.Orphans(c)) != 0 {
+		t.Errorf("Orphans() after pruning = %v, expected none", s.Orphans(c))
+	}
+	if got := s.Favourites; !reflect.DeepEqual(got, map[string]bool{album.Encode(): true}) {
+		t.Errorf("Favourites = %v, expected: %v", got, map[string]bool{album.Encode(): true})
+	}
+	if n := len(p.Items()); n != 1 {
+		t.Errorf("len(Items()) = %d, expected: 1", n)
+	}
+	if got, expected := s.Cursors["Default"].Current, (cursor.Position{Path: b, Index: 0}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("cursor Current = %v, expected: %v", got, expected)
+	}
+}
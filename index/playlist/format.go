@@ -0,0 +1,261 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package playlist
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"tchaik.com/index"
+)
+
+// Format is a playlist file format.
+type Format string
+
+// Supported playlist file formats.
+const (
+	M3U8 Format = "m3u8"
+	PLS  Format = "pls"
+	XSPF Format = "xspf"
+)
+
+// Formats is a list of the supported playlist file formats.
+var Formats = []Format{M3U8, PLS, XSPF}
+
+// ParseFormat returns the Format with the given name (or file extension, i.e. "m3u",
+// ".xspf"), and true if successful, false otherwise.
+func ParseFormat(s string) (Format, bool) {
+	s = strings.ToLower(strings.TrimPrefix(s, "."))
+	if s == "m3u" {
+		return M3U8, true
+	}
+	for _, f := range Formats {
+		if s == string(f) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case M3U8:
+		return "audio/x-mpegurl; charset=utf-8"
+	case PLS:
+		return "audio/x-scpls"
+	case XSPF:
+		return "application/xspf+xml"
+	}
+	return "application/octet-stream"
+}
+
+// Entry is a track in a playlist file.
+type Entry struct {
+	Location string
+	Title    string
+	Artist   string
+	Album    string
+	Duration time.Duration
+}
+
+// Encode writes the playlist entries to w in the format f.
+func Encode(w io.Writer, f Format, title string, entries []Entry) error {
+	switch f {
+	case M3U8:
+		return encodeM3U(w, entries)
+	case PLS:
+		return encodePLS(w, entries)
+	case XSPF:
+		return encodeXSPF(w, title, entries)
+	}
+	return fmt.Errorf("unknown playlist format: %v", f)
+}
+
+// Decode reads playlist entries from r in the format f.
+func Decode(r io.Reader, f Format) ([]Entry, error) {
+	switch f {
+	case M3U8:
+		return decodeM3U(r)
+	case PLS:
+		return decodePLS(r)
+	case XSPF:
+		return decodeXSPF(r)
+	}
+	return nil, fmt.Errorf("unknown playlist format: %v", f)
+}
+
+// TrackPath is the path of the track URLs used as entry locations by Export (followed by the
+// track ID), i.e. where tchaik serves the media file of each track.
+const TrackPath = "/track/"
+
+// Export returns the playlist entries for the tracks in the Playlist, using the
+// Collection (which has path "Root") as the data source.  Entry locations are the URLs of
+// the tracks: base (i.e. "http://localhost:8080") followed by TrackPath and the track ID.
+// The Location field of tracks isn't used, as it would expose the paths of the media files.
+func Export(p *Playlist, c index.Collection, base string) ([]Entry, error) {
+	var entries []Entry
+	for _, item := range p.Items() {
+		paths, err := Paths(item, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			t, err := trackFromPath(c, path)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, Entry{
+				Location: base + TrackPath + t.GetString("ID"),
+				Title:    t.GetString("Name"),
+				Artist:   t.GetString("Artist"),
+				Album:    t.GetString("Album"),
+				Duration: time.Duration(t.GetInt("TotalTime")) * time.Millisecond,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// trackFromPath returns the track with the path p (i.e. the path of its group followed by
+// its index) in the collection c (which has path "Root").
+func trackFromPath(c index.Collection, p index.Path) (index.Track, error) {
+	if len(p) < 3 {
+		return nil, fmt.Errorf("invalid track path: %v", p)
+	}
+	g, err := index.GroupFromPath(c, p[1:len(p)-1])
+	if err != nil {
+		return nil, err
+	}
+	i, err := strconv.Atoi(string(p[len(p)-1]))
+	tracks := g.Tracks()
+	if err != nil || i < 0 || i >= len(tracks) {
+		return nil, fmt.Errorf("invalid track path: %v", p)
+	}
+	return tracks[i], nil
+}
+
+// normaliseLocation converts file URLs into paths, and cleans the path (using forward
+// slashes as the separator).
+func normaliseLocation(l string) string {
+	if l == "" {
+		return ""
+	}
+	if strings.HasPrefix(l, "file:") {
+		if u, err := url.Parse(l); err == nil {
+			l = u.Path
+		}
+	}
+	return path.Clean(strings.Replace(l, `\`, "/", -1))
+}
+
+// locationIndex maps track locations (and IDs) to their paths.
+type locationIndex struct {
+	ids   map[string]index.Path // track ID -> path
+	paths map[string]index.Path // normalised location -> path
+	names map[string][]string   // file name -> normalised locations
+}
+
+func newLocationIndex(c index.Collection) *locationIndex {
+	li := &locationIndex{
+		ids:   make(map[string]index.Path),
+		paths: make(map[string]index.Path),
+		names: make(map[string][]string),
+	}
+	index.Walk(c, index.Path{"Root"}, func(t index.Track, p index.Path) error {
+		if id := t.GetString("ID"); id != "" {
+			if _, ok := li.ids[id]; !ok {
+				li.ids[id] = p
+			}
+		}
+		l := normaliseLocation(t.GetString("Location"))
+		if _, ok := li.paths[l]; !ok && l != "" {
+			li.paths[l] = p
+			base := path.Base(l)
+			li.names[base] = append(li.names[base], l)
+		}
+		return nil
+	})
+	return li
+}
+
+// find returns the path of the track at location l.  Track URLs (see Export) are matched by
+// track ID.  Locations which don't match exactly (i.e. relative locations, or locations from
+// another machine) are matched against the track with the same file name and the longest
+// common suffix, which must contain all of a relative location, or at least the file name
+// and its directory.
+func (li *locationIndex) find(l string) (index.Path, bool) {
+	if i := strings.LastIndex(l, TrackPath); i >= 0 {
+		if p, ok := li.ids[l[i+len(TrackPath):]]; ok {
+			return p, true
+		}
+	}
+
+	l = normaliseLocation(l)
+	if l == "" {
+		return nil, false
+	}
+	if p, ok := li.paths[l]; ok {
+		return p, true
+	}
+
+	var match string
+	var n int
+	for _, x := range li.names[path.Base(l)] {
+		if k := commonSuffix(x, l); k > n {
+			match, n = x, k
+		}
+	}
+	if match == "" {
+		return nil, false
+	}
+
+	rel := l
+	for strings.HasPrefix(rel, "../") {
+		rel = rel[3:]
+	}
+	if !path.IsAbs(rel) && strings.HasSuffix(match, "/"+rel) {
+		return li.paths[match], true
+	}
+	if strings.Count(l[len(l)-n:], "/") >= 2 {
+		return li.paths[match], true
+	}
+	return nil, false
+}
+
+// commonSuffix returns the length of the longest common suffix of x and y.
+func commonSuffix(x, y string) int {
+	n := 0
+	for n < len(x) && n < len(y) && x[len(x)-1-n] == y[len(y)-1-n] {
+		n++
+	}
+	return n
+}
+
+// Import creates a Playlist from the playlist entries, matching entry locations to the
+// track URLs written by Export, or the Location field of the tracks in the Collection (which
+// has path "Root").  Each matched
+// entry becomes an item in the playlist.  Returns the Playlist and the entries which
+// couldn't be matched.
+func Import(entries []Entry, c index.Collection) (*Playlist, []Entry) {
+	li := newLocationIndex(c)
+
+	p := &Playlist{}
+	var missing []Entry
+	for _, e := range entries {
+		path, ok := li.find(e.Location)
+		if !ok {
+			missing = append(missing, e)
+			continue
+		}
+		p.Add(path)
+	}
+	return p, missing
+}
@@ -0,0 +1,171 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package playlist
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/attr"
+	"tchaik.com/index/indextest"
+)
+
+var formatTracks = indextest.Library{
+	{ID: "1", Name: "Symphony No. 1", Album: "Symphonies", Composer: "Johannes Brahms", Location: "/music/Brahms/Symphonies/01 Symphony No. 1.mp3"},
+	{ID: "2", Name: "Symphony No. 2", Album: "Symphonies", Composer: "Johannes Brahms", Location: "/music/Brahms/Symphonies/02 Symphony No. 2.mp3"},
+	{ID: "3", Name: "Cello Suite No. 1", Album: "Cello Suites", Composer: "J. S. Bach", Location: "/music/Bach/Cello Suites/01 Prelude.mp3"},
+	{ID: "4", Name: "Violin Partita No. 2", Album: "Partitas", Composer: "J. S. Bach", Location: "/music/Bach/Partitas/01 Prelude.mp3"},
+}
+
+var formatEntries = []Entry{
+	{Location: "/music/a/01 first.mp3", Title: "First", Artist: "Artist", Album: "Album", Duration: 61 * time.Second},
+	{Location: "/music/b/02 second & third.flac", Title: "Second", Duration: 5 * time.Second},
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, f := range Formats {
+		buf := &bytes.Buffer{}
+		err := Encode(buf, f, "Test", formatEntries)
+		if err != nil {
+			t.Errorf("[%v] unexpected error from Encode: %v", f, err)
+			continue
+		}
+
+		got, err := Decode(buf, f)
+		if err != nil {
+			t.Errorf("[%v] unexpected error from Decode: %v", f, err)
+			continue
+		}
+
+		expected := formatEntries
+		if f == XSPF {
+			expected = []Entry{formatEntries[0], formatEntries[1]}
+			expected[0].Location = "file:///music/a/01%20first.mp3"
+			expected[1].Location = "file:///music/b/02%20second%20&%20third.flac"
+		} else {
+			// M3U and PLS don't have an album field.
+			expected = []Entry{formatEntries[0], formatEntries[1]}
+			expected[0].Album = ""
+		}
+
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("[%v] Decode(Encode(x)) = %#v, expected: %#v", f, got, expected)
+		}
+	}
+}
+
+func TestDecodeM3U(t *testing.T) {
+	in := "\ufeff# comment\r\nfirst.mp3\r\n\r\n#EXTINF:-1,Some Title\r\nhttp://example.com/stream\r\n"
+	got, err := Decode(strings.NewReader(in), M3U8)
+	if err != nil {
+		t.Fatalf("unexpected error from Decode: %v", err)
+	}
+	expected := []Entry{
+		{Location: "first.mp3"},
+		{Location: "http://example.com/stream", Title: "Some Title"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Decode() = %#v, expected: %#v", got, expected)
+	}
+}
+
+func TestDecodePLS(t *testing.T) {
+	in := "[playlist]\nFile2=b.mp3\nfile1=a.mp3\nTitle1=A\nLength1=10\nTitle3=No file\nNumberOfEntries=2\nVersion=2\n"
+	got, err := Decode(strings.NewReader(in), PLS)
+	if err != nil {
+		t.Fatalf("unexpected error from Decode: %v", err)
+	}
+	expected := []Entry{
+		{Location: "a.mp3", Title: "A", Duration: 10 * time.Second},
+		{Location: "b.mp3"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Decode() = %#v, expected: %#v", got, expected)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in string
+		f  Format
+		ok bool
+	}{
+		{"m3u8", M3U8, true},
+		{".m3u", M3U8, true},
+		{"PLS", PLS, true},
+		{".xspf", XSPF, true},
+		{"wpl", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		f, ok := ParseFormat(tt.in)
+		if f != tt.f || ok != tt.ok {
+			t.Errorf("ParseFormat(%#v) = (%#v, %v), expected: (%#v, %v)", tt.in, f, ok, tt.f, tt.ok)
+		}
+	}
+}
+
+// entryNames returns the names of the tracks in the playlist.
+func entryNames(t *testing.T, p *Playlist, c index.Collection) []string {
+	entries, err := Export(p, c, "")
+	if err != nil {
+		t.Fatalf("unexpected error from Export: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Title)
+	}
+	return names
+}
+
+func TestImportExport(t *testing.T) {
+	c := index.Collect(formatTracks, index.By(attr.String("Album")))
+
+	entries := []Entry{
+		{Location: "/music/Bach/Partitas/01 Prelude.mp3"},
+		{Location: "file:///music/Brahms/Symphonies/02%20Symphony%20No.%202.mp3"},
+		{Location: "../Bach/Cello Suites/01 Prelude.mp3"},
+		{Location: `C:\Users\Music\Brahms\Symphonies\01 Symphony No. 1.mp3`},
+		{Location: "/elsewhere/01 Prelude.mp3"},
+		{Location: "/music/Missing.mp3"},
+		{Location: "http://localhost:8080/track/3"},
+		{Location: "http://localhost:8080/track/5"},
+	}
+
+	p, missing := Import(entries, c)
+	expectedMissing := []Entry{entries[4], entries[5], entries[7]}
+	if !reflect.DeepEqual(missing, expectedMissing) {
+		t.Errorf("Import() missing = %#v, expected: %#v", missing, expectedMissing)
+	}
+
+	got := entryNames(t, p, c)
+	expected := []string{"Violin Partita No. 2", "Symphony No. 2", "Cello Suite No. 1", "Symphony No. 1", "Cello Suite No. 1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Export(Import()) = %#v, expected: %#v", got, expected)
+	}
+
+	exported, err := Export(p, c, "http://localhost:8080")
+	if err != nil {
+		t.Fatalf("unexpected error from Export: %v", err)
+	}
+	if got, expected := exported[0].Location, "http://localhost:8080/track/4"; got != expected {
+		t.Errorf("Export() location = %#v, expected: %#v", got, expected)
+	}
+
+	err = p.Remove(1, p.Items()[1].path)
+	if err != nil {
+		t.Errorf("unexpected error removing track item: %v", err)
+	}
+	got = entryNames(t, p, c)
+	expected = []string{"Violin Partita No. 2", "Cello Suite No. 1", "Symphony No. 1", "Cello Suite No. 1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Export() after Remove = %#v, expected: %#v", got, expected)
+	}
+}
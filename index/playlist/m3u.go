@@ -0,0 +1,77 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entryName returns the display name of the entry used in M3U and PLS files.
+func entryName(e Entry) string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
+
+// setEntryName sets the artist and title of the entry from its display name.
+func setEntryName(e *Entry, name string) {
+	e.Title = name
+	if i := strings.Index(name, " - "); i >= 0 {
+		e.Artist, e.Title = name[:i], name[i+3:]
+	}
+}
+
+// encodeM3U writes the entries as an extended M3U (UTF-8) playlist.
+func encodeM3U(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	for _, e := range entries {
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", int(e.Duration/time.Second), entryName(e))
+		fmt.Fprintln(bw, e.Location)
+	}
+	return bw.Flush()
+}
+
+// decodeM3U reads entries from a (possibly extended) M3U playlist.
+func decodeM3U(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var e Entry
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(s.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXTINF:") {
+			info := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)
+			if n, err := strconv.Atoi(strings.TrimSpace(info[0])); err == nil && n > 0 {
+				e.Duration = time.Duration(n) * time.Second
+			}
+			if len(info) == 2 {
+				setEntryName(&e, strings.TrimSpace(info[1]))
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		e.Location = line
+		entries = append(entries, e)
+		e = Entry{}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -8,6 +8,7 @@ package playlist
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"tchaik.com/index"
 )
@@ -40,6 +41,39 @@ func (r RemovePath) MarshalJSON() ([]byte, error) {
 	return json.Marshal(exp)
 }
 
+// IncludePath is a type which defines an IncludePath action for a specific index.Path,
+// which re-includes a path (previously removed by a RemovePath) in a playlist item.
+type IncludePath index.Path
+
+// Transform implements Transformer
+func (IncludePath) Transform() string {
+	return "include"
+}
+
+// Implements json.Marshaler.
+func (i IncludePath) MarshalJSON() ([]byte, error) {
+	exp := struct {
+		Action string     `json:"action"`
+		Path   index.Path `json:"path"`
+	}{
+		Action: i.Transform(),
+		Path:   index.Path(i),
+	}
+	return json.Marshal(exp)
+}
+
+// transformPath returns the path of the RemovePath or IncludePath transform, and true if
+// the transform removes the path.
+func transformPath(t Transformer) (index.Path, bool) {
+	switch t := t.(type) {
+	case RemovePath:
+		return index.Path(t), true
+	case IncludePath:
+		return index.Path(t), false
+	}
+	return nil, false
+}
+
 // Item is a type which defines a playlist item.
 type Item struct {
 	path       index.Path
@@ -52,11 +86,55 @@ func newItem(path index.Path) *Item {
 	}
 }
 
+// Path returns the path of the Item.
+func (i *Item) Path() index.Path {
+	return i.path
+}
+
 // AddTransform adds the Transformer to the Item.
 func (i *Item) AddTransform(t Transformer) {
 	i.transforms = append(i.transforms, t)
 }
 
+// removed returns true if the path is removed by the transforms of the item.  Transforms are
+// applied in order, so later transforms override earlier ones.
+func (i *Item) removed(p index.Path) bool {
+	result := false
+	for _, t := range i.transforms {
+		if tp, remove := transformPath(t); tp.Contains(p) {
+			result = remove
+		}
+	}
+	return result
+}
+
+// include re-includes the path in the item: transforms of paths within it are dropped, and
+// if the path is still removed by a transform of a path containing it then an IncludePath
+// is added.
+func (i *Item) include(p index.Path) {
+	transforms := make([]Transformer, 0, len(i.transforms))
+	for _, t := range i.transforms {
+		if tp, _ := transformPath(t); tp == nil || !p.Contains(tp) {
+			transforms = append(transforms, t)
+		}
+	}
+	i.transforms = transforms
+
+	if i.removed(p) {
+		i.AddTransform(IncludePath(p))
+	}
+}
+
+// copy returns a copy of the item.
+func (i *Item) copy() *Item {
+	transforms := make([]Transformer, len(i.transforms))
+	copy(transforms, i.transforms)
+	return &Item{
+		path:       i.path,
+		transforms: transforms,
+	}
+}
+
 // MarshalJSON implements json.Marshaler.
 func (i *Item) MarshalJSON() ([]byte, error) {
 	exp := struct {
@@ -82,12 +160,17 @@ func (i *Item) UnmarshalJSON(b []byte) error {
 
 	transforms := make([]Transformer, 0, len(exp.Transforms))
 	for _, t := range exp.Transforms {
+		if t["action"] != "remove" && t["action"] != "include" {
+			continue
+		}
+		p, err := index.PathFromJSONInterface(t["path"])
+		if err != nil {
+			return fmt.Errorf("invalid format for path: %v", t["path"])
+		}
 		if t["action"] == "remove" {
-			p, err := index.PathFromJSONInterface(t["path"])
-			if err != nil {
-				return fmt.Errorf("invalid format for path: %v", t["path"])
-			}
 			transforms = append(transforms, RemovePath(p))
+		} else {
+			transforms = append(transforms, IncludePath(p))
 		}
 	}
 
@@ -96,17 +179,21 @@ func (i *Item) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// Playlist is a basic implementation of a playlist
+// Playlist is a basic implementation of a playlist.  Smart playlists have a set of rules
+// which are used to generate their items (see Refresh).
 type Playlist struct {
 	items []*Item
+	smart *Smart
 }
 
 // MarshalJSON implements json.Marshaler.
 func (p *Playlist) MarshalJSON() ([]byte, error) {
 	exp := struct {
 		Items []*Item `json:"items"`
+		Smart *Smart  `json:"smart,omitempty"`
 	}{
 		p.items,
+		p.smart,
 	}
 	return json.Marshal(exp)
 }
@@ -115,24 +202,97 @@ func (p *Playlist) MarshalJSON() ([]byte, error) {
 func (p *Playlist) UnmarshalJSON(b []byte) error {
 	exp := struct {
 		Items []*Item `json:"items"`
+		Smart *Smart  `json:"smart"`
 	}{}
 	err := json.Unmarshal(b, &exp)
 	if err != nil {
 		return err
 	}
+	if exp.Smart != nil {
+		if err := exp.Smart.Validate(); err != nil {
+			return err
+		}
+	}
 	p.items = exp.Items
+	p.smart = exp.Smart
 	return nil
 }
 
+// Smart returns the rules of the smart playlist, or nil if this isn't a smart playlist.
+func (p *Playlist) Smart() *Smart {
+	return p.smart
+}
+
+// SetSmart sets the rules of the smart playlist, call Refresh to update the items.  If s is
+// nil then the playlist is no longer a smart playlist (and keeps its current items).
+func (p *Playlist) SetSmart(s *Smart) error {
+	if s != nil {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	p.smart = s
+	return nil
+}
+
+// Refresh re-evaluates the rules of a smart playlist against the collection c (see
+// Smart.Evaluate) and replaces its items.  Does nothing if this isn't a smart playlist.
+func (p *Playlist) Refresh(c index.Collection, m Meta, now time.Time) {
+	if p.smart == nil {
+		return
+	}
+	p.items = p.smart.Evaluate(c, m, now)
+}
+
 // Add adds a new with the path to the Playlist.
 func (p *Playlist) Add(path index.Path) {
 	p.items = append(p.items, newItem(path))
 }
 
+// Insert inserts a new item with the path at index n of the Playlist (where n can be the
+// number of items, to add it to the end).
+func (p *Playlist) Insert(n int, path index.Path) error {
+	if n < 0 || n > len(p.items) {
+		return fmt.Errorf("invalid item index (items: %d): %d", len(p.items), n)
+	}
+
+	p.items = append(p.items, nil)
+	copy(p.items[n+1:], p.items[n:])
+	p.items[n] = newItem(path)
+	return nil
+}
+
+// checkIndex returns an error if n isn't a valid item index.
+func (p *Playlist) checkIndex(n int) error {
+	if n < 0 || n >= len(p.items) {
+		return fmt.Errorf("invalid item index (items: %d): %d", len(p.items), n)
+	}
+	return nil
+}
+
+// Move moves the item with index `from` so that it has index `to`.
+func (p *Playlist) Move(from, to int) error {
+	if err := p.checkIndex(from); err != nil {
+		return err
+	}
+	if err := p.checkIndex(to); err != nil {
+		return err
+	}
+
+	item := p.items[from]
+	if from < to {
+		copy(p.items[from:to], p.items[from+1:to+1])
+	} else {
+		copy(p.items[to+1:from+1], p.items[to:from])
+	}
+	p.items[to] = item
+	return nil
+}
+
 // Remove removes the item with index `n` and path `path` from the Playlist.
 func (p *Playlist) Remove(n int, path index.Path) error {
-	if n >= len(p.items) {
-		return fmt.Errorf("invalid item index (items: %d): %d", len(p.items), n)
+	if err := p.checkIndex(n); err != nil {
+		return err
 	}
 
 	item := p.items[n]
@@ -148,6 +308,85 @@ func (p *Playlist) Remove(n int, path index.Path) error {
 	return nil
 }
 
+// Include re-includes the path `path` (previously removed by Remove) in the item with
+// index `n`.
+func (p *Playlist) Include(n int, path index.Path) error {
+	if err := p.checkIndex(n); err != nil {
+		return err
+	}
+
+	item := p.items[n]
+	if !item.path.Contains(path) || path.Equal(item.path) {
+		return fmt.Errorf("path '%v' is not contained in item '%v'", path, item.path)
+	}
+	item.include(path)
+	return nil
+}
+
+// Clear removes all the items from the Playlist.
+func (p *Playlist) Clear() {
+	p.items = nil
+}
+
+// Copy returns a copy of the Playlist.
+func (p *Playlist) Copy() *Playlist {
+	items := make([]*Item, len(p.items))
+	for i, item := range p.items {
+		items[i] = item.copy()
+	}
+
+	var smart *Smart
+	if p.smart != nil {
+		s := *p.smart
+		s.Rules = make([]Rule, len(p.smart.Rules))
+		copy(s.Rules, p.smart.Rules)
+		smart = &s
+	}
+	return &Playlist{
+		items: items,
+		smart: smart,
+	}
+}
+
+// RewritePaths replaces the path of each item (and the paths of its transforms) with the
+// path returned by fn, i.e. when the paths of groups and tracks in the collection have
+// changed.  Items and transforms are removed if fn returns false.  Returns the new index of
+// each item (-1 if it was removed).
+func (p *Playlist) RewritePaths(fn func(index.Path) (index.Path, bool)) []int {
+	indices := make([]int, len(p.items))
+	items := make([]*Item, 0, len(p.items))
+	for i, item := range p.items {
+		path, ok := fn(item.path)
+		if !ok {
+			indices[i] = -1
+			continue
+		}
+		item.path = path
+
+		transforms := make([]Transformer, 0, len(item.transforms))
+		for _, t := range item.transforms {
+			switch tt := t.(type) {
+			case RemovePath:
+				if tp, ok := fn(index.Path(tt)); ok {
+					transforms = append(transforms, RemovePath(tp))
+				}
+			case IncludePath:
+				if tp, ok := fn(index.Path(tt)); ok {
+					transforms = append(transforms, IncludePath(tp))
+				}
+			default:
+				transforms = append(transforms, t)
+			}
+		}
+		item.transforms = transforms
+
+		indices[i] = len(items)
+		items = append(items, item)
+	}
+	p.items = items
+	return indices
+}
+
 // Items returns a slice of *Item instances which represent each item in the playlist.
 func (p *Playlist) Items() []*Item {
 	items := make([]*Item, len(p.items))
@@ -157,29 +396,37 @@ func (p *Playlist) Items() []*Item {
 	return items
 }
 
+// TrackPaths returns the list of paths for the tracks within the path (a group, or a single
+// track), using Collection as the data source.
+func TrackPaths(p index.Path, c index.Collection) ([]index.Path, error) {
+	return Paths(newItem(p), c)
+}
+
 // Paths returns the list of paths for the tracks within the Item, using Collection
-// as the data source.
+// as the data source.  Items can also refer to a single track (i.e. the path of its
+// group followed by its index).
 func Paths(item *Item, c index.Collection) ([]index.Path, error) {
+	if len(item.path) > 2 {
+		if g, err := index.GroupFromPath(c, item.path[1:len(item.path)-1]); err == nil {
+			if _, ok := g.(index.Collection); !ok {
+				if _, err := trackFromPath(c, item.path); err != nil {
+					return nil, err
+				}
+				return []index.Path{item.path}, nil
+			}
+		}
+	}
+
 	g, err := index.GroupFromPath(c, item.path[1:]) // Trim "Root" prefix
 	if err != nil {
 		return nil, err
 	}
 
-	removePaths := make([]index.Path, len(item.transforms))
-	for _, transform := range item.transforms {
-		if path, ok := transform.(RemovePath); ok {
-			removePaths = append(removePaths, index.Path(path))
-		}
-	}
-
 	var paths []index.Path
 	walkFn := func(t index.Track, p index.Path) error {
-		for _, rp := range removePaths {
-			if rp.Contains(p) {
-				return nil
-			}
+		if !item.removed(p) {
+			paths = append(paths, p)
 		}
-		paths = append(paths, p)
 		return nil
 	}
 	index.Walk(g, item.path, walkFn)
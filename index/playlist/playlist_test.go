@@ -5,9 +5,12 @@
 package playlist
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 
 	"tchaik.com/index"
+	"tchaik.com/index/attr"
 )
 
 func TestPlaylistAdd(t *testing.T) {
@@ -138,3 +141,177 @@ func TestPlaylistItemRemove(t *testing.T) {
 		t.Errorf("expected error for removing invalid item (items: %v)", p.Items())
 	}
 }
+
+// itemPaths returns the paths of the items in the playlist.
+func itemPaths(p *Playlist) []string {
+	var paths []string
+	for _, item := range p.Items() {
+		paths = append(paths, item.path.Encode())
+	}
+	return paths
+}
+
+func TestPlaylistInsert(t *testing.T) {
+	p := &Playlist{}
+	p.Add(index.NewPath("Root:b"))
+
+	err := p.Insert(0, index.NewPath("Root:a"))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = p.Insert(2, index.NewPath("Root:c"))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = p.Insert(4, index.NewPath("Root:d"))
+	if err == nil {
+		t.Errorf("expected error inserting item at invalid index")
+	}
+
+	got := itemPaths(p)
+	expected := []string{"Root:a", "Root:b", "Root:c"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("itemPaths(p) = %#v, expected: %#v", got, expected)
+	}
+}
+
+func TestPlaylistMove(t *testing.T) {
+	tests := []struct {
+		from, to int
+		expected []string
+	}{
+		{0, 2, []string{"Root:b", "Root:c", "Root:a", "Root:d"}},
+		{3, 1, []string{"Root:a", "Root:d", "Root:b", "Root:c"}},
+		{1, 1, []string{"Root:a", "Root:b", "Root:c", "Root:d"}},
+	}
+
+	for _, tt := range tests {
+		p := &Playlist{}
+		for _, x := range []string{"a", "b", "c", "d"} {
+			p.Add(index.NewPath("Root:" + x))
+		}
+
+		err := p.Move(tt.from, tt.to)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		got := itemPaths(p)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Move(%d, %d) items = %#v, expected: %#v", tt.from, tt.to, got, tt.expected)
+		}
+	}
+
+	p := &Playlist{}
+	p.Add(index.NewPath("Root:a"))
+	if err := p.Move(0, 1); err == nil {
+		t.Errorf("expected error moving item to invalid index")
+	}
+}
+
+func TestPlaylistInclude(t *testing.T) {
+	c := index.Collect(smartTracks, index.By(attr.String("Composer")))
+	c = index.SubCollect(c, index.By(attr.String("Album")))
+
+	var brahms, symphonies index.Path
+	for _, k := range c.Keys() {
+		if c.Get(k).Name() != "Johannes Brahms" {
+			continue
+		}
+		brahms = index.Path{"Root", k}
+		g := c.Get(k).(index.Collection)
+		for _, k := range g.Keys() {
+			if g.Get(k).Name() == "Brahms Symphonies" {
+				symphonies = append(brahms, k)
+			}
+		}
+	}
+
+	p := &Playlist{}
+	p.Add(brahms)
+	err := p.Remove(0, symphonies)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = p.Include(0, append(symphonies, "1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := smartNames(t, c, p.Items())
+	expected := []string{
+		"Johannes Brahms: Symphony No. 2",
+		"Johannes Brahms: Violin Concerto",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Include() names = %#v, expected: %#v", got, expected)
+	}
+
+	err = p.Include(0, symphonies)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(p.Items()[0].transforms); n != 0 {
+		t.Errorf("len(transforms) = %d, expected: %d", n, 0)
+	}
+
+	p.Remove(0, symphonies)
+	p.Include(0, append(symphonies, "0"))
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("unexpected error from Marshal: %v", err)
+	}
+	var q Playlist
+	err = json.Unmarshal(b, &q)
+	if err != nil {
+		t.Fatalf("unexpected error from Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(&q, p) {
+		t.Errorf("Unmarshal(Marshal(p)) = %#v, expected: %#v", &q, p)
+	}
+}
+
+func TestRepActionEdit(t *testing.T) {
+	s := &testStore{m: make(map[string]*Playlist)}
+	p := &Playlist{}
+	p.Add(index.NewPath("Root:a"))
+	p.Add(index.NewPath("Root:b"))
+	s.Set("A", p)
+
+	actions := []RepAction{
+		{Name: "A", Action: "MOVE", Index: 1, To: 0},
+		{Name: "A", Action: "INSERT", Index: 1, Path: index.NewPath("Root:c")},
+		{Name: "A", Action: "DUPLICATE", NewName: "B"},
+		{Name: "A", Action: "RENAME", NewName: "C"},
+		{Name: "B", Action: "CLEAR"},
+	}
+	for _, a := range actions {
+		if err := a.Apply(s); err != nil {
+			t.Fatalf("unexpected error from %v: %v", a.Action, err)
+		}
+	}
+
+	if s.Get("A") != nil {
+		t.Errorf("expected renamed playlist to be removed")
+	}
+	got := itemPaths(s.Get("C"))
+	expected := []string{"Root:b", "Root:c", "Root:a"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("itemPaths(C) = %#v, expected: %#v", got, expected)
+	}
+	if n := len(s.Get("B").Items()); n != 0 {
+		t.Errorf("len(B.Items()) = %d, expected: %d", n, 0)
+	}
+
+	err := RepAction{Name: "C", Action: "RENAME", NewName: "B"}.Apply(s)
+	if err == nil {
+		t.Errorf("expected error renaming playlist to existing name")
+	}
+
+	err = RepAction{Name: "C", Action: "DELETE"}.Apply(s)
+	if err != nil {
+		t.Errorf("unexpected error from DELETE: %v", err)
+	}
+	if s.Get("C") != nil {
+		t.Errorf("expected deleted playlist to be removed")
+	}
+}
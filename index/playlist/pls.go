@@ -0,0 +1,95 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encodePLS writes the entries as a PLS (version 2) playlist.
+func encodePLS(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "[playlist]")
+	for i, e := range entries {
+		n := i + 1
+		fmt.Fprintf(bw, "File%d=%s\n", n, e.Location)
+		fmt.Fprintf(bw, "Title%d=%s\n", n, entryName(e))
+		fmt.Fprintf(bw, "Length%d=%d\n", n, int(e.Duration/time.Second))
+	}
+	fmt.Fprintf(bw, "NumberOfEntries=%d\n", len(entries))
+	fmt.Fprintln(bw, "Version=2")
+	return bw.Flush()
+}
+
+// decodePLS reads entries from a PLS playlist.  Entries are ordered by their number, and
+// entries without a file are ignored.
+func decodePLS(r io.Reader) ([]Entry, error) {
+	m := make(map[int]*Entry)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(s.Text(), "\ufeff"))
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k, v := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(k, f) {
+				field = f
+				break
+			}
+		}
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(k[len(field):])
+		if err != nil {
+			continue
+		}
+
+		e, ok := m[n]
+		if !ok {
+			e = &Entry{}
+			m[n] = e
+		}
+
+		switch field {
+		case "file":
+			e.Location = v
+		case "title":
+			setEntryName(e, v)
+		case "length":
+			if l, err := strconv.Atoi(v); err == nil && l > 0 {
+				e.Duration = time.Duration(l) * time.Second
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]int, 0, len(m))
+	for n := range m {
+		keys = append(keys, n)
+	}
+	sort.Ints(keys)
+
+	entries := make([]Entry, 0, len(keys))
+	for _, n := range keys {
+		if e := m[n]; e.Location != "" {
+			entries = append(entries, *e)
+		}
+	}
+	return entries, nil
+}
@@ -17,20 +17,53 @@ const (
 	ActionCreate Action = "create"
 	ActionDelete        = "delete"
 
-	ActionAddItem    = "addItem"
-	ActionRemoveItem = "deleteItem"
+	ActionRename    = "rename"
+	ActionDuplicate = "duplicate"
+	ActionClear     = "clear"
+
+	ActionAddItem     = "addItem"
+	ActionInsertItem  = "insertItem"
+	ActionMoveItem    = "moveItem"
+	ActionRemoveItem  = "deleteItem"
+	ActionIncludePath = "includePath"
+	ActionSetSmart    = "setSmart"
 )
 
 var actionToAction = map[string]Action{
-	"ADD_ITEM": ActionAddItem,
-	"REMOVE":   ActionRemoveItem,
+	"DELETE":    ActionDelete,
+	"RENAME":    ActionRename,
+	"DUPLICATE": ActionDuplicate,
+	"CLEAR":     ActionClear,
+	"ADD_ITEM":  ActionAddItem,
+	"INSERT":    ActionInsertItem,
+	"MOVE":      ActionMoveItem,
+	"REMOVE":    ActionRemoveItem,
+	"INCLUDE":   ActionIncludePath,
+	"SET_SMART": ActionSetSmart,
+}
+
+// itemActions are the actions which change the items of a playlist, and so can't be used
+// on smart playlists.
+var itemActions = map[Action]bool{
+	ActionClear:       true,
+	ActionAddItem:     true,
+	ActionInsertItem:  true,
+	ActionMoveItem:    true,
+	ActionRemoveItem:  true,
+	ActionIncludePath: true,
 }
 
+// RepAction is a playlist mutation.  Index is the index of the item (for INSERT, MOVE,
+// REMOVE and INCLUDE), To is the new index of the item (for MOVE) and NewName is the name
+// of the new playlist (for RENAME and DUPLICATE).
 type RepAction struct {
-	Name   string     `json:"name"`
-	Action Action     `json:"action"`
-	Path   index.Path `json:"path"`
-	Index  int        `json:"index"`
+	Name    string     `json:"name"`
+	Action  Action     `json:"action"`
+	Path    index.Path `json:"path"`
+	Index   int        `json:"index"`
+	To      int        `json:"to"`
+	NewName string     `json:"newName"`
+	Smart   *Smart     `json:"smart"`
 }
 
 func (a RepAction) Apply(s Store) error {
@@ -45,19 +78,60 @@ func (a RepAction) Apply(s Store) error {
 	}
 
 	p := s.Get(a.Name)
+	if p == nil && action == ActionSetSmart {
+		p = &Playlist{}
+	}
 	if p == nil {
 		return fmt.Errorf("invalid playlist name: '%v'", a.Name)
 	}
 
+	if p.Smart() != nil && itemActions[action] {
+		return fmt.Errorf("cannot change the items of smart playlist: '%v'", a.Name)
+	}
+
+	switch action {
+	case ActionAddItem, ActionInsertItem, ActionRemoveItem, ActionIncludePath:
+		if len(a.Path) == 0 {
+			return fmt.Errorf("invalid path: '%v'", a.Path)
+		}
+	case ActionRename, ActionDuplicate:
+		if a.NewName == "" {
+			return fmt.Errorf("invalid new playlist name: '%v'", a.NewName)
+		}
+		if s.Get(a.NewName) != nil {
+			return fmt.Errorf("playlist already exists: '%v'", a.NewName)
+		}
+	}
+
+	var err error
 	switch action {
 	case ActionDelete:
-		s.Delete(a.Name)
+		return s.Delete(a.Name)
+	case ActionRename:
+		err = s.Set(a.NewName, p)
+		if err != nil {
+			return err
+		}
+		return s.Delete(a.Name)
+	case ActionDuplicate:
+		return s.Set(a.NewName, p.Copy())
+	case ActionClear:
+		p.Clear()
 	case ActionAddItem:
 		p.Add(a.Path)
+	case ActionInsertItem:
+		err = p.Insert(a.Index, a.Path)
+	case ActionMoveItem:
+		err = p.Move(a.Index, a.To)
 	case ActionRemoveItem:
-		p.Remove(a.Index, a.Path)
+		err = p.Remove(a.Index, a.Path)
+	case ActionIncludePath:
+		err = p.Include(a.Index, a.Path)
+	case ActionSetSmart:
+		err = p.SetSmart(a.Smart)
 	}
-
-	s.Set(a.Name, p)
-	return nil
+	if err != nil {
+		return err
+	}
+	return s.Set(a.Name, p)
 }
@@ -0,0 +1,361 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package playlist
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tchaik.com/index"
+)
+
+// Rule fields which are evaluated using meta data (see Meta) rather than track attributes.
+const (
+	FieldRating     = "Rating"
+	FieldPlayCount  = "PlayCount"
+	FieldLastPlayed = "LastPlayed"
+	FieldFavourite  = "Favourite"
+	FieldChecklist  = "Checklist"
+)
+
+// Rule operators.  Text fields support OpContains, OpNotContains, OpIs and OpIsNot (all
+// case-insensitive), int fields (including FieldRating and FieldPlayCount) support the
+// comparison operators, time fields (including FieldLastPlayed) support OpWithinDays and
+// OpNotWithinDays, and bool fields (FieldFavourite and FieldChecklist) support OpIs and
+// OpIsNot.
+const (
+	OpContains      = "contains"
+	OpNotContains   = "notContains"
+	OpIs            = "is"
+	OpIsNot         = "isNot"
+	OpEqual         = "="
+	OpNotEqual      = "!="
+	OpLess          = "<"
+	OpLessEqual     = "<="
+	OpGreater       = ">"
+	OpGreaterEqual  = ">="
+	OpWithinDays    = "withinDays"
+	OpNotWithinDays = "notWithinDays"
+)
+
+type fieldType int
+
+const (
+	textField fieldType = iota
+	intField
+	timeField
+	boolField
+)
+
+// ruleFields is a mapping of the fields which can be used in rules to their types.
+var ruleFields = map[string]fieldType{
+	"Name":        textField,
+	"Album":       textField,
+	"AlbumArtist": textField,
+	"Artist":      textField,
+	"Composer":    textField,
+	"Genre":       textField,
+	"Kind":        textField,
+
+	"Year":         intField,
+	"TrackNumber":  intField,
+	"DiscNumber":   intField,
+	"TotalTime":    intField,
+	"BitRate":      intField,
+	FieldRating:    intField,
+	FieldPlayCount: intField,
+
+	index.ImportedPlayCountField: intField,
+
+	"DateAdded":     timeField,
+	"DateModified":  timeField,
+	FieldLastPlayed: timeField,
+
+	FieldFavourite: boolField,
+	FieldChecklist: boolField,
+}
+
+var fieldTypeOps = map[fieldType][]string{
+	textField: {OpContains, OpNotContains, OpIs, OpIsNot},
+	intField:  {OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual},
+	timeField: {OpWithinDays, OpNotWithinDays},
+	boolField: {OpIs, OpIsNot},
+}
+
+// Rule is a condition which tracks must satisfy to be included in a smart playlist.  Value
+// is a string for text fields, a number for int fields, a number of days for time fields
+// and a bool for bool fields.
+type Rule struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// intValue returns the value of x as an int.  Numbers decoded from JSON are float64.
+func intValue(x interface{}) (int, bool) {
+	switch x := x.(type) {
+	case int:
+		return x, true
+	case float64:
+		return int(x), true
+	}
+	return 0, false
+}
+
+// Validate returns a non-nil error if the field, operator or value of the rule is invalid.
+func (r Rule) Validate() error {
+	ft, ok := ruleFields[r.Field]
+	if !ok {
+		return fmt.Errorf("invalid rule field: %v", r.Field)
+	}
+
+	validOp := false
+	for _, op := range fieldTypeOps[ft] {
+		if r.Op == op {
+			validOp = true
+			break
+		}
+	}
+	if !validOp {
+		return fmt.Errorf("invalid operator for %v: %v", r.Field, r.Op)
+	}
+
+	switch ft {
+	case textField:
+		_, ok = r.Value.(string)
+	case intField, timeField:
+		_, ok = intValue(r.Value)
+	case boolField:
+		_, ok = r.Value.(bool)
+	}
+	if !ok {
+		return fmt.Errorf("invalid value for %v: %#v", r.Field, r.Value)
+	}
+	return nil
+}
+
+// Meta is an interface which defines methods for fetching the meta data used to evaluate
+// smart playlist rules.  Meta data is keyed by path: a track is considered a favourite
+// (or in the checklist) if any of the paths containing it are, its rating is that of the
+// most specific rated path containing it, and its plays are those of all the paths
+// containing it.
+type Meta interface {
+	// Rating returns the rating of the path, or 0 if it isn't rated.
+	Rating(index.Path) int
+
+	// Plays returns the times the path was played.
+	Plays(index.Path) []time.Time
+
+	// Favourite returns true if the path is a favourite.
+	Favourite(index.Path) bool
+
+	// Checklist returns true if the path is in the checklist.
+	Checklist(index.Path) bool
+}
+
+// Smart defines the rules used to create a smart playlist.  Tracks must match all the
+// rules (or any of them if Any is set), and at most Limit tracks are included (if
+// non-zero).
+type Smart struct {
+	Rules []Rule `json:"rules"`
+	Any   bool   `json:"any,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// Validate returns a non-nil error if any of the rules are invalid.
+func (s *Smart) Validate() error {
+	if len(s.Rules) == 0 {
+		return errors.New("smart playlist must have at least one rule")
+	}
+	if s.Limit < 0 {
+		return fmt.Errorf("invalid limit: %d", s.Limit)
+	}
+	for _, r := range s.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ruleDoc is a track being evaluated against a set of rules.
+type ruleDoc struct {
+	t   index.Track
+	p   index.Path
+	m   Meta
+	now time.Time
+}
+
+// prefixes returns the paths containing the track, from least to most specific (excluding
+// the "Root" prefix).
+func (d *ruleDoc) prefixes() []index.Path {
+	result := make([]index.Path, 0, len(d.p)-1)
+	for i := 2; i <= len(d.p); i++ {
+		result = append(result, d.p[:i])
+	}
+	return result
+}
+
+func (d *ruleDoc) rating() int {
+	r := 0
+	for _, p := range d.prefixes() {
+		if x := d.m.Rating(p); x != 0 {
+			r = x
+		}
+	}
+	return r
+}
+
+func (d *ruleDoc) plays() []time.Time {
+	var result []time.Time
+	for _, p := range d.prefixes() {
+		result = append(result, d.m.Plays(p)...)
+	}
+	return result
+}
+
+func (d *ruleDoc) flag(fn func(index.Path) bool) bool {
+	for _, p := range d.prefixes() {
+		if fn(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *ruleDoc) intField(f string) int {
+	switch f {
+	case FieldRating:
+		return d.rating()
+	case FieldPlayCount:
+		return len(d.plays())
+	}
+	return d.t.GetInt(f)
+}
+
+func (d *ruleDoc) timeField(f string) time.Time {
+	if f == FieldLastPlayed {
+		var last time.Time
+		for _, t := range d.plays() {
+			if t.After(last) {
+				last = t
+			}
+		}
+		return last
+	}
+	return d.t.GetTime(f)
+}
+
+func (d *ruleDoc) boolField(f string) bool {
+	if f == FieldFavourite {
+		return d.flag(d.m.Favourite)
+	}
+	return d.flag(d.m.Checklist)
+}
+
+// match returns true if the track matches the (valid) rule r.
+func (d *ruleDoc) match(r Rule) bool {
+	switch ruleFields[r.Field] {
+	case textField:
+		x := strings.ToLower(d.t.GetString(r.Field))
+		v := strings.ToLower(r.Value.(string))
+		switch r.Op {
+		case OpContains:
+			return strings.Contains(x, v)
+		case OpNotContains:
+			return !strings.Contains(x, v)
+		case OpIs:
+			return x == v
+		case OpIsNot:
+			return x != v
+		}
+
+	case intField:
+		x := d.intField(r.Field)
+		v, _ := intValue(r.Value)
+		switch r.Op {
+		case OpEqual:
+			return x == v
+		case OpNotEqual:
+			return x != v
+		case OpLess:
+			return x < v
+		case OpLessEqual:
+			return x <= v
+		case OpGreater:
+			return x > v
+		case OpGreaterEqual:
+			return x >= v
+		}
+
+	case timeField:
+		v, _ := intValue(r.Value)
+		within := d.timeField(r.Field).After(d.now.AddDate(0, 0, -v))
+		return within == (r.Op == OpWithinDays)
+
+	case boolField:
+		v := r.Value.(bool)
+		return (d.boolField(r.Field) == v) == (r.Op == OpIs)
+	}
+	return false
+}
+
+func (s *Smart) match(d *ruleDoc) bool {
+	for _, r := range s.Rules {
+		if d.match(r) == s.Any {
+			return s.Any
+		}
+	}
+	return !s.Any
+}
+
+// errLimit is used to stop the walk once the limit has been reached.
+var errLimit = errors.New("limit reached")
+
+// Evaluate returns the playlist items for the tracks in the collection c (assumed to have
+// path "Root") which match the rules, using m to fetch meta data and now as the current
+// time.  There is one item for each group in c which contains a matching track, with
+// RemovePath transforms for the tracks in the group which don't match.  The rules are
+// assumed to be valid (see Validate).
+func (s *Smart) Evaluate(c index.Collection, m Meta, now time.Time) []*Item {
+	var items []*Item
+	var item *Item
+	var matches int
+	count := 0
+
+	done := func() {
+		if item != nil && matches > 0 {
+			items = append(items, item)
+		}
+	}
+
+	walkFn := func(t index.Track, p index.Path) error {
+		if item == nil || !item.path.Contains(p) {
+			done()
+			if s.Limit > 0 && count >= s.Limit {
+				return errLimit
+			}
+			item = newItem(p[:2])
+			matches = 0
+		}
+
+		d := &ruleDoc{t: t, p: p, m: m, now: now}
+		if (s.Limit > 0 && count >= s.Limit) || !s.match(d) {
+			item.AddTransform(RemovePath(p))
+			return nil
+		}
+		matches++
+		count++
+		return nil
+	}
+
+	err := index.Walk(c, index.Path{"Root"}, walkFn)
+	if err != errLimit {
+		done()
+	}
+	return items
+}
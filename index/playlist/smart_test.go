@@ -0,0 +1,273 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package playlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"tchaik.com/index"
+	"tchaik.com/index/attr"
+	"tchaik.com/index/indextest"
+)
+
+type testMeta struct {
+	ratings map[string]int
+	plays   map[string][]time.Time
+	favs    map[string]bool
+}
+
+func (m testMeta) Rating(p index.Path) int        { return m.ratings[fmt.Sprintf("%v", p)] }
+func (m testMeta) Plays(p index.Path) []time.Time { return m.plays[fmt.Sprintf("%v", p)] }
+func (m testMeta) Favourite(p index.Path) bool    { return m.favs[fmt.Sprintf("%v", p)] }
+func (m testMeta) Checklist(p index.Path) bool    { return false }
+
+var smartTracks = indextest.Library{
+	{Name: "Symphony No. 1", Album: "Brahms Symphonies", Composer: "Johannes Brahms", Year: 1955},
+	{Name: "Symphony No. 2", Album: "Brahms Symphonies", Composer: "Johannes Brahms", Year: 1955},
+	{Name: "Violin Concerto", Album: "Violin Concertos", Composer: "Johannes Brahms", Year: 1965},
+	{Name: "Violin Concerto", Album: "Violin Concertos", Composer: "Jean Sibelius", Year: 1965},
+	{Name: "Cello Suite No. 1", Album: "Cello Suites", Composer: "J. S. Bach", Year: 1939},
+}
+
+// smartNames returns the composer and name of the tracks in the items, sorted.
+func smartNames(t *testing.T, c index.Collection, items []*Item) []string {
+	var names []string
+	for _, item := range items {
+		paths, err := Paths(item, c)
+		if err != nil {
+			t.Fatalf("unexpected error from Paths: %v", err)
+		}
+		for _, p := range paths {
+			g, err := index.GroupFromPath(c, p[1:len(p)-1])
+			if err != nil {
+				t.Fatalf("unexpected error from GroupFromPath: %v", err)
+			}
+			i, err := strconv.Atoi(string(p[len(p)-1]))
+			if err != nil {
+				t.Fatalf("unexpected error parsing track index: %v", err)
+			}
+			tr := g.Tracks()[i]
+			names = append(names, tr.GetString("Composer")+": "+tr.GetString("Name"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSmartEvaluate(t *testing.T) {
+	c := index.Collect(smartTracks, index.By(attr.String("Album")))
+	now := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	var brahmsKey index.Key
+	for _, k := range c.Keys() {
+		if c.Get(k).Name() == "Brahms Symphonies" {
+			brahmsKey = k
+		}
+	}
+	brahms := index.Path{"Root", brahmsKey}
+
+	m := testMeta{
+		ratings: map[string]int{
+			fmt.Sprintf("%v", brahms):                         4,
+			fmt.Sprintf("%v", append(brahms, index.Key("1"))): 2,
+		},
+		plays: map[string][]time.Time{
+			fmt.Sprintf("%v", append(brahms, index.Key("0"))): {now.AddDate(0, 0, -10)},
+		},
+		favs: map[string]bool{},
+	}
+
+	tests := []struct {
+		smart Smart
+		out   []string
+	}{
+		{
+			Smart{Rules: []Rule{{"Composer", OpContains, "brahms"}}},
+			[]string{
+				"Johannes Brahms: Symphony No. 1",
+				"Johannes Brahms: Symphony No. 2",
+				"Johannes Brahms: Violin Concerto",
+			},
+		},
+		{
+			Smart{Rules: []Rule{{"Composer", OpContains, "Brahms"}, {"Year", OpLess, 1960}}},
+			[]string{
+				"Johannes Brahms: Symphony No. 1",
+				"Johannes Brahms: Symphony No. 2",
+			},
+		},
+		{
+			Smart{Rules: []Rule{{"Composer", OpContains, "Bach"}, {"Composer", OpIs, "jean sibelius"}}, Any: true},
+			[]string{
+				"J. S. Bach: Cello Suite No. 1",
+				"Jean Sibelius: Violin Concerto",
+			},
+		},
+		{
+			// Rating of the track overrides the rating of the album.
+			Smart{Rules: []Rule{{FieldRating, OpGreaterEqual, 4}}},
+			[]string{
+				"Johannes Brahms: Symphony No. 1",
+			},
+		},
+		{
+			Smart{Rules: []Rule{{"Composer", OpContains, "Brahms"}, {FieldLastPlayed, OpNotWithinDays, 90}}},
+			[]string{
+				"Johannes Brahms: Symphony No. 2",
+				"Johannes Brahms: Violin Concerto",
+			},
+		},
+		{
+			Smart{Rules: []Rule{{FieldPlayCount, OpGreater, 0}}},
+			[]string{
+				"Johannes Brahms: Symphony No. 1",
+			},
+		},
+		{
+			Smart{Rules: []Rule{{FieldFavourite, OpIs, true}}},
+			nil,
+		},
+	}
+
+	for ii, tt := range tests {
+		items := tt.smart.Evaluate(c, m, now)
+		got := smartNames(t, c, items)
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("[%d] Evaluate() = %#v, expected: %#v", ii, got, tt.out)
+		}
+	}
+}
+
+func TestSmartEvaluateLimit(t *testing.T) {
+	c := index.Collect(smartTracks, index.By(attr.String("Album")))
+	s := Smart{Rules: []Rule{{"Year", OpGreater, 0}}}
+
+	for _, n := range []int{1, 2, 3, 5} {
+		s.Limit = n
+		got := smartNames(t, c, s.Evaluate(c, testMeta{}, time.Now()))
+		if len(got) != n {
+			t.Errorf("Evaluate() with Limit %d returned %d tracks: %#v", n, len(got), got)
+		}
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		in    Rule
+		valid bool
+	}{
+		{Rule{"Composer", OpContains, "Brahms"}, true},
+		{Rule{"Year", OpLess, 1960}, true},
+		{Rule{"Year", OpLess, 1960.0}, true},
+		{Rule{FieldLastPlayed, OpNotWithinDays, 90.0}, true},
+		{Rule{FieldFavourite, OpIs, true}, true},
+		{Rule{"Unknown", OpIs, "x"}, false},
+		{Rule{"Composer", OpLess, "Brahms"}, false},
+		{Rule{"Year", OpLess, "1960"}, false},
+		{Rule{FieldFavourite, OpIs, "yes"}, false},
+	}
+
+	for ii, tt := range tests {
+		err := tt.in.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("[%d] %#v.Validate() = %v, expected valid: %v", ii, tt.in, err, tt.valid)
+		}
+	}
+
+	if err := (&Smart{}).Validate(); err == nil {
+		t.Errorf("expected error validating smart playlist without rules")
+	}
+}
+
+func TestPlaylistSmartJSON(t *testing.T) {
+	p := &Playlist{}
+	err := p.SetSmart(&Smart{
+		Rules: []Rule{{"Composer", OpContains, "Brahms"}, {"Year", OpLess, 1960}},
+		Limit: 50,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error from SetSmart: %v", err)
+	}
+	p.Add(index.NewPath("Root:a"))
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("unexpected error from json.Marshal: %v", err)
+	}
+
+	got := &Playlist{}
+	err = json.Unmarshal(b, got)
+	if err != nil {
+		t.Fatalf("unexpected error from json.Unmarshal: %v", err)
+	}
+
+	if got.Smart() == nil {
+		t.Fatalf("expected smart playlist, got nil Smart()")
+	}
+	if len(got.Smart().Rules) != 2 || got.Smart().Limit != 50 {
+		t.Errorf("Smart() = %#v, expected: %#v", got.Smart(), p.Smart())
+	}
+	if len(got.Items()) != 1 {
+		t.Errorf("len(Items()) = %d, expected: %d", len(got.Items()), 1)
+	}
+
+	err = json.Unmarshal([]byte(`{"items":[],"smart":{"rules":[{"field":"Year","op":"contains","value":"x"}]}}`), got)
+	if err == nil {
+		t.Errorf("expected error unmarshalling invalid smart playlist")
+	}
+}
+
+func TestRepActionSmart(t *testing.T) {
+	s := &testStore{m: make(map[string]*Playlist)}
+
+	err := RepAction{
+		Name:   "Brahms",
+		Action: "SET_SMART",
+		Smart:  &Smart{Rules: []Rule{{"Composer", OpContains, "Brahms"}}},
+	}.Apply(s)
+	if err != nil {
+		t.Fatalf("unexpected error from Apply: %v", err)
+	}
+
+	p := s.Get("Brahms")
+	if p == nil || p.Smart() == nil {
+		t.Fatalf("expected smart playlist to be created")
+	}
+
+	err = RepAction{Name: "Brahms", Action: "ADD_ITEM", Path: index.NewPath("Root:a")}.Apply(s)
+	if err == nil {
+		t.Errorf("expected error adding item to smart playlist")
+	}
+}
+
+type testStore struct {
+	m map[string]*Playlist
+}
+
+func (s *testStore) Names() []string {
+	var names []string
+	for k := range s.m {
+		names = append(names, k)
+	}
+	return names
+}
+
+func (s *testStore) Get(name string) *Playlist { return s.m[name] }
+
+func (s *testStore) Set(name string, p *Playlist) error {
+	s.m[name] = p
+	return nil
+}
+
+func (s *testStore) Delete(name string) error {
+	delete(s.m, name)
+	return nil
+}
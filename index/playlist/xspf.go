@@ -0,0 +1,98 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package playlist
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int64  `xml:"duration,omitempty"`
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+// xspfLocation returns the location as a URI: absolute paths are converted into file URLs.
+func xspfLocation(l string) string {
+	l = strings.Replace(l, `\`, "/", -1)
+	if len(l) > 1 && l[1] == ':' {
+		l = "/" + l // Windows drive letter
+	}
+	if !path.IsAbs(l) {
+		return l
+	}
+	u := &url.URL{Scheme: "file", Path: l}
+	return u.String()
+}
+
+// encodeXSPF writes the entries as an XSPF playlist.
+func encodeXSPF(w io.Writer, title string, entries []Entry) error {
+	p := xspfPlaylist{
+		Version: "1",
+		Title:   title,
+		Tracks:  make([]xspfTrack, len(entries)),
+	}
+	for i, e := range entries {
+		p.Tracks[i] = xspfTrack{
+			Location: xspfLocation(e.Location),
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: int64(e.Duration / time.Millisecond),
+		}
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(p)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// decodeXSPF reads entries from an XSPF playlist.
+func decodeXSPF(r io.Reader) ([]Entry, error) {
+	var p xspfPlaylist
+	err := xml.NewDecoder(r).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(p.Tracks))
+	for _, t := range p.Tracks {
+		l := strings.TrimSpace(t.Location)
+		if l == "" {
+			continue
+		}
+		entries = append(entries, Entry{
+			Location: l,
+			Title:    t.Title,
+			Artist:   t.Creator,
+			Album:    t.Album,
+			Duration: time.Duration(t.Duration) * time.Millisecond,
+		})
+	}
+	return entries, nil
+}
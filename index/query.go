@@ -0,0 +1,324 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// queryTextFields is a mapping of query field qualifiers to the text fields they search.
+var queryTextFields = map[string]string{
+	"name":        "Name",
+	"title":       "Name",
+	"album":       "Album",
+	"artist":      "Artist",
+	"albumartist": "AlbumArtist",
+	"composer":    "Composer",
+	"genre":       "Genre",
+	"conductor":   "Conductor",
+	"orchestra":   "Orchestra",
+	"catalogue":   "Catalogue",
+}
+
+// queryIntFields is a mapping of query field qualifiers to the int fields they search.
+var queryIntFields = map[string]string{
+	"year":      "Year",
+	"bitrate":   "BitRate",
+	"totaltime": "TotalTime",
+}
+
+// queryTerm is a single term in a Query.
+type queryTerm struct {
+	not   bool   // true if the term is negated
+	field string // field name, empty for the default fields
+
+	// text terms
+	words []string // normalised words, len(words) > 1 for phrases
+
+	// numeric range terms (inclusive)
+	numeric  bool
+	min, max int
+}
+
+// Query is a parsed search query.  See ParseQuery for the syntax.
+type Query struct {
+	terms []queryTerm
+}
+
+// IsPlain returns true if the Query only contains unqualified, non-negated single words,
+// and so can be answered by a word-based Searcher.
+func (q Query) IsPlain() bool {
+	for _, t := range q.terms {
+		if t.not || t.field != "" || len(t.words) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseQuery parses the search query in s.  Queries are a list of whitespace separated
+// terms, all of which must match.  Terms can be:
+//
+//  word              a word (or word prefix) in any of the default fields
+//  "some words"      a phrase in any of the default fields
+//  field:word        a word (or word prefix) in the field
+//  field:"a phrase"  a phrase in the field
+//  year:1720..1750   a numeric range (inclusive, either bound can be omitted)
+//  year:1720         an exact numeric value
+//
+// Text fields are name (or title), album, artist, albumartist, composer, genre, conductor,
+// orchestra and catalogue (i.e. catalogue:"bwv 1007", see ParseCatalogue).  Numeric fields
+// are year, bitrate and totaltime (milliseconds).  Any term can be negated by prefixing it
+// with '-' or the keyword NOT.
+func ParseQuery(s string) (Query, error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return Query{}, err
+	}
+
+	var q Query
+	not := false
+	for _, tok := range tokens {
+		if tok == "NOT" {
+			not = true
+			continue
+		}
+
+		t := queryTerm{not: not}
+		not = false
+		if strings.HasPrefix(tok, "-") && len(tok) > 1 {
+			t.not = true
+			tok = tok[1:]
+		}
+
+		if i := strings.Index(tok, ":"); i > 0 {
+			qualifier, value := strings.ToLower(tok[:i]), tok[i+1:]
+			if f, ok := queryIntFields[qualifier]; ok {
+				t.field = f
+				t.numeric = true
+				t.min, t.max, err = parseRange(value)
+				if err != nil {
+					return Query{}, fmt.Errorf("invalid range for '%v': %v", qualifier, err)
+				}
+				q.terms = append(q.terms, t)
+				continue
+			}
+			if f, ok := queryTextFields[qualifier]; ok {
+				t.field = f
+				tok = value
+			}
+		}
+
+		t.words = strings.Fields(removeNonAlphaNumeric(strings.Trim(tok, `"`)))
+		if len(t.words) > 0 {
+			q.terms = append(q.terms, t)
+		}
+	}
+	return q, nil
+}
+
+// tokenizeQuery splits s into whitespace separated tokens, keeping quoted strings
+// (including any whitespace) within a single token.
+func tokenizeQuery(s string) ([]string, error) {
+	var tokens []string
+	var tok []rune
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			tok = append(tok, r)
+
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if len(tok) > 0 {
+				tokens = append(tokens, string(tok))
+				tok = tok[:0]
+			}
+
+		default:
+			tok = append(tok, r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in query: %v", s)
+	}
+	if len(tok) > 0 {
+		tokens = append(tokens, string(tok))
+	}
+	return tokens, nil
+}
+
+// parseRange parses a numeric range of the form "a..b", "a..", "..b" or "a".
+func parseRange(s string) (min, max int, err error) {
+	const maxInt = int(^uint(0) >> 1)
+
+	parseBound := func(x string, def int) (int, error) {
+		if x == "" {
+			return def, nil
+		}
+		return strconv.Atoi(x)
+	}
+
+	i := strings.Index(s, "..")
+	if i == -1 {
+		min, err = strconv.Atoi(s)
+		return min, min, err
+	}
+
+	if s == ".." {
+		return 0, 0, fmt.Errorf("empty range")
+	}
+	min, err = parseBound(s[:i], -maxInt-1)
+	if err != nil {
+		return
+	}
+	max, err = parseBound(s[i+2:], maxInt)
+	return
+}
+
+// queryDoc is the searchable representation of a Track.
+type queryDoc struct {
+	Track
+	path Path
+	text map[string]string // field -> normalised text
+}
+
+// containsWords returns true if the normalised text x contains the words, with the
+// last word matched as a prefix.
+func containsWords(x string, words []string) bool {
+	return strings.Contains(" "+x, " "+strings.Join(words, " "))
+}
+
+// matchTerm returns true if the term matches the document (ignoring negation).
+func (d *queryDoc) matchTerm(t queryTerm, defaultFields []string) bool {
+	if t.numeric {
+		v := d.GetInt(t.field)
+		return t.min <= v && v <= t.max
+	}
+
+	if t.field != "" {
+		return containsWords(d.field(t.field), t.words)
+	}
+	for _, f := range defaultFields {
+		if containsWords(d.field(f), t.words) {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the normalised text for the field.
+func (d *queryDoc) field(f string) string {
+	if x, ok := d.text[f]; ok {
+		return x
+	}
+	return normaliseText(d.GetString(f))
+}
+
+func normaliseText(x string) string {
+	return strings.Join(strings.Fields(removeNonAlphaNumeric(x)), " ")
+}
+
+// Match returns true if the Track matches the Query.
+func (q Query) Match(t Track, defaultFields []string) bool {
+	return q.match(&queryDoc{Track: t}, defaultFields)
+}
+
+func (q Query) match(d *queryDoc, defaultFields []string) bool {
+	for _, t := range q.terms {
+		if d.matchTerm(t, defaultFields) == t.not {
+			return false
+		}
+	}
+	return true
+}
+
+// querySearcher is a Searcher which parses search input as a Query and returns the
+// paths of all Groups containing a matching Track.
+type querySearcher struct {
+	once sync.Once
+	c    Collection
+	docs []*queryDoc
+
+	fields []string
+	plain  Searcher
+}
+
+// NewQuerySearcher creates a ScoredSearcher which parses the search input as a Query (see
+// ParseQuery), using fields as the default fields for unqualified terms.  Input which
+// only contains plain words (see Query.IsPlain), or which can't be parsed, is passed
+// to the Searcher plain.  Paths are returned in Collection order.
+func NewQuerySearcher(c Collection, fields []string, plain Searcher) ScoredSearcher {
+	return &querySearcher{
+		c:      c,
+		fields: fields,
+		plain:  plain,
+	}
+}
+
+// bootstrap builds the searchable representation of all the Tracks in the Collection.
+// The normalised text of all the default fields and qualified text fields is computed
+// up front so that Search can be called concurrently.
+func (s *querySearcher) bootstrap() {
+	fields := make(map[string]bool, len(s.fields)+len(queryTextFields))
+	for _, f := range s.fields {
+		fields[f] = true
+	}
+	for _, f := range queryTextFields {
+		fields[f] = true
+	}
+
+	Walk(s.c, Path([]Key{"Root"}), func(t Track, p Path) error {
+		d := &queryDoc{
+			Track: t,
+			path:  p[:len(p)-1],
+			text:  make(map[string]string, len(fields)),
+		}
+		for f := range fields {
+			d.text[f] = normaliseText(t.GetString(f))
+		}
+		s.docs = append(s.docs, d)
+		return nil
+	})
+}
+
+// SearchScored implements ScoredSearcher.  Plain input is passed to SearchScored on the
+// plain Searcher, all other results have zero score.
+func (s *querySearcher) SearchScored(input string) []ScoredPath {
+	q, err := ParseQuery(input)
+	if err != nil || q.IsPlain() {
+		return SearchScored(s.plain, input)
+	}
+	return unscoredPaths(s.search(q))
+}
+
+// Search implements Searcher.
+func (s *querySearcher) Search(input string) []Path {
+	q, err := ParseQuery(input)
+	if err != nil || q.IsPlain() {
+		return s.plain.Search(input)
+	}
+	return s.search(q)
+}
+
+func (s *querySearcher) search(q Query) []Path {
+	s.once.Do(s.bootstrap)
+	var result []Path
+	done := make(map[string]bool)
+	for _, d := range s.docs {
+		e := d.path.Encode()
+		if done[e] {
+			continue
+		}
+		if q.match(d, s.fields) {
+			done[e] = true
+			result = append(result, d.path)
+		}
+	}
+	return result
+}
@@ -0,0 +1,194 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"reflect"
+	"testing"
+
+	"tchaik.com/index/attr"
+)
+
+func TestParseRange(t *testing.T) {
+	const maxInt = int(^uint(0) >> 1)
+	const minInt = -maxInt - 1
+
+	tests := []struct {
+		in       string
+		min, max int
+		err      bool
+	}{
+		{"1720..1750", 1720, 1750, false},
+		{"1720..", 1720, maxInt, false},
+		{"..1750", minInt, 1750, false},
+		{"1720", 1720, 1720, false},
+		{"..", 0, 0, true},
+		{"abc", 0, 0, true},
+		{"1720..abc", 0, 0, true},
+	}
+
+	for ii, tt := range tests {
+		min, max, err := parseRange(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("[%d] parseRange(%#v) error = %v, expected error: %v", ii, tt.in, err, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if min != tt.min || max != tt.max {
+			t.Errorf("[%d] parseRange(%#v) = %d, %d, expected: %d, %d", ii, tt.in, min, max, tt.min, tt.max)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		in    string
+		terms []queryTerm
+		plain bool
+	}{
+		{
+			in: "bach cello",
+			terms: []queryTerm{
+				{words: []string{"bach"}},
+				{words: []string{"cello"}},
+			},
+			plain: true,
+		},
+		{
+			in: `composer:bach "well tempered"`,
+			terms: []queryTerm{
+				{field: "Composer", words: []string{"bach"}},
+				{words: []string{"well", "tempered"}},
+			},
+		},
+		{
+			in: `album:"Goldberg Variations" -organ NOT artist:gould`,
+			terms: []queryTerm{
+				{field: "Album", words: []string{"goldberg", "variations"}},
+				{not: true, words: []string{"organ"}},
+				{not: true, field: "Artist", words: []string{"gould"}},
+			},
+		},
+		{
+			in: "year:1720..1750",
+			terms: []queryTerm{
+				{field: "Year", numeric: true, min: 1720, max: 1750},
+			},
+		},
+		{
+			in: "Dvořák",
+			terms: []queryTerm{
+				{words: []string{"dvorak"}},
+			},
+			plain: true,
+		},
+	}
+
+	for ii, tt := range tests {
+		q, err := ParseQuery(tt.in)
+		if err != nil {
+			t.Errorf("[%d] unexpected error from ParseQuery(%#v): %v", ii, tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.terms, tt.terms) {
+			t.Errorf("[%d] ParseQuery(%#v) = %#v, expected: %#v", ii, tt.in, q.terms, tt.terms)
+		}
+		if q.IsPlain() != tt.plain {
+			t.Errorf("[%d] ParseQuery(%#v).IsPlain() = %v, expected: %v", ii, tt.in, q.IsPlain(), tt.plain)
+		}
+	}
+
+	for _, in := range []string{`"unterminated`, "year:abc"} {
+		if _, err := ParseQuery(in); err == nil {
+			t.Errorf("expected error from ParseQuery(%#v)", in)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	track := testTrack{
+		Name:     "Prelude and Fugue in C major, BWV 846",
+		Album:    "The Well-Tempered Clavier",
+		Artist:   "Glenn Gould",
+		Composer: "Johann Sebastian Bach",
+		Year:     1722,
+	}
+	fields := []string{"Name", "Album", "Artist", "Composer"}
+
+	tests := []struct {
+		in    string
+		match bool
+	}{
+		{"bach", true},
+		{"seb", true},
+		{"mozart", false},
+		{"composer:bach", true},
+		{"artist:bach", false},
+		{`"well tempered"`, true},
+		{`"tempered well"`, false},
+		{`album:"well tempered clav"`, true},
+		{"year:1720..1750", true},
+		{"year:1750..", false},
+		{"bach -gould", false},
+		{"bach NOT artist:richter", true},
+		{"composer:bach year:1720..1750 -organ", true},
+	}
+
+	for ii, tt := range tests {
+		q, err := ParseQuery(tt.in)
+		if err != nil {
+			t.Errorf("[%d] unexpected error from ParseQuery(%#v): %v", ii, tt.in, err)
+			continue
+		}
+		if got := q.Match(track, fields); got != tt.match {
+			t.Errorf("[%d] ParseQuery(%#v).Match() = %v, expected: %v", ii, tt.in, got, tt.match)
+		}
+	}
+}
+
+type testSearcher []Path
+
+func (s testSearcher) Search(string) []Path { return s }
+
+func TestQuerySearcher(t *testing.T) {
+	tracks := testTracker{
+		{Name: "Cello Suite No. 1", Album: "Cello Suites", Composer: "Bach", Year: 1720},
+		{Name: "Cello Suite No. 2", Album: "Cello Suites", Composer: "Bach", Year: 1720},
+		{Name: "Toccata and Fugue", Album: "Organ Works", Composer: "Bach", Year: 1705},
+		{Name: "Cello Concerto", Album: "Cello Concertos", Composer: "Dvorak", Year: 1895},
+	}
+	c := Collect(tracks, By(attr.String("Album")))
+	key := func(album string) Path {
+		for _, k := range c.Keys() {
+			if c.Get(k).Name() == album {
+				return Path{"Root", k}
+			}
+		}
+		return nil
+	}
+
+	plain := testSearcher{Path{"Root", "plain"}}
+	s := NewQuerySearcher(c, []string{"Name", "Album", "Composer"}, plain)
+
+	tests := []struct {
+		in  string
+		out []Path
+	}{
+		{"cello", []Path(plain)},
+		{"composer:bach", []Path{key("Cello Suites"), key("Organ Works")}},
+		{"composer:bach -organ", []Path{key("Cello Suites")}},
+		{"cello year:1800..", []Path{key("Cello Concertos")}},
+		{`"cello suite" year:..1720`, []Path{key("Cello Suites")}},
+	}
+
+	for ii, tt := range tests {
+		got := s.Search(tt.in)
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("[%d] Search(%#v) = %v, expected: %v", ii, tt.in, got, tt.out)
+		}
+	}
+}
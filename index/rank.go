@@ -0,0 +1,399 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ScoredPath is a Path with a relevance score.
+type ScoredPath struct {
+	Path  Path
+	Score float64
+}
+
+// ScoredSearcher is an interface which defines the SearchScored method.
+type ScoredSearcher interface {
+	Searcher
+
+	// SearchScored uses the given string to filter a list of paths, returning them
+	// in order of decreasing relevance along with their scores.
+	SearchScored(string) []ScoredPath
+}
+
+// SearchScored calls SearchScored on s if it implements ScoredSearcher, otherwise it
+// calls Search and returns the paths with zero scores.
+func SearchScored(s Searcher, input string) []ScoredPath {
+	if ss, ok := s.(ScoredSearcher); ok {
+		return ss.SearchScored(input)
+	}
+	return unscoredPaths(s.Search(input))
+}
+
+// unscoredPaths returns the paths as ScoredPaths with zero scores.
+func unscoredPaths(paths []Path) []ScoredPath {
+	if paths == nil {
+		return nil
+	}
+	result := make([]ScoredPath, len(paths))
+	for i, p := range paths {
+		result[i] = ScoredPath{Path: p}
+	}
+	return result
+}
+
+// ScoredPathsToPaths returns the paths from the list of ScoredPaths.
+func ScoredPathsToPaths(sp []ScoredPath) []Path {
+	if sp == nil {
+		return nil
+	}
+	paths := make([]Path, len(sp))
+	for i, x := range sp {
+		paths[i] = x.Path
+	}
+	return paths
+}
+
+// NewScoredPathsCollection creates a new collection from a source collection `src` which will
+// contain the groups represented by the given list of scored paths.  The score of each group
+// is set as the field "Score".
+func NewScoredPathsCollection(src Collection, paths []ScoredPath) Collection {
+	scores := make(map[Key]float64, len(paths))
+	for _, p := range paths {
+		if _, ok := scores[p.Path[1]]; !ok {
+			scores[p.Path[1]] = p.Score
+		}
+	}
+
+	return scoredPathsCollection{
+		Collection: NewPathsCollection(src, ScoredPathsToPaths(paths)),
+		scores:     scores,
+	}
+}
+
+type scoredPathsCollection struct {
+	Collection
+	scores map[Key]float64
+}
+
+// Get implements Collection.
+func (c scoredPathsCollection) Get(k Key) Group {
+	g := c.Collection.Get(k)
+	if g == nil {
+		return nil
+	}
+	return fieldsGroup(map[string]interface{}{"Score": c.scores[k]}, g)
+}
+
+// BM25 parameters.
+const (
+	rankK1 = 1.2
+	rankB  = 0.75
+)
+
+// ExpandedWordWeight is the weight applied to the score of words which were expanded from
+// a search word (i.e. are not an exact match).
+const ExpandedWordWeight = 0.5
+
+// rankDoc is a document in a RankIndex.
+type rankDoc struct {
+	path Path
+	len  []int // number of words in each field
+}
+
+// posting is an occurrence of a word in a document of a RankIndex.
+type posting struct {
+	doc int   // index of the document
+	tf  []int // term frequency in each field
+}
+
+// postingList is the list of postings of a word.  The postings of indexes read by
+// ReadSearchIndex are read when they are first used.
+type postingList struct {
+	df     int // number of documents containing the word
+	list   []posting
+	loaded bool
+
+	offset, size int64 // of the encoded postings (see ReadSearchIndex)
+}
+
+// RankIndex is a word index which scores Groups using BM25F: term frequencies from each
+// field are weighted by the field boost and normalised by field length before being
+// combined.  RankIndex implements WordIndex.
+type RankIndex struct {
+	fields []string
+	boosts []float64
+
+	docs   []*rankDoc
+	avgLen []float64
+	words  map[string]*postingList
+
+	mu   sync.Mutex  // protects lazily loaded postings
+	data io.ReaderAt // encoded postings, nil if all postings are loaded
+}
+
+// BuildRankIndex creates a RankIndex using the Groups in the Collection (as in
+// BuildCollectionWordIndex), taking data from the given fields.  The boosts map gives the
+// relative weight of each field (fields not in the map have weight 1).  Each distinct field
+// value is only counted once per Group, so that fields shared by every track in a Group
+// (such as Album) don't dominate.
+func BuildRankIndex(c Collection, fields []string, boosts map[string]float64) *RankIndex {
+	r := &RankIndex{
+		fields: fields,
+		boosts: make([]float64, len(fields)),
+		avgLen: make([]float64, len(fields)),
+		words:  make(map[string]*postingList),
+	}
+	for i, f := range fields {
+		r.boosts[i] = 1.0
+		if b, ok := boosts[f]; ok {
+			r.boosts[i] = b
+		}
+	}
+
+	r.addGroup(c, Path([]Key{"Root"}))
+
+	if len(r.docs) > 0 {
+		for _, d := range r.docs {
+			for i, n := range d.len {
+				r.avgLen[i] += float64(n)
+			}
+		}
+		for i := range r.avgLen {
+			r.avgLen[i] /= float64(len(r.docs))
+		}
+	}
+	return r
+}
+
+func (r *RankIndex) addGroup(g Group, p Path) {
+	if c, ok := g.(Collection); ok {
+		for _, k := range c.Keys() {
+			np := make(Path, len(p), len(p)+1)
+			copy(np, p)
+			np = append(np, k)
+			r.addGroup(c.Get(k), np)
+		}
+		return
+	}
+
+	n := len(r.docs)
+	d := &rankDoc{
+		path: p,
+		len:  make([]int, len(r.fields)),
+	}
+	tf := make(map[string][]int)
+	var words []string
+	for i, f := range r.fields {
+		done := make(map[string]bool)
+		for _, t := range g.Tracks() {
+			v := t.GetString(f)
+			if done[v] {
+				continue
+			}
+			done[v] = true
+			for _, w := range strings.Fields(removeNonAlphaNumeric(v)) {
+				if tf[w] == nil {
+					tf[w] = make([]int, len(r.fields))
+					words = append(words, w)
+				}
+				tf[w][i]++
+				d.len[i]++
+			}
+		}
+	}
+
+	r.docs = append(r.docs, d)
+	for _, w := range words {
+		l, ok := r.words[w]
+		if !ok {
+			l = &postingList{loaded: true}
+			r.words[w] = l
+		}
+		l.df++
+		l.list = append(l.list, posting{doc: n, tf: tf[w]})
+	}
+}
+
+// postings returns the postings of the word, reading them if they haven't been loaded.
+func (r *RankIndex) postings(w string) []posting {
+	l, ok := r.words[w]
+	if !ok {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !l.loaded {
+		if r.data == nil {
+			log.Printf("error reading search index postings for %#v: index is closed", w)
+			return nil
+		}
+		list, err := decodePostings(io.NewSectionReader(r.data, l.offset, l.size), len(r.fields), len(r.docs))
+		if err != nil {
+			log.Printf("error reading search index postings for %#v: %v", w, err)
+		}
+		l.list = list
+		l.loaded = true
+	}
+	return l.list
+}
+
+// Close closes the source of the postings which haven't been loaded (if it is an io.Closer,
+// see ReadSearchIndex).  Postings which haven't been loaded can't be read after Close.
+func (r *RankIndex) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.(io.Closer)
+	r.data = nil
+	if !ok {
+		return nil
+	}
+	return c.Close()
+}
+
+// Words implements WordIndex.
+func (r *RankIndex) Words() []string {
+	words := make([]string, 0, len(r.words))
+	for w := range r.words {
+		words = append(words, w)
+	}
+	return words
+}
+
+// Search implements Searcher, returning the paths of Groups which contain the word.
+func (r *RankIndex) Search(w string) []Path {
+	list := r.postings(w)
+	if len(list) == 0 {
+		return nil
+	}
+	paths := make([]Path, len(list))
+	for i, x := range list {
+		paths[i] = r.docs[x.doc].path
+	}
+	return paths
+}
+
+// idf returns the inverse document frequency of the word.
+func (r *RankIndex) idf(w string) float64 {
+	n := float64(len(r.docs))
+	var df float64
+	if l, ok := r.words[w]; ok {
+		df = float64(l.df)
+	}
+	return math.Log(1 + (n-df+0.5)/(df+0.5))
+}
+
+// score returns the BM25F score of the word in the document of the posting.
+func (r *RankIndex) score(p posting, w string) float64 {
+	d := r.docs[p.doc]
+	tf := 0.0
+	for i, n := range p.tf {
+		if n == 0 {
+			continue
+		}
+		norm := 1.0
+		if r.avgLen[i] > 0 {
+			norm = 1 - rankB + rankB*float64(d.len[i])/r.avgLen[i]
+		}
+		tf += r.boosts[i] * float64(n) / norm
+	}
+	return r.idf(w) * tf * (rankK1 + 1) / (tf + rankK1)
+}
+
+// rankSearcher is a ScoredSearcher which uses a RankIndex.
+type rankSearcher struct {
+	*RankIndex
+	Expander
+}
+
+// NewRankSearcher creates a ScoredSearcher which expands each word in the search input
+// using the Expander and returns the Groups from the RankIndex which match all the words,
+// ordered by decreasing score.  Words shorter than MinPrefix are ignored.  Matches of
+// expanded words are weighted by ExpandedWordWeight.
+func NewRankSearcher(r *RankIndex, e Expander) ScoredSearcher {
+	return &rankSearcher{
+		RankIndex: r,
+		Expander:  e,
+	}
+}
+
+// Search implements Searcher.
+func (s *rankSearcher) Search(input string) []Path {
+	return ScoredPathsToPaths(s.SearchScored(input))
+}
+
+// SearchScored implements ScoredSearcher.
+func (s *rankSearcher) SearchScored(input string) []ScoredPath {
+	var words []string
+	for _, w := range strings.Fields(removeNonAlphaNumeric(input)) {
+		if len(w) >= MinPrefix {
+			words = append(words, w)
+		}
+	}
+	if len(words) == 0 {
+		return []ScoredPath{}
+	}
+
+	var scores map[int]float64
+	for _, w := range words {
+		wscores := make(map[int]float64)
+		for _, x := range s.Expand(w) {
+			weight := 1.0
+			if x != w {
+				weight = ExpandedWordWeight
+			}
+			for _, p := range s.postings(x) {
+				n := p.doc
+				if scores != nil {
+					if _, ok := scores[n]; !ok {
+						continue
+					}
+				}
+				if sc := weight * s.score(p, x); sc > wscores[n] {
+					wscores[n] = sc
+				}
+			}
+		}
+
+		if scores != nil {
+			for n, sc := range wscores {
+				wscores[n] = sc + scores[n]
+			}
+		}
+		scores = wscores
+		if len(scores) == 0 {
+			break
+		}
+	}
+
+	result := make([]ScoredPath, 0, len(scores))
+	for n, sc := range scores {
+		result = append(result, ScoredPath{
+			Path:  s.docs[n].path,
+			Score: sc,
+		})
+	}
+	sort.Sort(scoredPathSlice(result))
+	return result
+}
+
+type scoredPathSlice []ScoredPath
+
+func (s scoredPathSlice) Len() int      { return len(s) }
+func (s scoredPathSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s scoredPathSlice) Less(i, j int) bool {
+	if s[i].Score == s[j].Score {
+		return s[i].Path.Encode() < s[j].Path.Encode()
+	}
+	return s[i].Score > s[j].Score
+}
@@ -0,0 +1,99 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"reflect"
+	"testing"
+
+	"tchaik.com/index/attr"
+)
+
+func TestRankSearcher(t *testing.T) {
+	tracks := testTracker{
+		{Name: "Prelude", Album: "Cello Suites", Composer: "Bach"},
+		{Name: "Allemande", Album: "Cello Suites", Composer: "Bach"},
+		{Name: "Sarabande", Album: "Cello Suites", Composer: "Bach"},
+		{Name: "Cello Concerto", Album: "Concertos", Composer: "Dvorak"},
+		{Name: "Symphony No. 9", Album: "Concertos", Composer: "Dvorak"},
+		{Name: "Air on the G String", Album: "Orchestral Suites", Composer: "J. S. Bach"},
+		{Name: "Bourrée", Album: "Orchestral Suites", Composer: "J. S. Bach"},
+		{Name: "Variations and Fugue on a Theme of Bach", Album: "Variations", Composer: "Reger"},
+	}
+	c := Collect(tracks, By(attr.String("Album")))
+	key := func(album string) Path {
+		for _, k := range c.Keys() {
+			if c.Get(k).Name() == album {
+				return Path{"Root", k}
+			}
+		}
+		return nil
+	}
+
+	fields := []string{"Name", "Album", "Composer"}
+	boosts := map[string]float64{"Album": 3.0, "Composer": 2.0}
+	ri := BuildRankIndex(c, fields, boosts)
+	s := NewRankSearcher(ri, BuildPrefixMultiExpander(ri.Words(), 10))
+
+	tests := []struct {
+		in  string
+		out []Path
+	}{
+		// Album match ranks above a track name match.
+		{"cello", []Path{key("Cello Suites"), key("Concertos")}},
+		// Composer match ranks above a track name match.
+		{"bach", []Path{key("Cello Suites"), key("Orchestral Suites"), key("Variations")}},
+		// All words must match.
+		{"bach suites", []Path{key("Cello Suites"), key("Orchestral Suites")}},
+		{"cello orchestral", []Path{}},
+		// Prefixes are expanded.
+		{"orch", []Path{key("Orchestral Suites")}},
+		// Short words are ignored.
+		{"of", []Path{}},
+	}
+
+	for ii, tt := range tests {
+		got := s.SearchScored(tt.in)
+		paths := ScoredPathsToPaths(got)
+		if !reflect.DeepEqual(paths, tt.out) {
+			t.Errorf("[%d] SearchScored(%#v) = %v, expected: %v", ii, tt.in, paths, tt.out)
+			continue
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i].Score > got[i-1].Score {
+				t.Errorf("[%d] SearchScored(%#v) results not ordered by score: %v", ii, tt.in, got)
+			}
+		}
+	}
+}
+
+func TestRankIndexSearch(t *testing.T) {
+	tracks := testTracker{
+		{Name: "Prelude", Album: "Cello Suites", Composer: "Bach"},
+		{Name: "Cello Concerto", Album: "Concertos", Composer: "Dvořák"},
+	}
+	c := Collect(tracks, By(attr.String("Album")))
+	ri := BuildRankIndex(c, []string{"Name", "Album", "Composer"}, nil)
+
+	if got := len(ri.Search("cello")); got != 2 {
+		t.Errorf("len(Search(\"cello\")) = %d, expected: 2", got)
+	}
+	if got := len(ri.Search("dvorak")); got != 1 {
+		t.Errorf("len(Search(\"dvorak\")) = %d, expected: 1", got)
+	}
+	expected := []string{"bach", "cello", "concerto", "concertos", "dvorak", "prelude", "suites"}
+	if got := ri.Words(); !reflect.DeepEqual(stringSet(got), stringSet(expected)) {
+		t.Errorf("Words() = %v, expected: %v (compared unordered)", got, expected)
+	}
+}
+
+func TestSearchScored(t *testing.T) {
+	plain := testSearcher{Path{"Root", "a"}, Path{"Root", "b"}}
+	got := SearchScored(plain, "x")
+	expected := []ScoredPath{{Path: Path{"Root", "a"}}, {Path: Path{"Root", "b"}}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("SearchScored() = %v, expected: %v", got, expected)
+	}
+}
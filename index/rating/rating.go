@@ -27,6 +27,8 @@ type Store interface {
 	Set(index.Path, Value) error
 	// Get the rating for the path.
 	Get(index.Path) Value
+	// List returns a list of paths which have a rating (i.e. not None).
+	List() []index.Path
 }
 
 // NewStore creates a basic implementation of a ratings store, using the given path as the
@@ -57,7 +59,12 @@ func (s *store) Set(p index.Path, v Value) error {
 	s.Lock()
 	defer s.Unlock()
 
-	s.m[fmt.Sprintf("%v", p)] = v
+	k := fmt.Sprintf("%v", p)
+	if v == None {
+		delete(s.m, k)
+	} else {
+		s.m[k] = v
+	}
 	return s.store.Persist(&s.m)
 }
 
@@ -68,3 +75,15 @@ func (s *store) Get(p index.Path) Value {
 
 	return s.m[fmt.Sprintf("%v", p)]
 }
+
+// List implements Store.
+func (s *store) List() []index.Path {
+	s.RLock()
+	defer s.RUnlock()
+
+	result := make([]index.Path, 0, len(s.m))
+	for k := range s.m {
+		result = append(result, index.NewPath(k))
+	}
+	return result
+}
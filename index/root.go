@@ -0,0 +1,123 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import "tchaik.com/index/attr"
+
+// NewRootCollection creates the "Root" collection of the Library: tracks grouped by album,
+// with keys sorted by group name.  Catalogue attributes ("Catalogue", "CatalogueSystem" and
+// "CatalogueNumber") are added to tracks which have a catalogue number (see ParseCatalogue).
+func NewRootCollection(l Library) Collection {
+	root := Collect(trackList(catalogueTracks(l.Tracks())), By(attr.String("Album")))
+	SortKeysByGroupName(root)
+	return root
+}
+
+// RootGroups wraps the "Root" collection c so that each group is transformed when it is
+// fetched: tracks are sorted by disc and track number (and then by catalogue number), names
+// are collected by common prefix (i.e. works and their movements) and common attributes are
+// set on the groups.  The paths used by playlists, cursors and the meta stores refer to
+// groups in this collection.
+func RootGroups(c Collection) Collection {
+	return &rootGroups{c}
+}
+
+type rootGroups struct {
+	Collection
+}
+
+// Get implements Collection.
+func (r *rootGroups) Get(k Key) Group {
+	g := r.Collection.Get(k)
+	if g == nil {
+		return g
+	}
+
+	Sort(g.Tracks(), MultiSort(SortByString("Kind"), SortByInt("DiscNumber"), SortByInt("TrackNumber"), SortByCatalogue))
+	g = Transform(g, SplitList("Artist", "AlbumArtist", "Composer"))
+	g = Transform(g, TrimTrackNumPrefix)
+	c := Collect(g, ByPrefix("Name"))
+	g = SubTransform(c, TrimEnumPrefix)
+	g = SumGroupIntAttr("TotalTime", g)
+	commonFields := []attr.Interface{
+		attr.String("Album"),
+		attr.Strings("Artist"),
+		attr.Strings("AlbumArtist"),
+		attr.Strings("Composer"),
+		attr.String("Kind"),
+		attr.Int("Year"),
+		attr.Int("BitRate"),
+		attr.Int("DiscNumber"),
+	}
+	g = CommonGroupAttr(commonFields, g)
+	g = RemoveEmptyCollections(g)
+	return g
+}
+
+// NewNestedCollection creates an alternative root collection of the Library: tracks grouped
+// by each of the Collectors in turn and then by album, with keys sorted by group name at
+// each level.  Artist, AlbumArtist and Composer names are split before grouping (see
+// SplitList), so that ByEach can be used with these fields, and catalogue attributes are
+// added to the tracks (as in NewRootCollection).  Use NestedGroups (with the number of
+// Collectors) to fetch groups from the collection.
+func NewNestedCollection(l Library, cs ...Collector) Collection {
+	var t Tracker = trackList(splitNameList([]string{"Artist", "AlbumArtist", "Composer"}, catalogueTracks(l.Tracks())))
+	for _, c := range cs {
+		if x, ok := t.(Collection); ok {
+			t = SubCollect(x, c)
+			continue
+		}
+		t = c.Collect(t)
+	}
+
+	var root Collection
+	if x, ok := t.(Collection); ok {
+		root = SubCollect(x, By(attr.String("Album")))
+	} else {
+		root = Collect(t, By(attr.String("Album")))
+	}
+	sortKeysByGroupName(root)
+	return root
+}
+
+// trackList is a basic implementation of Tracker.
+type trackList []Track
+
+// Tracks implements Tracker.
+func (t trackList) Tracks() []Track { return t }
+
+// sortKeysByGroupName sorts the keys of the collection and all its sub-collections by group
+// name (see SortKeysByGroupName).
+func sortKeysByGroupName(c Collection) {
+	SortKeysByGroupName(c)
+	for _, k := range c.Keys() {
+		if x, ok := c.Get(k).(Collection); ok {
+			sortKeysByGroupName(x)
+		}
+	}
+}
+
+// NestedGroups wraps the collection c (created by NewNestedCollection with depth Collectors)
+// so that album groups are transformed when they are fetched, as in RootGroups.
+func NestedGroups(c Collection, depth int) Collection {
+	if depth <= 0 {
+		return RootGroups(c)
+	}
+	return &nestedGroups{c, depth}
+}
+
+type nestedGroups struct {
+	Collection
+	depth int
+}
+
+// Get implements Collection.
+func (n *nestedGroups) Get(k Key) Group {
+	g := n.Collection.Get(k)
+	if c, ok := g.(Collection); ok {
+		return NestedGroups(c, n.depth-1)
+	}
+	return g
+}
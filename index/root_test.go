@@ -0,0 +1,109 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"tchaik.com/index/attr"
+)
+
+type testTrackerLibrary struct {
+	testTracker
+}
+
+func (testTrackerLibrary) Track(string) (Track, bool) { return nil, false }
+
+func TestNestedCollection(t *testing.T) {
+	l := testTrackerLibrary{testTracker{
+		{Name: "Prelude", Album: "Cello Suites", Composer: "Bach", Year: 1983},
+		{Name: "Ave Maria", Album: "Songs", Composer: "Bach, Gounod", Year: 1991},
+		{Name: "Sonata", Album: "Sonatas", Composer: "Scarlatti", Year: 1988},
+	}}
+
+	composers := NewNestedCollection(l, ByEach(attr.Strings("Composer")))
+	if got, expected := names(composers), []string{"Bach", "Gounod", "Scarlatti"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("names(composers) = %#v, expected: %#v", got, expected)
+	}
+	bach := composers.Get(nameKeyMap(composers)["Bach"]).(Collection)
+	if got, expected := names(bach), []string{"Cello Suites", "Songs"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("names(bach) = %#v, expected: %#v", got, expected)
+	}
+
+	decades := NewNestedCollection(l, ByDecade(attr.Int("Year")), By(attr.Int("Year")))
+	if got, expected := names(decades), []string{"1980s", "1990s"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("names(decades) = %#v, expected: %#v", got, expected)
+	}
+
+	p := Path{nameKeyMap(decades)["1980s"]}
+	g, err := GroupFromPath(NestedGroups(decades, 2), p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	years := g.(Collection)
+	if got, expected := names(years), []string{"1983", "1988"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("names(years) = %#v, expected: %#v", got, expected)
+	}
+
+	p = append(p, nameKeyMap(years)["1983"], Key(""))
+	p[2] = nameKeyMap(years.Get(p[1]).(Collection))["Cello Suites"]
+	g, err = GroupFromPath(NestedGroups(decades, 2), p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := g.Field("Album"); got != "Cello Suites" {
+		t.Errorf("album.Field(\"Album\") = %#v, expected: %#v", got, "Cello Suites")
+	}
+}
+
+func TestRootGroupsCatalogueOrder(t *testing.T) {
+	l := testTrackerLibrary{testTracker{
+		{Name: "Partita No. 2, BWV 1004", Album: "Bach"},
+		{Name: "Toccata and Fugue, BWV 565", Album: "Bach"},
+		{Name: "Chorale", Album: "Bach"},
+		{Name: "Cello Suite No. 1, BWV 1007", Album: "Bach"},
+	}}
+
+	root := RootGroups(NewRootCollection(l))
+	g := root.Get(root.Keys()[0])
+
+	var got []string
+	for _, x := range g.Tracks() {
+		got = append(got, x.GetString("Name"))
+	}
+	expected := []string{"Toccata and Fugue, BWV 565", "Partita No. 2, BWV 1004", "Cello Suite No. 1, BWV 1007", "Chorale"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("tracks = %#v, expected: %#v", got, expected)
+	}
+}
+
+func TestRootGroupsCatalogueFields(t *testing.T) {
+	// Tracks in a Library created by Convert panic on unknown attributes, so every track in
+	// RootGroups must have the catalogue attributes.
+	l := Convert(testTrackerLibrary{testTracker{
+		{Name: "Cello Suite No. 1, BWV 1007", Album: "Bach"},
+		{Name: "Chorale", Album: "Bach"},
+	}}, "Name")
+
+	root := RootGroups(NewRootCollection(l))
+	g := root.Get(root.Keys()[0])
+
+	var got []string
+	for _, x := range g.Tracks() {
+		got = append(got, fmt.Sprintf("%v/%v/%d", x.GetString("Catalogue"), x.GetString("CatalogueSystem"), x.GetInt("CatalogueNumber")))
+	}
+	expected := []string{"BWV 1007/BWV/1007", "//0"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("catalogue attributes = %#v, expected: %#v", got, expected)
+	}
+
+	si := BuildSearchIndex(root, CollectionSource(root, SearchFields), SearchFields, SearchBoosts, SearchPrefixSize)
+	s := NewRankSearcher(si.Rank, si.Prefix)
+	if paths := s.Search("bwv"); len(paths) != 1 {
+		t.Errorf("Search(%#v) = %v, expected 1 path", "bwv", paths)
+	}
+}
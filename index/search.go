@@ -158,10 +158,28 @@ func (es *expandSearcher) Search(s string) []Path {
 	return Union(ps...)
 }
 
+// NewExpandSearcher creates a Searcher which wraps the given Searcher by expanding the
+// search input using the Expander, and returning the union of the results.
+func NewExpandSearcher(e Expander, s Searcher) Searcher {
+	return &expandSearcher{e, s}
+}
+
 // BuildPrefixExpandSearcher constructs a prefix expander which wraps the given Searcher
 // by expanding each word in the search input using the WordIndex.
 func BuildPrefixExpandSearcher(s Searcher, w WordIndex, n int) Searcher {
-	return &expandSearcher{BuildPrefixMultiExpander(w.Words(), n), s}
+	return NewExpandSearcher(BuildPrefixMultiExpander(w.Words(), n), s)
+}
+
+// BuildFuzzyExpandSearcher constructs a prefix and fuzzy expander which wraps the given
+// Searcher by expanding each word in the search input using the WordIndex.  Words are
+// expanded to all words with the word as a prefix (see FuzzyPrefixExpand) followed by all
+// words within edit distance d (see BuildFuzzyExpander).
+func BuildFuzzyExpandSearcher(s Searcher, w WordIndex, n, d int) Searcher {
+	words := w.Words()
+	return NewExpandSearcher(MultiExpand{
+		FuzzyPrefixExpand{BuildPrefixMultiExpander(words, n)},
+		BuildFuzzyExpander(words, d),
+	}, s)
 }
 
 type trackWordIndex struct {
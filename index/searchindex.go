@@ -0,0 +1,363 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// SearchFields is the default list of fields used to build search indexes.
+var SearchFields = []string{"Composer", "Artist", "Album", "Name", "Conductor", "Orchestra", "Catalogue"}
+
+// SearchBoosts is the default relative weighting of SearchFields when ranking search
+// results.
+var SearchBoosts = map[string]float64{
+	"Album":    3.0,
+	"Composer": 2.0,
+}
+
+// SearchPrefixSize is the default maximum prefix length used by the prefix expander in
+// search indexes.
+const SearchPrefixSize = 10
+
+// SearchIndexVersion is the version of the search index format written by
+// WriteSearchIndex.
+const SearchIndexVersion = 2
+
+// searchIndexMagic is written at the start of every search index (followed by the format
+// version).
+var searchIndexMagic = []byte("TCHI")
+
+// SearchIndex is a precomputed search index for a Collection, which can be persisted using
+// WriteSearchIndex and ReadSearchIndex to avoid rebuilding it.
+type SearchIndex struct {
+	// Checksum is the checksum of the index parameters and the data used to build the
+	// index (see SearchIndexChecksum).
+	Checksum string
+
+	// Rank is the index used to find and score search results.
+	Rank *RankIndex
+
+	// Prefix is the prefix expander built from the words in Rank.
+	Prefix PrefixMultiExpand
+}
+
+// Close closes the io.ReaderAt that the SearchIndex was read from, if it is an io.Closer
+// (see ReadSearchIndex).
+func (s *SearchIndex) Close() error {
+	return s.Rank.Close()
+}
+
+// BuildSearchIndex builds a SearchIndex for the Collection, indexing the given fields (with
+// the given boosts, see BuildRankIndex) and expanding prefixes up to n characters (see
+// BuildPrefixMultiExpander).  The source identifies the data in the Collection, and is used
+// to compute the checksum of the index (see SearchIndexChecksum).
+func BuildSearchIndex(c Collection, source string, fields []string, boosts map[string]float64, n int) *SearchIndex {
+	r := BuildRankIndex(c, fields, boosts)
+	return &SearchIndex{
+		Checksum: SearchIndexChecksum(source, fields, boosts, n),
+		Rank:     r,
+		Prefix:   BuildPrefixMultiExpander(r.Words(), n),
+	}
+}
+
+// SearchIndexChecksum computes a checksum of the index parameters and the source, which
+// identifies the data used to build a SearchIndex: an index can be reused if the checksum
+// is unchanged.  Use LibraryFileSource for libraries read from a file (cheap), or
+// CollectionSource otherwise.
+func SearchIndexChecksum(source string, fields []string, boosts map[string]float64, n int) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d\x00%d\x00", SearchIndexVersion, n)
+	for _, f := range fields {
+		fmt.Fprintf(h, "%v\x00%v\x00", f, strconv.FormatFloat(boosts[f], 'g', -1, 64))
+	}
+	io.WriteString(h, source)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// LibraryFileSource returns the source (see SearchIndexChecksum) of a library read from
+// the file: its size and modification time.
+func LibraryFileSource(fi os.FileInfo) string {
+	return fmt.Sprintf("file\x00%d\x00%d", fi.Size(), fi.ModTime().UnixNano())
+}
+
+// CollectionSource returns the source (see SearchIndexChecksum) of the Collection: a hash
+// of the indexed fields of each track (along with the path of its Group).  The source does
+// not depend on the order of tracks within Groups.  All tracks in the Collection are read,
+// so prefer LibraryFileSource where possible.
+func CollectionSource(c Collection, fields []string) string {
+	var sums []string
+	Walk(c, Path([]Key{"Root"}), func(t Track, p Path) error {
+		h := sha1.New()
+		fmt.Fprintf(h, "%v\x00", p[:len(p)-1].Encode())
+		for _, f := range fields {
+			fmt.Fprintf(h, "%v\x00", t.GetString(f))
+		}
+		sums = append(sums, string(h.Sum(nil)))
+		return nil
+	})
+	sort.Strings(sums)
+
+	h := sha1.New()
+	for _, s := range sums {
+		io.WriteString(h, s)
+	}
+	return fmt.Sprintf("collection\x00%x", h.Sum(nil))
+}
+
+// searchIndexData is the gob-encoded header of a SearchIndex: everything except the
+// postings of each word.
+type searchIndexData struct {
+	Checksum string
+
+	Fields []string
+	Boosts []float64
+	AvgLen []float64
+	Docs   []rankDocData
+	Words  map[string]postingsData
+
+	PrefixWords map[string][]string
+	PrefixSize  int
+}
+
+type rankDocData struct {
+	Path Path
+	Len  []int
+}
+
+// postingsData is the location of the encoded postings of a word, relative to the start of
+// the postings.
+type postingsData struct {
+	DF           int
+	Offset, Size int64
+}
+
+// WriteSearchIndex writes the SearchIndex to the writer.  The format is a magic string and
+// format version, followed by the size of the header and the header (a gzipped
+// gob-encoded representation of the index without postings), and then the postings of
+// each word (see ReadSearchIndex).
+func WriteSearchIndex(s *SearchIndex, w io.Writer) error {
+	postings := &bytes.Buffer{}
+	d := searchIndexData{
+		Checksum:    s.Checksum,
+		Fields:      s.Rank.fields,
+		Boosts:      s.Rank.boosts,
+		AvgLen:      s.Rank.avgLen,
+		Docs:        make([]rankDocData, len(s.Rank.docs)),
+		Words:       make(map[string]postingsData, len(s.Rank.words)),
+		PrefixWords: s.Prefix.words,
+		PrefixSize:  s.Prefix.size,
+	}
+	for i, x := range s.Rank.docs {
+		d.Docs[i] = rankDocData{
+			Path: x.path,
+			Len:  x.len,
+		}
+	}
+	words := s.Rank.Words()
+	sort.Strings(words)
+	for _, x := range words {
+		offset := int64(postings.Len())
+		encodePostings(postings, s.Rank.postings(x))
+		d.Words[x] = postingsData{
+			DF:     s.Rank.words[x].df,
+			Offset: offset,
+			Size:   int64(postings.Len()) - offset,
+		}
+	}
+
+	header := &bytes.Buffer{}
+	gzw, err := gzip.NewWriterLevel(header, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(gzw).Encode(d)
+	if err != nil {
+		return err
+	}
+	err = gzw.Close()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(searchIndexMagic)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.BigEndian, uint32(SearchIndexVersion))
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.BigEndian, uint64(header.Len()))
+	if err != nil {
+		return err
+	}
+	_, err = header.WriteTo(w)
+	if err != nil {
+		return err
+	}
+	_, err = postings.WriteTo(w)
+	return err
+}
+
+// encodePostings writes the postings to the buffer: the number of postings followed by
+// the document index (the difference from the previous posting) and term frequencies of
+// each posting, all as uvarints.
+func encodePostings(buf *bytes.Buffer, list []posting) {
+	b := make([]byte, binary.MaxVarintLen64)
+	put := func(x int) {
+		n := binary.PutUvarint(b, uint64(x))
+		buf.Write(b[:n])
+	}
+
+	put(len(list))
+	prev := 0
+	for _, p := range list {
+		put(p.doc - prev)
+		prev = p.doc
+		for _, x := range p.tf {
+			put(x)
+		}
+	}
+}
+
+// decodePostings reads postings written by encodePostings, with term frequencies for the
+// given number of fields.  Document indices must be less than docs.
+func decodePostings(r io.Reader, fields, docs int) ([]posting, error) {
+	br := bufio.NewReader(r)
+	get := func() (int, error) {
+		x, err := binary.ReadUvarint(br)
+		if err == nil && x > math.MaxInt32 {
+			err = fmt.Errorf("value out of range: %d", x)
+		}
+		return int(x), err
+	}
+
+	n, err := get()
+	if err != nil {
+		return nil, err
+	}
+	if n > docs {
+		return nil, fmt.Errorf("invalid number of postings: %d", n)
+	}
+
+	list := make([]posting, n)
+	doc := 0
+	for i := range list {
+		d, err := get()
+		if err != nil {
+			return nil, err
+		}
+		doc += d
+		if doc >= docs {
+			return nil, fmt.Errorf("document index out of range: %d", doc)
+		}
+
+		tf := make([]int, fields)
+		for j := range tf {
+			tf[j], err = get()
+			if err != nil {
+				return nil, err
+			}
+		}
+		list[i] = posting{doc: doc, tf: tf}
+	}
+	return list, nil
+}
+
+// ReadSearchIndex reads a SearchIndex written by WriteSearchIndex.  Only the header of the
+// index is read: the postings of each word are read from r when they are first used, and
+// so r must remain readable for as long as the index is used (see SearchIndex.Close).
+func ReadSearchIndex(r io.ReaderAt) (*SearchIndex, error) {
+	head := make([]byte, len(searchIndexMagic)+12)
+	_, err := r.ReadAt(head, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading search index header: %v", err)
+	}
+	if !bytes.Equal(head[:len(searchIndexMagic)], searchIndexMagic) {
+		return nil, fmt.Errorf("invalid search index format")
+	}
+	head = head[len(searchIndexMagic):]
+
+	version := binary.BigEndian.Uint32(head)
+	if version != SearchIndexVersion {
+		return nil, fmt.Errorf("unsupported search index format version: %d", version)
+	}
+
+	size := binary.BigEndian.Uint64(head[4:])
+	if size > math.MaxInt64/2 {
+		return nil, fmt.Errorf("invalid search index header size: %d", size)
+	}
+	start := int64(len(searchIndexMagic) + 12)
+	gzr, err := gzip.NewReader(bufio.NewReader(io.NewSectionReader(r, start, int64(size))))
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	var d searchIndexData
+	err = gob.NewDecoder(gzr).Decode(&d)
+	if err != nil {
+		return nil, fmt.Errorf("error reading search index: %v", err)
+	}
+
+	if len(d.Boosts) != len(d.Fields) || len(d.AvgLen) != len(d.Fields) {
+		return nil, fmt.Errorf("invalid search index: field count mismatch")
+	}
+
+	postings := start + int64(size)
+	rank := &RankIndex{
+		fields: d.Fields,
+		boosts: d.Boosts,
+		avgLen: d.AvgLen,
+		docs:   make([]*rankDoc, len(d.Docs)),
+		words:  make(map[string]*postingList, len(d.Words)),
+		data:   r,
+	}
+	for i, x := range d.Docs {
+		if len(x.Len) != len(d.Fields) {
+			return nil, fmt.Errorf("invalid search index: field count mismatch")
+		}
+		rank.docs[i] = &rankDoc{
+			path: x.Path,
+			len:  x.Len,
+		}
+	}
+	for w, x := range d.Words {
+		if x.Offset < 0 || x.Size < 0 || x.DF < 0 || x.DF > len(rank.docs) {
+			return nil, fmt.Errorf("invalid search index: invalid postings for %#v", w)
+		}
+		rank.words[w] = &postingList{
+			df:     x.DF,
+			offset: postings + x.Offset,
+			size:   x.Size,
+		}
+	}
+
+	prefix := PrefixMultiExpand{
+		words: d.PrefixWords,
+		size:  d.PrefixSize,
+	}
+	if prefix.words == nil {
+		prefix.words = make(map[string][]string)
+	}
+
+	return &SearchIndex{
+		Checksum: d.Checksum,
+		Rank:     rank,
+		Prefix:   prefix,
+	}, nil
+}
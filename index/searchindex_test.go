@@ -0,0 +1,149 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"tchaik.com/index/attr"
+)
+
+var searchIndexTracks = testTracker{
+	{Name: "Prelude", Album: "Cello Suites", Composer: "Bach"},
+	{Name: "Allemande", Album: "Cello Suites", Composer: "Bach"},
+	{Name: "Cello Concerto", Album: "Concertos", Composer: "Dvořák"},
+	{Name: "Air on the G String", Album: "Orchestral Suites", Composer: "Bach"},
+}
+
+func TestSearchIndexEncodeDecode(t *testing.T) {
+	c := Collect(searchIndexTracks, By(attr.String("Album")))
+	si := BuildSearchIndex(c, CollectionSource(c, SearchFields), SearchFields, SearchBoosts, SearchPrefixSize)
+
+	buf := &bytes.Buffer{}
+	err := WriteSearchIndex(si, buf)
+	if err != nil {
+		t.Fatalf("unexpected error from WriteSearchIndex: %v", err)
+	}
+
+	got, err := ReadSearchIndex(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error from ReadSearchIndex: %v", err)
+	}
+
+	if got.Checksum != si.Checksum {
+		t.Errorf("Checksum = %v, expected: %v", got.Checksum, si.Checksum)
+	}
+	if !reflect.DeepEqual(got.Prefix, si.Prefix) {
+		t.Errorf("Prefix = %#v, expected: %#v", got.Prefix, si.Prefix)
+	}
+	if !reflect.DeepEqual(stringSet(got.Rank.Words()), stringSet(si.Rank.Words())) {
+		t.Errorf("Rank.Words() = %#v, expected: %#v", got.Rank.Words(), si.Rank.Words())
+	}
+	if !reflect.DeepEqual(got.Rank.docs, si.Rank.docs) {
+		t.Errorf("Rank.docs = %#v, expected: %#v", got.Rank.docs, si.Rank.docs)
+	}
+
+	// Postings are only read when they are used.
+	if l := got.Rank.words["cello"]; l.loaded || l.df != 2 {
+		t.Errorf("postings of %#v: loaded = %v, df = %d, expected: false, 2", "cello", l.loaded, l.df)
+	}
+	for _, w := range si.Rank.Words() {
+		if x, y := got.Rank.postings(w), si.Rank.postings(w); !reflect.DeepEqual(x, y) {
+			t.Errorf("postings(%#v) = %#v, expected: %#v", w, x, y)
+		}
+	}
+
+	expected := NewRankSearcher(si.Rank, si.Prefix)
+	s := NewRankSearcher(got.Rank, got.Prefix)
+	for _, in := range []string{"cello", "bach", "dvorak", "suites orch"} {
+		if x, y := s.SearchScored(in), expected.SearchScored(in); !reflect.DeepEqual(x, y) {
+			t.Errorf("SearchScored(%#v) = %v, expected: %v", in, x, y)
+		}
+	}
+}
+
+func TestReadSearchIndexInvalid(t *testing.T) {
+	for _, in := range [][]byte{nil, []byte("TCHK\x00\x00\x00\x01"), []byte("TCHI\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00"), []byte("TCHI\x00\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x10")} {
+		_, err := ReadSearchIndex(bytes.NewReader(in))
+		if err == nil {
+			t.Errorf("expected error from ReadSearchIndex(%q)", in)
+		}
+	}
+}
+
+func TestSearchIndexChecksum(t *testing.T) {
+	c := Collect(searchIndexTracks, By(attr.String("Album")))
+	source := CollectionSource(c, SearchFields)
+	sum := SearchIndexChecksum(source, SearchFields, SearchBoosts, SearchPrefixSize)
+
+	reversed := make(testTracker, len(searchIndexTracks))
+	for i, x := range searchIndexTracks {
+		reversed[len(reversed)-1-i] = x
+	}
+	if got := CollectionSource(Collect(reversed, By(attr.String("Album"))), SearchFields); got != source {
+		t.Errorf("source changed when track order changed")
+	}
+
+	if got := SearchIndexChecksum(source, SearchFields, SearchBoosts, SearchPrefixSize+1); got == sum {
+		t.Errorf("checksum unchanged when prefix size changed")
+	}
+	if got := SearchIndexChecksum(source, SearchFields, map[string]float64{"Album": 1.0}, SearchPrefixSize); got == sum {
+		t.Errorf("checksum unchanged when boosts changed")
+	}
+
+	changed := append(testTracker{}, searchIndexTracks...)
+	changed[0].Name = "Gigue"
+	if got := SearchIndexChecksum(CollectionSource(Collect(changed, By(attr.String("Album"))), SearchFields), SearchFields, SearchBoosts, SearchPrefixSize); got == sum {
+		t.Errorf("checksum unchanged when track name changed")
+	}
+}
+
+// closeReader is an io.ReaderAt which records when it is closed.
+type closeReader struct {
+	*bytes.Reader
+	closed bool
+}
+
+func (r *closeReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestSearchIndexClose(t *testing.T) {
+	c := Collect(searchIndexTracks, By(attr.String("Album")))
+	si := BuildSearchIndex(c, CollectionSource(c, SearchFields), SearchFields, SearchBoosts, SearchPrefixSize)
+	if err := si.Close(); err != nil {
+		t.Errorf("unexpected error from Close of built index: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	err := WriteSearchIndex(si, buf)
+	if err != nil {
+		t.Fatalf("unexpected error from WriteSearchIndex: %v", err)
+	}
+
+	r := &closeReader{Reader: bytes.NewReader(buf.Bytes())}
+	got, err := ReadSearchIndex(r)
+	if err != nil {
+		t.Fatalf("unexpected error from ReadSearchIndex: %v", err)
+	}
+	if len(got.Rank.Search("bach")) != 2 {
+		t.Errorf("Search(%#v) = %v, expected 2 paths", "bach", got.Rank.Search("bach"))
+	}
+
+	if err := got.Close(); err != nil {
+		t.Errorf("unexpected error from Close: %v", err)
+	}
+	if !r.closed {
+		t.Errorf("expected Close to close the reader")
+	}
+
+	// Loaded postings can still be used, others are empty.
+	if len(got.Rank.Search("bach")) != 2 || len(got.Rank.Search("cello")) != 0 {
+		t.Errorf("Search() after Close = (%v, %v), expected: 2 paths, no paths", got.Rank.Search("bach"), got.Rank.Search("cello"))
+	}
+}
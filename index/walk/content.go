@@ -0,0 +1,67 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package walk
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dhowden/tag"
+)
+
+// contentID returns the SHA1 sum of the audio data of the file (see streamInfo), so that it
+// doesn't change when the file is moved or its metadata tags are edited.
+func contentID(r io.ReadSeeker, s streamInfo, t tag.FileType) (string, error) {
+	if s.AudioSize <= 0 {
+		return "", errors.New("no audio data")
+	}
+	if _, err := r.Seek(s.AudioOffset, 0); err != nil {
+		return "", err
+	}
+
+	h := sha1.New()
+	lr := io.LimitReader(r, s.AudioSize)
+	var err error
+	if t == tag.OGG {
+		err = oggPageData(h, lr)
+	} else {
+		_, err = io.Copy(h, lr)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// oggPageData writes the data of the Ogg pages read from r to w, skipping the page headers
+// (which include sequence numbers that change when the comment packet changes size).
+func oggPageData(w io.Writer, r io.Reader) error {
+	h := make([]byte, 27+255)
+	for {
+		if _, err := io.ReadFull(r, h[:27]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if string(h[:4]) != "OggS" {
+			return errors.New("invalid Ogg page")
+		}
+
+		segments := h[27 : 27+int(h[26])]
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return err
+		}
+		var n int64
+		for _, x := range segments {
+			n += int64(x)
+		}
+		if _, err := io.CopyN(w, r, n); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,432 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package walk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// streamInfo is the information about the audio stream of a file.
+type streamInfo struct {
+	Duration time.Duration
+	BitRate  int // average bit rate (kbit/s)
+
+	// AudioOffset and AudioSize give the range of the file which contains the audio data
+	// (i.e. excluding metadata tags).  For Ogg files this is the range of the audio pages.
+	AudioOffset, AudioSize int64
+}
+
+// bitRate returns the average bit rate (kbit/s) of n bytes of audio data with duration d.
+func bitRate(n int64, d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((float64(n)*8/d.Seconds() + 500) / 1000)
+}
+
+// samplesDuration returns the duration of n samples at the sample rate.
+func samplesDuration(n, rate int64) time.Duration {
+	return time.Duration(float64(n) / float64(rate) * float64(time.Second))
+}
+
+// readStreamInfo reads the audio stream information from the file, which has size bytes and
+// type t (as detected by tag.ReadFrom).
+func readStreamInfo(r io.ReadSeeker, size int64, t tag.FileType) (streamInfo, error) {
+	if _, err := r.Seek(0, 0); err != nil {
+		return streamInfo{}, err
+	}
+
+	switch t {
+	case tag.MP3:
+		return mp3StreamInfo(r, size)
+	case tag.M4A, tag.M4B, tag.M4P, tag.ALAC:
+		return mp4StreamInfo(r, size)
+	case tag.FLAC:
+		return flacStreamInfo(r, size)
+	case tag.OGG:
+		return oggStreamInfo(r, size)
+	}
+	return streamInfo{}, fmt.Errorf("unsupported file type: %v", t)
+}
+
+// readAt reads len(b) bytes from r at offset.
+func readAt(r io.ReadSeeker, b []byte, offset int64) error {
+	if _, err := r.Seek(offset, 0); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(r, b)
+	return err
+}
+
+// id3v2Size returns the size of the ID3v2 tag at the start of r (or 0 if there isn't one),
+// where size is the size of r.  Returns an error if the tag is larger than r.
+func id3v2Size(r io.ReadSeeker, size int64) (int64, error) {
+	h := make([]byte, 10)
+	if err := readAt(r, h, 0); err != nil {
+		return 0, err
+	}
+	if string(h[:3]) != "ID3" {
+		return 0, nil
+	}
+	n := int64(h[6]&0x7F)<<21 | int64(h[7]&0x7F)<<14 | int64(h[8]&0x7F)<<7 | int64(h[9]&0x7F)
+	if h[5]&0x10 != 0 { // footer
+		n += 10
+	}
+	if n+10 >= size {
+		return 0, errors.New("invalid ID3v2 tag size")
+	}
+	return n + 10, nil
+}
+
+// mpegBitRates are the bit rates (kbit/s) indexed by [MPEG-1 or 2][layer][index].
+var mpegBitRates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448}, // Layer I
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},    // Layer II
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},     // Layer III
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+var mpegSampleRates = [3]int{44100, 48000, 32000}
+
+// mpegHeader is an MPEG audio frame header.
+type mpegHeader struct {
+	mpeg1   bool
+	layer   int // 1, 2 or 3
+	bitRate int // kbit/s
+	rate    int // Hz
+	mono    bool
+}
+
+// samples returns the number of samples in the frame.
+func (h mpegHeader) samples() int {
+	switch {
+	case h.layer == 1:
+		return 384
+	case h.layer == 3 && !h.mpeg1:
+		return 576
+	}
+	return 1152
+}
+
+// parseMPEGHeader parses the MPEG audio frame header in b.  Returns false if b isn't a
+// valid header.
+func parseMPEGHeader(b []byte) (mpegHeader, bool) {
+	if b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mpegHeader{}, false
+	}
+	version := (b[1] >> 3) & 0x03 // 0: MPEG-2.5, 2: MPEG-2, 3: MPEG-1
+	layer := 4 - int((b[1]>>1)&0x03)
+	bitRateIndex := b[2] >> 4
+	rateIndex := (b[2] >> 2) & 0x03
+	if version == 1 || layer == 4 || bitRateIndex == 0 || bitRateIndex == 15 || rateIndex == 3 {
+		return mpegHeader{}, false
+	}
+
+	h := mpegHeader{
+		mpeg1: version == 3,
+		layer: layer,
+		rate:  mpegSampleRates[rateIndex],
+		mono:  b[3]>>6 == 3,
+	}
+	v := 0
+	if !h.mpeg1 {
+		v = 1
+		h.rate /= 2
+		if version == 0 {
+			h.rate /= 2
+		}
+	}
+	h.bitRate = mpegBitRates[v][layer-1][bitRateIndex]
+	return h, true
+}
+
+// mp3SearchLimit is the maximum number of bytes searched for the first MPEG audio frame.
+const mp3SearchLimit = 64 * 1024
+
+// mp3StreamInfo reads the stream information from an MP3 file.  VBR files are expected to
+// have a Xing (or Info) or VBRI header in the first frame, otherwise the bit rate of the
+// first frame is used for the whole file.
+func mp3StreamInfo(r io.ReadSeeker, size int64) (streamInfo, error) {
+	start, err := id3v2Size(r, size)
+	if err != nil {
+		return streamInfo{}, err
+	}
+
+	// Find the first frame.
+	buf := make([]byte, mp3SearchLimit)
+	if start+int64(len(buf)) > size {
+		buf = buf[:size-start]
+	}
+	if err := readAt(r, buf, start); err != nil {
+		return streamInfo{}, err
+	}
+	var h mpegHeader
+	i := 0
+	for ok := false; !ok; i++ {
+		if i+4 > len(buf) {
+			return streamInfo{}, errors.New("no MPEG audio frames found")
+		}
+		h, ok = parseMPEGHeader(buf[i:])
+	}
+	i--
+	frame := buf[i:]
+	start += int64(i)
+
+	end := size
+	id3v1 := make([]byte, 3)
+	if size-start > 128 && readAt(r, id3v1, size-128) == nil && string(id3v1) == "TAG" {
+		end -= 128 // ID3v1
+	}
+
+	sideInfo := 32
+	switch {
+	case h.mpeg1 && h.mono, !h.mpeg1 && !h.mono:
+		sideInfo = 17
+	case !h.mpeg1 && h.mono:
+		sideInfo = 9
+	}
+
+	var frames, n int64
+	if x := 4 + sideInfo; len(frame) >= x+16 && (string(frame[x:x+4]) == "Xing" || string(frame[x:x+4]) == "Info") {
+		flags := binary.BigEndian.Uint32(frame[x+4:])
+		x += 8
+		if flags&0x01 != 0 {
+			frames = int64(binary.BigEndian.Uint32(frame[x:]))
+			x += 4
+		}
+		if flags&0x02 != 0 {
+			n = int64(binary.BigEndian.Uint32(frame[x:]))
+		}
+	} else if x := 4 + 32; len(frame) >= x+18 && string(frame[x:x+4]) == "VBRI" {
+		n = int64(binary.BigEndian.Uint32(frame[x+10:]))
+		frames = int64(binary.BigEndian.Uint32(frame[x+14:]))
+	}
+
+	if frames > 0 {
+		d := samplesDuration(frames*int64(h.samples()), int64(h.rate))
+		if n == 0 {
+			n = end - start
+		}
+		return streamInfo{Duration: d, BitRate: bitRate(n, d), AudioOffset: start, AudioSize: end - start}, nil
+	}
+
+	d := time.Duration(end-start) * 8 * time.Millisecond / time.Duration(h.bitRate)
+	return streamInfo{Duration: d, BitRate: h.bitRate, AudioOffset: start, AudioSize: end - start}, nil
+}
+
+// flacStreamInfo reads the stream information from the STREAMINFO block of a FLAC file.
+func flacStreamInfo(r io.ReadSeeker, size int64) (streamInfo, error) {
+	offset, err := id3v2Size(r, size)
+	if err != nil {
+		return streamInfo{}, err
+	}
+
+	b := make([]byte, 4)
+	if err := readAt(r, b, offset); err != nil {
+		return streamInfo{}, err
+	}
+	if string(b) != "fLaC" {
+		return streamInfo{}, errors.New("invalid FLAC stream marker")
+	}
+	offset += 4
+
+	var rate int64
+	var samples int64
+	for last := false; !last; {
+		if err := readAt(r, b, offset); err != nil {
+			return streamInfo{}, err
+		}
+		last = b[0]&0x80 != 0
+		n := int64(b[1])<<16 | int64(b[2])<<8 | int64(b[3])
+
+		if b[0]&0x7F == 0 { // STREAMINFO
+			info := make([]byte, 18)
+			if err := readAt(r, info, offset+4); err != nil {
+				return streamInfo{}, err
+			}
+			rate = int64(info[10])<<12 | int64(info[11])<<4 | int64(info[12])>>4
+			samples = int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:]))
+		}
+		offset += 4 + n
+	}
+
+	if rate == 0 {
+		return streamInfo{}, errors.New("no FLAC STREAMINFO block")
+	}
+	if offset > size {
+		return streamInfo{}, errors.New("invalid FLAC metadata block size")
+	}
+	d := samplesDuration(samples, rate)
+	return streamInfo{Duration: d, BitRate: bitRate(size-offset, d), AudioOffset: offset, AudioSize: size - offset}, nil
+}
+
+// mp4Atom returns the offset and size of the content of the first atom with the name in
+// the range [offset, end).
+func mp4Atom(r io.ReadSeeker, name string, offset, end int64) (int64, int64, error) {
+	h := make([]byte, 16)
+	for offset+8 <= end {
+		if err := readAt(r, h[:8], offset); err != nil {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(h))
+		header := int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if err := readAt(r, h[8:], offset+8); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(h[8:]))
+			header = 16
+		}
+		if size < header {
+			return 0, 0, fmt.Errorf("invalid size for atom '%s': %d", h[4:8], size)
+		}
+
+		if string(h[4:8]) == name {
+			return offset + header, size - header, nil
+		}
+		offset += size
+	}
+	return 0, 0, fmt.Errorf("atom not found: %v", name)
+}
+
+// mp4StreamInfo reads the stream information from the mvhd atom of an MP4 file.  The bit rate
+// is computed from the size of the mdat atom.
+func mp4StreamInfo(r io.ReadSeeker, size int64) (streamInfo, error) {
+	moov, moovSize, err := mp4Atom(r, "moov", 0, size)
+	if err != nil {
+		return streamInfo{}, err
+	}
+	mvhd, _, err := mp4Atom(r, "mvhd", moov, moov+moovSize)
+	if err != nil {
+		return streamInfo{}, err
+	}
+
+	b := make([]byte, 32)
+	if err := readAt(r, b, mvhd); err != nil {
+		return streamInfo{}, err
+	}
+
+	var scale, duration uint64
+	if b[0] == 1 {
+		scale = uint64(binary.BigEndian.Uint32(b[20:]))
+		duration = binary.BigEndian.Uint64(b[24:])
+	} else {
+		scale = uint64(binary.BigEndian.Uint32(b[12:]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:]))
+	}
+	if scale == 0 {
+		return streamInfo{}, errors.New("invalid mvhd time scale")
+	}
+	d := samplesDuration(int64(duration), int64(scale))
+
+	s := streamInfo{Duration: d, BitRate: bitRate(size, d)}
+	if mdat, mdatSize, err := mp4Atom(r, "mdat", 0, size); err == nil {
+		s.BitRate = bitRate(mdatSize, d)
+		s.AudioOffset, s.AudioSize = mdat, mdatSize
+	}
+	return s, nil
+}
+
+// oggSearchLimit is the number of bytes at the end of an Ogg file which are searched for
+// the last page.
+const oggSearchLimit = 64 * 1024
+
+// oggStreamInfo reads the stream information from an Ogg (Vorbis or Opus) file, using the
+// granule position of the last page.
+func oggStreamInfo(r io.ReadSeeker, size int64) (streamInfo, error) {
+	h := make([]byte, 27+255+19)
+	if size < int64(len(h)) {
+		h = h[:size]
+	}
+	if err := readAt(r, h, 0); err != nil {
+		return streamInfo{}, err
+	}
+	if len(h) < 28 || string(h[:4]) != "OggS" || 27+int(h[26]) > len(h) {
+		return streamInfo{}, errors.New("invalid Ogg page")
+	}
+	packet := h[27+int(h[26]):]
+
+	var rate, skip int64
+	switch {
+	case len(packet) >= 16 && string(packet[:7]) == "\x01vorbis":
+		rate = int64(binary.LittleEndian.Uint32(packet[12:]))
+	case len(packet) >= 19 && string(packet[:8]) == "OpusHead":
+		rate = 48000 // granule positions are always at 48kHz
+		skip = int64(binary.LittleEndian.Uint16(packet[10:]))
+	default:
+		return streamInfo{}, errors.New("unsupported Ogg codec")
+	}
+	if rate == 0 {
+		return streamInfo{}, errors.New("invalid sample rate")
+	}
+
+	offset := size - oggSearchLimit
+	if offset < 0 {
+		offset = 0
+	}
+	buf := make([]byte, size-offset)
+	if err := readAt(r, buf, offset); err != nil {
+		return streamInfo{}, err
+	}
+	i := bytes.LastIndex(buf, []byte("OggS"))
+	if i == -1 || i+14 > len(buf) {
+		return streamInfo{}, errors.New("could not find last Ogg page")
+	}
+
+	granule := int64(binary.LittleEndian.Uint64(buf[i+6:])) - skip
+	if granule <= 0 {
+		return streamInfo{}, errors.New("invalid granule position")
+	}
+	d := samplesDuration(granule, rate)
+	s := streamInfo{Duration: d, BitRate: bitRate(size, d)}
+	if audio, err := oggAudioOffset(r, size); err == nil {
+		s.AudioOffset, s.AudioSize = audio, size-audio
+	}
+	return s, nil
+}
+
+// oggAudioOffset returns the offset of the first audio page in an Ogg file, that is the first
+// page after the header packets (identification, comment etc) which all have a granule
+// position of zero (or -1 for pages on which no packet ends).
+func oggAudioOffset(r io.ReadSeeker, size int64) (int64, error) {
+	h := make([]byte, 27+255)
+	for offset := int64(0); offset+27 <= size; {
+		if err := readAt(r, h[:27], offset); err != nil {
+			return 0, err
+		}
+		if string(h[:4]) != "OggS" {
+			return 0, errors.New("invalid Ogg page")
+		}
+		if granule := int64(binary.LittleEndian.Uint64(h[6:])); granule != 0 && granule != -1 {
+			return offset, nil
+		}
+
+		segments := h[27 : 27+int(h[26])]
+		if err := readAt(r, segments, offset+27); err != nil {
+			return 0, err
+		}
+		offset += 27 + int64(len(segments))
+		for _, n := range segments {
+			offset += int64(n)
+		}
+	}
+	return 0, errors.New("no Ogg audio pages")
+}
@@ -0,0 +1,203 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package walk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/dhowden/tag"
+
+	"tchaik.com/index"
+)
+
+// mp3CBR returns n bytes of MPEG-1 Layer III data at 128kbit/s, 44.1kHz.
+func mp3CBR(n int) []byte {
+	b := make([]byte, n)
+	copy(b, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return b
+}
+
+// mp3Xing returns an MPEG-1 Layer III stream with a Xing header giving the number of frames
+// and bytes.
+func mp3Xing(frames, n uint32) []byte {
+	b := mp3CBR(417)
+	b[3] = 0xC0 // mono: side info is 17 bytes
+	x := 4 + 17
+	copy(b[x:], "Xing")
+	binary.BigEndian.PutUint32(b[x+4:], 0x03)
+	binary.BigEndian.PutUint32(b[x+8:], frames)
+	binary.BigEndian.PutUint32(b[x+12:], n)
+	return append(b, make([]byte, 1000)...)
+}
+
+func flacFile(rate, samples uint32, audio int) []byte {
+	info := make([]byte, 34)
+	info[10] = byte(rate >> 12)
+	info[11] = byte(rate >> 4)
+	info[12] = byte(rate << 4)
+	binary.BigEndian.PutUint32(info[14:], samples)
+
+	b := []byte("fLaC")
+	b = append(b, 0x80, 0, 0, byte(len(info))) // last block, STREAMINFO
+	b = append(b, info...)
+	return append(b, make([]byte, audio)...)
+}
+
+// flacBlockOverflow returns a FLAC file whose last metadata block is larger than the file.
+func flacBlockOverflow() []byte {
+	b := flacFile(44100, 88200, 0)
+	b[4] = 0 // STREAMINFO isn't the last block
+	return append(b, 0x81, 0xFF, 0xFF, 0xFF)
+}
+
+func oggPage(granule uint64, packet []byte) []byte {
+	h := make([]byte, 27)
+	copy(h, "OggS")
+	binary.LittleEndian.PutUint64(h[6:], granule)
+	h[26] = 1
+	h = append(h, byte(len(packet)))
+	return append(h, packet...)
+}
+
+func oggVorbis(rate uint32, granule uint64) []byte {
+	id := make([]byte, 30)
+	copy(id, "\x01vorbis")
+	binary.LittleEndian.PutUint32(id[12:], rate)
+	b := oggPage(0, id)
+	b = append(b, make([]byte, 1000)...)
+	return append(b, oggPage(granule, make([]byte, 10))...)
+}
+
+func TestReadStreamInfo(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		t        tag.FileType
+		duration time.Duration
+		bitRate  int
+	}{
+		{mp3CBR(16000), tag.MP3, time.Second, 128},
+		{append(make([]byte, 100), mp3CBR(16000)...), tag.MP3, time.Second, 128},
+		{mp3Xing(1000, 500000), tag.MP3, 26122448979, 153},
+		{flacFile(44100, 88200, 1000), tag.FLAC, 2 * time.Second, 4},
+		{oggVorbis(48000, 96000), tag.OGG, 2 * time.Second, 4},
+	}
+
+	for ii, tt := range tests {
+		s, err := readStreamInfo(bytes.NewReader(tt.data), int64(len(tt.data)), tt.t)
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %v", ii, err)
+			continue
+		}
+		if s.Duration != tt.duration || s.BitRate != tt.bitRate {
+			t.Errorf("[%d] readStreamInfo() = (%v, %d), expected: (%v, %d)", ii, s.Duration, s.BitRate, tt.duration, tt.bitRate)
+		}
+	}
+}
+
+func TestReadStreamInfoInvalid(t *testing.T) {
+	tests := []struct {
+		data []byte
+		t    tag.FileType
+	}{
+		{append([]byte{'I', 'D', '3', 4, 0, 0, 0x7F, 0x7F, 0x7F, 0x7F}, make([]byte, 190)...), tag.MP3},
+		{append([]byte{'I', 'D', '3', 4, 0, 0, 0x7F, 0x7F, 0x7F, 0x7F}, make([]byte, 190)...), tag.FLAC},
+		{make([]byte, 200), tag.MP3},
+		{oggVorbis(48000, 96000)[:60], tag.OGG},
+		{append([]byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xFF"), make([]byte, 33)...), tag.OGG},
+		{oggPage(0, make([]byte, 30)), tag.OGG},
+		{oggVorbis(0, 96000), tag.OGG},
+		{oggVorbis(48000, 0), tag.OGG},
+		{flacFile(44100, 88200, 0)[:20], tag.FLAC},
+		{flacBlockOverflow(), tag.FLAC},
+		{[]byte("fLaC"), tag.FLAC},
+		{append([]byte{0, 0, 0, 16}, []byte("moov")...), tag.M4A},
+		{append([]byte{0, 0, 0, 4}, []byte("moov")...), tag.M4A},
+	}
+
+	for ii, tt := range tests {
+		_, err := readStreamInfo(bytes.NewReader(tt.data), int64(len(tt.data)), tt.t)
+		if err == nil {
+			t.Errorf("[%d] readStreamInfo() returned nil error, expected non-nil", ii)
+		}
+	}
+}
+
+// id3v2 returns an empty ID3v2 tag with n bytes of padding.
+func id3v2(n int) []byte {
+	return append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(n >> 7), byte(n & 0x7F)}, make([]byte, n)...)
+}
+
+func TestContentID(t *testing.T) {
+	audio := mp3CBR(16000)
+	audio[1000] = 1
+
+	// Ogg files where the comment packet spans a different number of pages.
+	id := make([]byte, 30)
+	copy(id, "\x01vorbis")
+	binary.LittleEndian.PutUint32(id[12:], 48000)
+	ogg := func(comments int) []byte {
+		b := oggPage(0, id)
+		for i := 0; i < comments; i++ {
+			b = append(b, oggPage(^uint64(0), make([]byte, 200))...)
+		}
+		b = append(b, oggPage(48000, []byte("audio"))...)
+		return append(b, oggPage(96000, []byte("more audio"))...)
+	}
+
+	tests := []struct {
+		a, b  []byte
+		t     tag.FileType
+		equal bool
+	}{
+		{audio, append(id3v2(100), audio...), tag.MP3, true},
+		{audio, mp3CBR(16000), tag.MP3, false},
+		{flacFile(44100, 88200, 1000), flacFile(44100, 88200, 1001), tag.FLAC, false},
+		{ogg(1), ogg(3), tag.OGG, true},
+	}
+
+	for ii, tt := range tests {
+		var ids []string
+		for _, b := range [][]byte{tt.a, tt.b} {
+			r := bytes.NewReader(b)
+			s, err := readStreamInfo(r, int64(len(b)), tt.t)
+			if err != nil {
+				t.Fatalf("[%d] unexpected error from readStreamInfo: %v", ii, err)
+			}
+			id, err := contentID(r, s, tt.t)
+			if err != nil {
+				t.Fatalf("[%d] unexpected error from contentID: %v", ii, err)
+			}
+			ids = append(ids, id)
+		}
+		if (ids[0] == ids[1]) != tt.equal {
+			t.Errorf("[%d] contentID() = %v and %v, expected equal: %v", ii, ids[0], ids[1], tt.equal)
+		}
+	}
+}
+
+func TestUniqueContentIDs(t *testing.T) {
+	tracks := map[string]index.Track{
+		"/b.mp3": &track{ContentID: "abc"},
+		"/a.mp3": &track{ContentID: "abc"},
+		"/c.mp3": &track{ContentID: "abc"},
+		"/d.mp3": &track{Location: "/d.mp3"},
+	}
+	uniqueContentIDs(tracks)
+
+	expected := map[string]string{
+		"/a.mp3": "abc",
+		"/b.mp3": "abc-2",
+		"/c.mp3": "abc-3",
+		"/d.mp3": "",
+	}
+	for p, id := range expected {
+		if got := tracks[p].(*track).ContentID; got != id {
+			t.Errorf("ContentID of %v = %#v, expected: %#v", p, got, id)
+		}
+	}
+}
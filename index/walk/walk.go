@@ -8,6 +8,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -37,6 +39,16 @@ func validFiles(in <-chan string) <-chan string {
 
 var workers = 4
 
+// Options are the options used when reading tracks from audio files.
+type Options struct {
+	// ContentID sets the ID of each track to the SHA1 sum of its audio data (ignoring
+	// metadata tags) instead of the SHA1 sum of its path, so that IDs don't change when
+	// files are moved, renamed or re-tagged.  The whole of each file has to be read.  Files
+	// with identical audio data are given unique IDs by adding a suffix (in path order), and
+	// files whose audio data can't be found keep the path-based ID.
+	ContentID bool
+}
+
 type pathTrack struct {
 	path  string
 	track *track
@@ -44,10 +56,23 @@ type pathTrack struct {
 
 // NewLibrary constructs an index.Library by walking through the directory tree under
 // the given path.  Any errors are logged to stdout (TODO: fix this!)
-func NewLibrary(path string) index.Library {
+func NewLibrary(path string, o Options) index.Library {
+	tracks := make(map[string]index.Track)
+	for p, t := range processPaths(validFiles(walk(path)), o) {
+		tracks[p] = t
+	}
+	uniqueContentIDs(tracks)
+
+	return &library{
+		tracks: tracks,
+	}
+}
+
+// processPaths reads the tracks from the files passed on the channel using a pool of
+// workers.  Returns a map of path -> track for all files which were read successfully.
+func processPaths(files <-chan string, o Options) map[string]*track {
 	trackCh := make(chan pathTrack)
 	errCh := make(chan error)
-	files := validFiles(walk(path))
 
 	go func() {
 		for err := range errCh {
@@ -58,7 +83,7 @@ func NewLibrary(path string) index.Library {
 
 	process := func(files <-chan string) {
 		for p := range files {
-			t, err := processPath(p)
+			t, err := processPath(p, o)
 			if err != nil {
 				errCh <- fmt.Errorf("error processing '%v': %v", p, err)
 				continue
@@ -86,15 +111,105 @@ func NewLibrary(path string) index.Library {
 	for pt := range trackCh {
 		tracks[pt.path] = pt.track
 	}
+	return tracks
+}
+
+// Summary is a list of the changes made to a library by Update.  Each list contains
+// the locations of the affected tracks.
+type Summary struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// Update constructs an index.Library by walking through the directory tree under the
+// given path, re-using tracks from the existing library l wherever the underlying file
+// has the same size and modification time as when the track was last read.  New files
+// are added, files which have changed are re-read, and tracks whose files no longer exist
+// under the path are dropped.  Tracks are also re-read if they weren't read with the same
+// Options.  Any errors are logged to stdout (as in NewLibrary).
+func Update(l index.Library, path string, o Options) (index.Library, Summary) {
+	existing := make(map[string]index.Track)
+	for _, t := range l.Tracks() {
+		existing[t.GetString("Location")] = t
+	}
+
+	tracks := make(map[string]index.Track)
+	var added, changed, removed []string
+	for p := range validFiles(walk(path)) {
+		t, ok := existing[p]
+		if !ok {
+			added = append(added, p)
+			continue
+		}
+		delete(existing, p)
+
+		fileInfo, err := os.Stat(p)
+		if err != nil {
+			log.Printf("error processing '%v': %v", p, err)
+			removed = append(removed, p)
+			continue
+		}
+		if unchanged(t, fileInfo) && (t.GetString("ContentID") != "") == o.ContentID {
+			tracks[p] = t
+			continue
+		}
+		changed = append(changed, p)
+	}
+
+	for p := range existing {
+		removed = append(removed, p)
+	}
+
+	files := make(chan string)
+	go func() {
+		for _, p := range added {
+			files <- p
+		}
+		for _, p := range changed {
+			files <- p
+		}
+		close(files)
+	}()
+	processed := processPaths(files, o)
+	for p, t := range processed {
+		tracks[p] = t
+	}
 
+	var s Summary
+	s.Removed = removed
+	for _, p := range added {
+		if _, ok := processed[p]; ok {
+			s.Added = append(s.Added, p)
+		}
+	}
+	for _, p := range changed {
+		if _, ok := processed[p]; ok {
+			s.Changed = append(s.Changed, p)
+			continue
+		}
+		s.Removed = append(s.Removed, p)
+	}
+
+	sort.Strings(s.Added)
+	sort.Strings(s.Changed)
+	sort.Strings(s.Removed)
+
+	uniqueContentIDs(tracks)
 	return &library{
 		tracks: tracks,
-	}
+	}, s
+}
+
+// unchanged returns true if the file described by fileInfo has the same size and
+// modification time as when the track t was read.
+func unchanged(t index.Track, fileInfo os.FileInfo) bool {
+	return int64(t.GetInt("Size")) == fileInfo.Size() && t.GetTime("DateModified").Equal(fileInfo.ModTime())
 }
 
 // library is an implementation of index.library.
 type library struct {
-	tracks map[string]*track
+	tracks map[string]index.Track
 }
 
 // Track implements index.Library.
@@ -118,6 +233,8 @@ type track struct {
 	Location    string
 	FileInfo    os.FileInfo
 	CreatedTime time.Time
+	Stream      streamInfo
+	ContentID   string
 }
 
 // GetString implements index.Track.
@@ -146,12 +263,75 @@ func (m *track) GetString(name string) string {
 	case "Kind":
 		return kind(m.FileType()).String()
 	case "ID":
-		sum := sha1.Sum([]byte(m.Location))
-		return string(fmt.Sprintf("%x", sum))
+		if m.ContentID != "" {
+			return m.ContentID
+		}
+		return pathID(m.Location)
+	case "ContentID":
+		return m.ContentID
+	case "Comment":
+		return m.Comment()
+	}
+	if keys, ok := rawStringFields[name]; ok {
+		return rawString(m.Raw(), keys)
+	}
+	return ""
+}
+
+// rawStringFields is a mapping of extra string attributes (see index.RegisterField) to
+// the raw tag names (ID3v2, Vorbis comments, MP4 atoms and ID3v2 TXXX/MP4 freeform
+// descriptions) which hold them.
+var rawStringFields = map[string][]string{
+	"Conductor":           {"TPE3", "TP3", "CONDUCTOR", "\xa9con"},
+	"Orchestra":           {"ORCHESTRA", "ENSEMBLE"},
+	"MusicBrainzTrackID":  {"MUSICBRAINZ_TRACKID", "MusicBrainz Track Id"},
+	"MusicBrainzAlbumID":  {"MUSICBRAINZ_ALBUMID", "MusicBrainz Album Id"},
+	"MusicBrainzArtistID": {"MUSICBRAINZ_ARTISTID", "MusicBrainz Artist Id"},
+}
+
+// rawIntFields is a mapping of extra int attributes (see index.RegisterField) to the raw
+// tag names which hold them.
+var rawIntFields = map[string][]string{
+	"BPM": {"TBPM", "TBP", "BPM", "tmpo"},
+}
+
+// rawValue returns the first value in the raw tag data with a name (or description,
+// for user-defined text frames) matching one of the keys (ignoring case).
+func rawValue(raw map[string]interface{}, keys []string) interface{} {
+	for _, k := range keys {
+		for rk, v := range raw {
+			if strings.EqualFold(rk, k) {
+				return v
+			}
+			if c, ok := v.(*tag.Comm); ok && strings.EqualFold(c.Description, k) {
+				return c.Text
+			}
+		}
+	}
+	return nil
+}
+
+func rawString(raw map[string]interface{}, keys []string) string {
+	switch v := rawValue(raw, keys).(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case *tag.Comm:
+		return strings.TrimSpace(v.Text)
 	}
 	return ""
 }
 
+func rawInt(raw map[string]interface{}, keys []string) int {
+	switch v := rawValue(raw, keys).(type) {
+	case int:
+		return v
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(v))
+		return n
+	}
+	return 0
+}
+
 type kind tag.FileType
 
 func (k kind) String() string {
@@ -196,6 +376,15 @@ func (m *track) GetInt(name string) int {
 	case "DiscCount":
 		_, n := m.Disc()
 		return n
+	case "Size":
+		return int(m.FileInfo.Size())
+	case "TotalTime":
+		return int(m.Stream.Duration / time.Millisecond)
+	case "BitRate":
+		return m.Stream.BitRate
+	}
+	if keys, ok := rawIntFields[name]; ok {
+		return rawInt(m.Raw(), keys)
 	}
 	return 0
 }
@@ -234,7 +423,43 @@ func walk(root string) <-chan string {
 	return ch
 }
 
-func processPath(path string) (*track, error) {
+// uniqueContentIDs adds a suffix to the content IDs of tracks (keyed by path) which have the
+// same ID as another track, so that tracks with identical audio data aren't lost when the
+// library is converted (see index.Convert).  Tracks which already have IDs (i.e. read from
+// an existing library) keep them, and the remaining tracks are considered in path order.
+func uniqueContentIDs(tracks map[string]index.Track) {
+	paths := make([]string, 0, len(tracks))
+	ids := make(map[string]bool, len(tracks))
+	for p, t := range tracks {
+		if _, ok := t.(*track); !ok {
+			ids[t.GetString("ID")] = true
+			continue
+		}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		t := tracks[p].(*track)
+		if t.ContentID == "" {
+			continue
+		}
+		id := t.ContentID
+		for n := 2; ids[id]; n++ {
+			id = fmt.Sprintf("%v-%d", t.ContentID, n)
+		}
+		t.ContentID = id
+		ids[id] = true
+	}
+}
+
+// pathID returns the ID of a track derived from its location: the SHA1 sum of the path.
+func pathID(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return fmt.Sprintf("%x", sum)
+}
+
+func processPath(path string, o Options) (*track, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -256,10 +481,30 @@ func processPath(path string) (*track, error) {
 		return nil, err
 	}
 
+	// Missing stream information isn't fatal: the track is still usable without a
+	// duration or bit rate.
+	stream, err := readStreamInfo(f, fileInfo.Size(), m.FileType())
+	if err != nil {
+		log.Printf("error reading stream info from '%v': %v", path, err)
+	}
+
+	var id string
+	if o.ContentID {
+		id, err = contentID(f, stream, m.FileType())
+		if err != nil {
+			// The path-based ID is kept as the content ID so that Update can tell that the
+			// track was read with ContentID set, and doesn't re-read it.
+			log.Printf("error computing content ID of '%v' (using path-based ID): %v", path, err)
+			id = pathID(path)
+		}
+	}
+
 	return &track{
 		Metadata:    m,
 		Location:    path,
 		FileInfo:    fileInfo,
 		CreatedTime: createdTime,
+		Stream:      stream,
+		ContentID:   id,
 	}, nil
 }
@@ -0,0 +1,63 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package walk
+
+import "time"
+
+// Watcher watches a directory tree for changes to files.
+type Watcher struct {
+	// C receives a value when files in the directory tree have changed.
+	C <-chan struct{}
+
+	close func() error
+}
+
+// NewWatcher creates a Watcher for the directory tree under path.  Changes are coalesced
+// so that C only receives a value once no further changes have been observed for the
+// given delay.
+func NewWatcher(path string, delay time.Duration) (*Watcher, error) {
+	events := make(chan struct{})
+	closeFn, err := watch(path, events)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan struct{}, 1)
+	go debounce(events, c, delay)
+
+	return &Watcher{
+		C:     c,
+		close: closeFn,
+	}, nil
+}
+
+// Close stops the Watcher, after which C is closed.
+func (w *Watcher) Close() error {
+	return w.close()
+}
+
+// debounce sends a value on out once no values have been received from in for the
+// duration d.  Values which can't be sent immediately are dropped (so out should be
+// buffered).  When in is closed, out is closed.
+func debounce(in <-chan struct{}, out chan<- struct{}, d time.Duration) {
+	var fire <-chan time.Time
+	for {
+		select {
+		case _, ok := <-in:
+			if !ok {
+				close(out)
+				return
+			}
+			fire = time.After(d)
+
+		case <-fire:
+			fire = nil
+			select {
+			case out <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// +build linux
+
+package walk
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF
+
+// inotify watches a directory tree using inotify(7).  Watches are added for every
+// directory in the tree, including those created after the watch has started.
+type inotify struct {
+	fd   int
+	f    *os.File
+	dirs map[int]string // watch descriptor -> directory path
+
+	sync.Mutex
+	closed bool
+}
+
+func watch(root string, events chan<- struct{}) (func() error, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising inotify: %v", err)
+	}
+
+	w := &inotify{
+		fd:   fd,
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int]string),
+	}
+	if err := w.addTree(root); err != nil {
+		w.f.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer close(events)
+
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := w.f.Read(buf)
+			if err != nil {
+				if !w.isClosed() {
+					log.Printf("error reading inotify events: %v", err)
+				}
+				return
+			}
+			w.process(buf[:n])
+			events <- struct{}{}
+		}
+	}()
+	return w.close, nil
+}
+
+func (w *inotify) close() error {
+	w.Lock()
+	w.closed = true
+	w.Unlock()
+
+	return w.f.Close()
+}
+
+func (w *inotify) isClosed() bool {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.closed
+}
+
+// addTree adds watches for root and all directories beneath it.
+func (w *inotify) addTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			return fmt.Errorf("error watching '%v': %v", path, err)
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+}
+
+// process reads the events in buf, adding watches for any new directories and removing
+// references to directories which are no longer watched.
+func (w *inotify) process(buf []byte) {
+	for off := 0; off+unix.SizeofInotifyEvent <= len(buf); {
+		e := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(e.Len)]
+		off += unix.SizeofInotifyEvent + int(e.Len)
+
+		if e.Mask&unix.IN_IGNORED != 0 {
+			delete(w.dirs, int(e.Wd))
+			continue
+		}
+
+		if e.Mask&unix.IN_ISDIR != 0 && e.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+			dir, ok := w.dirs[int(e.Wd)]
+			if !ok {
+				continue
+			}
+			path := filepath.Join(dir, strings.TrimRight(string(name), "\x00"))
+			if err := w.addTree(path); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// +build !linux
+
+package walk
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pollInterval is the interval between scans of the directory tree on platforms where
+// file system notifications are not supported.
+var pollInterval = 30 * time.Second
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshot returns the state of all the files in the directory tree under root.
+func snapshot(root string) (map[string]fileState, error) {
+	m := make(map[string]fileState)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		m[path] = fileState{
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		return nil
+	})
+	return m, err
+}
+
+func changed(x, y map[string]fileState) bool {
+	if len(x) != len(y) {
+		return true
+	}
+	for k, v := range x {
+		w, ok := y[k]
+		if !ok || v.size != w.size || !v.modTime.Equal(w.modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func watch(root string, events chan<- struct{}) (func() error, error) {
+	prev, err := snapshot(root)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+
+		t := time.NewTicker(pollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+
+			next, err := snapshot(root)
+			if err != nil {
+				log.Printf("error scanning '%v': %v", root, err)
+				continue
+			}
+			if changed(prev, next) {
+				events <- struct{}{}
+			}
+			prev = next
+		}
+	}()
+
+	var once sync.Once
+	return func() error {
+		once.Do(func() { close(done) })
+		return nil
+	}, nil
+}
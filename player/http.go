@@ -13,7 +13,8 @@ import (
 )
 
 // NewHTTPHandler returns an http.Handler which defines a REST API for interacting with
-// Players.
+// Players.  The play queue of each player is at "<key>/queue": GET returns the queued
+// paths, and PUT applies a QueueAction.
 func NewHTTPHandler(p *Players) http.Handler {
 	return &httpHandler{
 		players: p,
@@ -37,7 +38,7 @@ func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	paths := strings.Split(r.URL.Path, "/")
-	if len(paths) != 1 {
+	if len(paths) > 2 || (len(paths) == 2 && paths[1] != "queue") {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
@@ -48,6 +49,11 @@ func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(paths) == 2 {
+		h.queue(h.players.Queue(paths[0]), w, r)
+		return
+	}
+
 	switch r.Method {
 	case "DELETE":
 		h.players.Remove(paths[0])
@@ -113,6 +119,38 @@ func (h *httpHandler) createPlayer(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (h *httpHandler) queue(q *Queue, w http.ResponseWriter, r *http.Request) {
+	if q == nil {
+		http.Error(w, "invalid player key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		h.writeJSON(w, r, q)
+
+	case "PUT":
+		dec := json.NewDecoder(r.Body)
+		defer r.Body.Close()
+
+		var data QueueAction
+		err := dec.Decode(&data)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error parsing JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err = data.Apply(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.writeJSON(w, r, q)
+
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
 func (h *httpHandler) playerAction(p Player, w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	defer r.Body.Close()
@@ -89,3 +89,56 @@ func TestRemovePlayer(t *testing.T) {
 		t.Errorf("len(ps.List()) = %d, expected %d", n, 0)
 	}
 }
+
+func TestPlayerQueue(t *testing.T) {
+	ps := NewPlayers()
+	ps.Add(testPlayer("1"))
+
+	h := NewHTTPHandler(ps)
+
+	actions := []string{
+		`{"action": "add", "path": ["Root", "a"]}`,
+		`{"action": "add", "path": ["Root", "b"]}`,
+		`{"action": "playNext", "path": ["Root", "c"]}`,
+	}
+	for _, a := range actions {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("PUT", "1/queue", strings.NewReader(a))
+		if err != nil {
+			t.Errorf("unexpected error creating request: %v", err)
+		}
+
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("w.Code = %d, expected %d", w.Code, http.StatusOK)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "1/queue", nil)
+	if err != nil {
+		t.Errorf("unexpected error creating request: %v", err)
+	}
+
+	h.ServeHTTP(w, r)
+	expected := `[["Root","c"],["Root","a"],["Root","b"]]`
+	if got := w.Body.String(); got != expected {
+		t.Errorf("w.Body = %#v, expected %#v", got, expected)
+	}
+
+	w = httptest.NewRecorder()
+	r, err = http.NewRequest("PUT", "1/queue", strings.NewReader(`{"action": "shuffle"}`))
+	if err != nil {
+		t.Errorf("unexpected error creating request: %v", err)
+	}
+
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("w.Code = %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+
+	ps.Remove("1")
+	if q := ps.Queue("1"); q != nil {
+		t.Errorf("ps.Queue() = %v, expected nil after removing player", q.Paths())
+	}
+}
@@ -162,15 +162,20 @@ func (v validated) MarshalJSON() ([]byte, error) {
 	return nil, nil
 }
 
-// Players is a collection of players which are identified by key.
+// Players is a collection of players which are identified by key.  Each player has a
+// play Queue.
 type Players struct {
 	sync.RWMutex
-	m map[string]Player
+	m      map[string]Player
+	queues map[string]*Queue
 }
 
 // NewPlayers creates a Players.
 func NewPlayers() *Players {
-	return &Players{m: make(map[string]Player)}
+	return &Players{
+		m:      make(map[string]Player),
+		queues: make(map[string]*Queue),
+	}
 }
 
 // Add the Player to the Players.
@@ -187,6 +192,7 @@ func (s *Players) Remove(key string) {
 	defer s.Unlock()
 
 	delete(s.m, key)
+	delete(s.queues, key)
 }
 
 // Get the Player identified by the key.
@@ -197,6 +203,23 @@ func (s *Players) Get(key string) Player {
 	return s.m[key]
 }
 
+// Queue returns the play Queue of the Player identified by the key, or nil if there is no
+// such Player.
+func (s *Players) Queue(key string) *Queue {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.m[key]; !ok {
+		return nil
+	}
+	q, ok := s.queues[key]
+	if !ok {
+		q = &Queue{}
+		s.queues[key] = q
+	}
+	return q
+}
+
 // List all Player keys in Players.
 func (s *Players) List() []string {
 	s.RLock()
@@ -0,0 +1,115 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package player
+
+import (
+	"encoding/json"
+	"sync"
+
+	"tchaik.com/index"
+)
+
+// Queue is a list of paths (tracks or groups of tracks) to play before a player's cursor
+// advances.
+type Queue struct {
+	sync.Mutex
+	paths []index.Path
+}
+
+// Add appends the paths to the end of the queue.
+func (q *Queue) Add(paths ...index.Path) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.paths = append(q.paths, paths...)
+}
+
+// AddNext adds the paths to the front of the queue, so they are played next.
+func (q *Queue) AddNext(paths ...index.Path) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.paths = append(append([]index.Path{}, paths...), q.paths...)
+}
+
+// Next removes the next track from the queue and returns it.  Returns false if the queue is
+// empty.  Each path is expanded into its tracks using expand, and the tracks which
+// remain are kept at the front of the queue.  Paths which can't be expanded (or have no
+// tracks) are removed.  Expanding the path and updating the queue is done atomically.
+func (q *Queue) Next(expand func(index.Path) ([]index.Path, error)) (index.Path, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	for len(q.paths) > 0 {
+		p := q.paths[0]
+		q.paths = q.paths[1:]
+
+		paths, err := expand(p)
+		if err != nil || len(paths) == 0 {
+			continue
+		}
+		q.paths = append(append([]index.Path{}, paths[1:]...), q.paths...)
+		return paths[0], true
+	}
+	return nil, false
+}
+
+// Clear removes all the paths from the queue.
+func (q *Queue) Clear() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.paths = nil
+}
+
+// Paths returns the paths in the queue, in play order.
+func (q *Queue) Paths() []index.Path {
+	q.Lock()
+	defer q.Unlock()
+
+	paths := make([]index.Path, len(q.paths))
+	copy(paths, q.paths)
+	return paths
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Queue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(q.Paths())
+}
+
+// Queue actions.
+const (
+	QueueAdd      Action = "add"
+	QueuePlayNext        = "playNext"
+	QueueClear           = "clear"
+)
+
+// QueueAction is a representation of a queue action as it would be transmitted.
+type QueueAction struct {
+	Action Action     `json:"action"`
+	Path   index.Path `json:"path,omitempty"`
+}
+
+// Apply applies the action to the Queue.
+func (a QueueAction) Apply(q *Queue) error {
+	switch a.Action {
+	case QueueAdd, QueuePlayNext:
+		if len(a.Path) == 0 {
+			return InvalidValueError("path required")
+		}
+		if a.Action == QueueAdd {
+			q.Add(a.Path)
+			return nil
+		}
+		q.AddNext(a.Path)
+
+	case QueueClear:
+		q.Clear()
+
+	default:
+		return InvalidActionError(a.Action)
+	}
+	return nil
+}
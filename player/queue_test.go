@@ -0,0 +1,50 @@
+package player
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"tchaik.com/index"
+)
+
+func TestQueueNext(t *testing.T) {
+	q := &Queue{}
+	q.Add(index.Path{"Root", "album"}, index.Path{"Root", "invalid"}, index.Path{"Root", "empty"}, index.Path{"Root", "track"})
+
+	expand := func(p index.Path) ([]index.Path, error) {
+		if len(p) > 2 {
+			return []index.Path{p}, nil
+		}
+		switch p[1] {
+		case "album":
+			return []index.Path{{"Root", "album", "0"}, {"Root", "album", "1"}}, nil
+		case "invalid":
+			return nil, fmt.Errorf("invalid path")
+		case "empty":
+			return nil, nil
+		}
+		return []index.Path{p}, nil
+	}
+
+	expected := []index.Path{
+		{"Root", "album", "0"},
+		{"Root", "album", "1"},
+		{"Root", "track"},
+	}
+	for i, x := range expected {
+		p, ok := q.Next(expand)
+		if !ok || !reflect.DeepEqual(p, x) {
+			t.Errorf("[%d] Next() = (%#v, %v), expected: (%#v, true)", i, p, ok, x)
+		}
+		if i == 0 {
+			if got := q.Paths(); !reflect.DeepEqual(got, []index.Path{{"Root", "album", "1"}, {"Root", "invalid"}, {"Root", "empty"}, {"Root", "track"}}) {
+				t.Errorf("Paths() = %#v, expected remaining album tracks at the front", got)
+			}
+		}
+	}
+
+	if p, ok := q.Next(expand); ok {
+		t.Errorf("Next() = (%#v, %v), expected: (nil, false)", p, ok)
+	}
+}
@@ -24,6 +24,7 @@ import (
 var localStore, remoteStore string
 var mediaFileSystemCache, artworkFileSystemCache string
 var trimPathPrefix, addPathPrefix string
+var transcoder string
 
 func init() {
 	flag.StringVar(&localStore, "local-store", "/", "`path` to local media store (prefixes all paths)")
@@ -32,12 +33,15 @@ func init() {
 	flag.StringVar(&artworkFileSystemCache, "artwork-cache", "", "`path` to local artwork cache (content addressable)")
 	flag.StringVar(&mediaFileSystemCache, "media-cache", "", "`path` to local media cache")
 
+	flag.StringVar(&transcoder, "transcoder", "", "`path` to ffmpeg, used to transcode media files (transcoded files are cached in -media-cache)")
+
 	flag.StringVar(&trimPathPrefix, "trim-path-prefix", "", "remove `prefix` from every path")
 	flag.StringVar(&addPathPrefix, "add-path-prefix", "", "add `prefix` to every path")
 }
 
 type stores struct {
 	media, artwork store.FileSystem
+	mediaCache     store.RWFileSystem
 }
 
 func buildRemoteStore(s *stores) (err error) {
@@ -122,8 +126,8 @@ func buildLocalStore(s *stores) {
 func buildMediaCache(s *stores) {
 	if mediaFileSystemCache != "" {
 		var errCh <-chan error
-		localCache := store.Dir(mediaFileSystemCache)
-		s.media, errCh = store.NewCachedFileSystem(s.media, localCache)
+		s.mediaCache = store.Dir(mediaFileSystemCache)
+		s.media, errCh = store.NewCachedFileSystem(s.media, s.mediaCache)
 		go func() {
 			for err := range errCh {
 				// TODO: pull this out!
@@ -155,6 +159,12 @@ func buildArtworkCache(s *stores) error {
 	return nil
 }
 
+func buildTranscoder(s *stores) {
+	if transcoder != "" {
+		s.media = store.TranscodeFileSystem(s.media, store.FFmpeg(transcoder), s.mediaCache)
+	}
+}
+
 // Stores returns a media and artwork filesystem as defined by the command line flags.
 func Stores() (media, artwork store.FileSystem, err error) {
 	s := &stores{}
@@ -165,6 +175,7 @@ func Stores() (media, artwork store.FileSystem, err error) {
 
 	buildLocalStore(s)
 	buildMediaCache(s)
+	buildTranscoder(s)
 
 	err = buildArtworkCache(s)
 	if err != nil {
@@ -0,0 +1,219 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// audioFrame is a frame in an MP3 or ADTS (AAC) stream.
+type audioFrame struct {
+	offset   int64
+	size     int64
+	duration time.Duration
+}
+
+var mp3BitRates = [2][15]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}, // MPEG-1
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},     // MPEG-2, 2.5
+}
+
+var mp3SampleRates = [3]int{44100, 48000, 32000}
+
+var adtsSampleRates = [13]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// mp3Frame returns the size and number of samples of the MPEG audio (Layer III) frame with
+// header h.  Returns false if h isn't a valid frame header.
+func mp3Frame(h []byte) (size int64, samples, rate int, ok bool) {
+	if h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return
+	}
+	version := (h[1] >> 3) & 0x03 // 0: MPEG-2.5, 2: MPEG-2, 3: MPEG-1
+	layer := (h[1] >> 1) & 0x03   // 1: Layer III
+	bitRateIndex := h[2] >> 4
+	rateIndex := (h[2] >> 2) & 0x03
+	padding := int64((h[2] >> 1) & 0x01)
+	if version == 1 || layer != 1 || bitRateIndex == 0 || bitRateIndex == 15 || rateIndex == 3 {
+		return
+	}
+
+	rate = mp3SampleRates[rateIndex]
+	switch version {
+	case 3:
+		samples = 1152
+		size = int64(144*mp3BitRates[0][bitRateIndex]*1000/rate) + padding
+		return size, samples, rate, true
+	case 2:
+		rate /= 2
+	case 0:
+		rate /= 4
+	}
+	samples = 576
+	size = int64(72*mp3BitRates[1][bitRateIndex]*1000/rate) + padding
+	return size, samples, rate, true
+}
+
+// adtsFrame returns the size and number of samples of the ADTS frame with header h.  Returns
+// false if h isn't a valid frame header.
+func adtsFrame(h []byte) (size int64, samples, rate int, ok bool) {
+	if h[0] != 0xFF || h[1]&0xF6 != 0xF0 {
+		return
+	}
+	rateIndex := (h[2] >> 2) & 0x0F
+	if int(rateIndex) >= len(adtsSampleRates) {
+		return
+	}
+	size = int64(h[3]&0x03)<<11 | int64(h[4])<<3 | int64(h[5]>>5)
+	if size < 7 {
+		return
+	}
+	return size, 1024 * (int(h[6]&0x03) + 1), adtsSampleRates[rateIndex], true
+}
+
+// id3Size returns the size of the ID3v2 tag with header h, or 0 if h isn't an ID3v2 tag
+// header.
+func id3Size(h []byte) int64 {
+	if string(h[:3]) != "ID3" {
+		return 0
+	}
+	size := int64(h[6]&0x7F)<<21 | int64(h[7]&0x7F)<<14 | int64(h[8]&0x7F)<<7 | int64(h[9]&0x7F)
+	if h[5]&0x10 != 0 { // footer
+		size += 10
+	}
+	return size + 10
+}
+
+// maxFrameSearch is the number of bytes (after any leading ID3v2 tags) searched for the
+// first frame of a stream before giving up.
+const maxFrameSearch = 64 * 1024
+
+// errNoFrames is returned when a stream doesn't contain MP3 or ADTS frames.
+var errNoFrames = fmt.Errorf("no MP3 or AAC (ADTS) frames found")
+
+// frameScanner reads the frames of an MP3 or ADTS (AAC) stream.
+type frameScanner struct {
+	r      *bufio.Reader
+	offset int64
+	start  int64 // offset of the data after leading ID3v2 tags
+	format TranscodeFormat
+}
+
+// newFrameScanner returns a frameScanner which reads frames from r.
+func newFrameScanner(r io.Reader) *frameScanner {
+	return &frameScanner{
+		r: bufio.NewReaderSize(r, 16*1024),
+	}
+}
+
+// frameHeader returns the size, number of samples, sample rate and format of the frame with
+// header h.  Returns false if h isn't a valid MP3 or ADTS frame header.
+func frameHeader(h []byte) (size int64, samples, rate int, f TranscodeFormat, ok bool) {
+	if size, samples, rate, ok = adtsFrame(h); ok {
+		return size, samples, rate, AAC, true
+	}
+	size, samples, rate, ok = mp3Frame(h)
+	return size, samples, rate, MP3, ok
+}
+
+// next returns the next frame in the stream, or io.EOF when there are no more frames.
+// Leading ID3v2 tags and any data which isn't a frame (i.e. trailing tags) are skipped.  The
+// format of the stream is set by the first frame, which must be followed by another frame of
+// the same format (or the end of the stream) so that other data isn't mistaken for audio.
+func (s *frameScanner) next() (audioFrame, error) {
+	for {
+		if s.format == "" && s.offset-s.start > maxFrameSearch {
+			return audioFrame{}, errNoFrames
+		}
+
+		h, err := s.r.Peek(10)
+		if err != nil {
+			if err == io.EOF && s.format == "" {
+				err = errNoFrames
+			}
+			return audioFrame{}, err
+		}
+
+		if s.format == "" {
+			if n := id3Size(h); n > 0 {
+				if err := s.discard(n); err != nil {
+					if err == io.EOF {
+						err = errNoFrames
+					}
+					return audioFrame{}, err
+				}
+				s.start = s.offset
+				continue
+			}
+		}
+
+		n, samples, rate, f, ok := frameHeader(h)
+		if ok && s.valid(n, f) {
+			frame := audioFrame{
+				offset:   s.offset,
+				size:     n,
+				duration: time.Duration(samples) * time.Second / time.Duration(rate),
+			}
+			s.format = f
+			if err := s.discard(n); err != nil {
+				return audioFrame{}, err
+			}
+			return frame, nil
+		}
+		if err := s.discard(1); err != nil {
+			return audioFrame{}, err
+		}
+	}
+}
+
+// valid returns true if the stream contains a complete frame of size n and format f at the
+// current offset.
+func (s *frameScanner) valid(n int64, f TranscodeFormat) bool {
+	if s.format != "" {
+		if f != s.format {
+			return false
+		}
+		b, _ := s.r.Peek(int(n))
+		return int64(len(b)) == n
+	}
+
+	b, _ := s.r.Peek(int(n) + 10)
+	if int64(len(b)) < n {
+		return false
+	}
+	if int64(len(b)) < n+10 {
+		return true // last frame in the stream
+	}
+	_, _, _, g, ok := frameHeader(b[n:])
+	return ok && g == f
+}
+
+// discard skips n bytes of the stream.
+func (s *frameScanner) discard(n int64) error {
+	m, err := s.r.Discard(int(n))
+	s.offset += int64(m)
+	return err
+}
+
+// audioFrames returns the frames in the MP3 or ADTS stream, and the format of the stream
+// (see frameScanner).
+func audioFrames(r io.ReaderAt, size int64) ([]audioFrame, TranscodeFormat, error) {
+	s := newFrameScanner(io.NewSectionReader(r, 0, size))
+
+	var frames []audioFrame
+	for {
+		f, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		frames = append(frames, f)
+	}
+	return frames, s.format, nil
+}
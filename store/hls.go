@@ -0,0 +1,474 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/trace"
+)
+
+// DefaultSegmentDuration is the default (target) duration of HLS segments.
+const DefaultSegmentDuration = 4 * time.Second
+
+// hlsCacheSize is the number of segmented tracks (and their open files) kept by the handler.
+const hlsCacheSize = 16
+
+// hlsPlaylist is the name of the playlist (manifest) for each track.
+const hlsPlaylist = "index.m3u8"
+
+var hlsContentTypes = map[TranscodeFormat]string{
+	AAC: "audio/aac",
+	MP3: "audio/mpeg",
+}
+
+// NewHLSHandler returns an http.Handler which serves media files from the FileSystem using
+// HTTP Live Streaming.  Files are split into segments of (roughly) duration d (zero for
+// DefaultSegmentDuration).  Paths are relative to the handler:
+//
+//  GET "<path>/index.m3u8"  the playlist for the file at <path>
+//  GET "<path>/<n>.<ext>"   segment n of the file at <path>
+//
+// Media files which are already MP3 or AAC (ADTS) are segmented as they are, and other files
+// are transcoded into AAC (when fs is a FileSystem created by TranscodeFileSystem).  The
+// "format" (mp3 or aac) and "bitrate" query parameters set the transcode options instead (see
+// TranscodeOptionsFromQuery), and are passed on to the segment URLs in the playlist.
+//
+// Files are segmented as they are read (or transcoded): the playlist is an EVENT playlist
+// which lists the segments available so far, and is ended once the whole file has been read.
+// Only the segment offsets are kept in memory: segment data is read from the file when it is
+// requested, and the files of the last hlsCacheSize (16) tracks are kept open.
+func NewHLSHandler(fs FileSystem, d time.Duration) http.Handler {
+	if d == 0 {
+		d = DefaultSegmentDuration
+	}
+	return &hlsHandler{
+		fs:     fs,
+		d:      d,
+		tracks: make(map[string]*hlsTrack),
+	}
+}
+
+type hlsHandler struct {
+	fs FileSystem
+	d  time.Duration
+
+	sync.Mutex // protects tracks and keys
+	tracks     map[string]*hlsTrack
+	keys       []string // keys of tracks, oldest first
+}
+
+// hlsSegment is a segment of a track.
+type hlsSegment struct {
+	offset, size int64
+	start        time.Duration
+	duration     time.Duration
+}
+
+// hlsTrack is a track split into segments.  Segments are added as the file is read.
+type hlsTrack struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	f        http.File
+	data     io.ReaderAt
+	format   TranscodeFormat
+	segments []hlsSegment
+	done     bool
+	err      error
+	closed   bool
+}
+
+func newHLSTrack() *hlsTrack {
+	t := &hlsTrack{}
+	t.cond = sync.NewCond(&t.Mutex)
+	return t
+}
+
+// load reads the file at path and splits it into segments of duration (roughly) d.  If o is
+// nil then the file is segmented as it is (if it is MP3 or AAC), or otherwise transcoded into
+// AAC.
+func (t *hlsTrack) load(ctx context.Context, fs FileSystem, path string, o *TranscodeOptions, d time.Duration) {
+	var s *frameScanner
+	var first audioFrame
+	var err error
+	if o != nil {
+		s, first, err = t.open(NewTranscodeContext(ctx, *o), fs, path, o.Format)
+	} else {
+		s, first, err = t.open(ctx, fs, path, "")
+		if err == errNoFrames {
+			aac := TranscodeOptions{Format: AAC}
+			aac.validate()
+			s, first, err = t.open(NewTranscodeContext(ctx, aac), fs, path, AAC)
+		}
+	}
+	if err != nil {
+		t.finish(nil, fmt.Errorf("error reading '%v': %v", path, err))
+		return
+	}
+
+	var seg hlsSegment
+	var start time.Duration
+	for f := first; ; {
+		if seg.duration == 0 {
+			seg = hlsSegment{offset: f.offset, start: start}
+		}
+		seg.size = f.offset + f.size - seg.offset
+		seg.duration += f.duration
+		start += f.duration
+		if seg.duration >= d {
+			t.add(seg)
+			seg = hlsSegment{}
+		}
+
+		f, err = s.next()
+		if err != nil {
+			break
+		}
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("error reading '%v': %v", path, err)
+	}
+	var last []hlsSegment
+	if seg.duration > 0 {
+		last = append(last, seg)
+	}
+	t.finish(last, err)
+}
+
+// open opens the file at path and reads its first frame, checking that it has the format f
+// (if set).  The file is kept open to read segment data.
+func (t *hlsTrack) open(ctx context.Context, fs FileSystem, path string, f TranscodeFormat) (*frameScanner, audioFrame, error) {
+	file, err := fs.Open(ctx, path)
+	if err != nil {
+		return nil, audioFrame{}, err
+	}
+
+	t.Lock()
+	if t.f != nil {
+		t.f.Close()
+	}
+	if t.closed {
+		t.Unlock()
+		file.Close()
+		return nil, audioFrame{}, fmt.Errorf("track closed")
+	}
+	sr := newSegmentReader(file)
+	t.f = file
+	t.data = sr
+	t.Unlock()
+
+	s := newFrameScanner(sr)
+	first, err := s.next()
+	if err != nil {
+		return nil, audioFrame{}, err
+	}
+	if f != "" && s.format != f {
+		return nil, audioFrame{}, fmt.Errorf("cannot stream as %v (no transcoder?)", f)
+	}
+
+	t.Lock()
+	t.format = s.format
+	t.Unlock()
+	return s, first, nil
+}
+
+// add adds a segment to the track.
+func (t *hlsTrack) add(s hlsSegment) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.segments = append(t.segments, s)
+	t.cond.Broadcast()
+}
+
+// finish adds the last segments of the track, and marks it as complete with the error err.
+func (t *hlsTrack) finish(last []hlsSegment, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.segments = append(t.segments, last...)
+	t.done = true
+	t.err = err
+	t.cond.Broadcast()
+}
+
+// wait blocks until the track has more than n segments, or has been completely read.
+// Returns an error if the track couldn't be read.
+func (t *hlsTrack) wait(n int) error {
+	t.Lock()
+	defer t.Unlock()
+
+	for !t.done && len(t.segments) <= n {
+		t.cond.Wait()
+	}
+	if t.err != nil && len(t.segments) <= n {
+		return t.err
+	}
+	return nil
+}
+
+// close closes the file of the track.  Reading of the file (if not complete) will fail.
+func (t *hlsTrack) close() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.closed = true
+	if t.f != nil {
+		t.f.Close()
+	}
+}
+
+// segmentReader is a file which is read sequentially (to find frames) and at offsets (to
+// read segments).
+type segmentReader interface {
+	io.Reader
+	io.ReaderAt
+}
+
+// newSegmentReader returns a segmentReader which reads from the file.
+func newSegmentReader(f http.File) segmentReader {
+	if sr, ok := f.(segmentReader); ok {
+		return sr
+	}
+	return &seekReader{f: f}
+}
+
+// seekReader is a segmentReader which seeks the underlying file for each read.
+type seekReader struct {
+	sync.Mutex
+	f      io.ReadSeeker
+	offset int64 // of sequential reads
+}
+
+// Read implements io.Reader.
+func (s *seekReader) Read(b []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, err := s.f.Seek(s.offset, 0); err != nil {
+		return 0, err
+	}
+	n, err := s.f.Read(b)
+	s.offset += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (s *seekReader) ReadAt(b []byte, off int64) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, err := s.f.Seek(off, 0); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.f, b)
+}
+
+// track returns the segmented track for the path and transcode options (nil for the format of
+// the file), waiting until it has at least one segment.
+func (h *hlsHandler) track(path string, o *TranscodeOptions) (*hlsTrack, error) {
+	key := path
+	if o != nil {
+		key += o.suffix()
+	}
+
+	h.Lock()
+	t, ok := h.tracks[key]
+	if !ok {
+		t = newHLSTrack()
+		h.tracks[key] = t
+		h.keys = append(h.keys, key)
+		if len(h.keys) > hlsCacheSize {
+			h.tracks[h.keys[0]].close()
+			delete(h.tracks, h.keys[0])
+			h.keys = h.keys[1:]
+		}
+
+		go func() {
+			tr := trace.New("hls", path)
+			defer tr.Finish()
+
+			t.load(trace.NewContext(context.Background(), tr), h.fs, path, o, h.d)
+			if t.err != nil {
+				tr.LazyPrintf("error: %v", t.err)
+				tr.SetError()
+			}
+		}()
+	}
+	h.Unlock()
+
+	if err := t.wait(0); err != nil {
+		h.Lock()
+		if h.tracks[key] == t {
+			delete(h.tracks, key) // try again next time
+			for i, k := range h.keys {
+				if k == key {
+					h.keys = append(h.keys[:i], h.keys[i+1:]...)
+					break
+				}
+			}
+		}
+		h.Unlock()
+		t.close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *hlsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	i := strings.LastIndex(r.URL.Path, "/")
+	if i == -1 {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	path, name := r.URL.Path[:i], r.URL.Path[i+1:]
+
+	q := r.URL.Query()
+	o, ok, err := TranscodeOptionsFromQuery(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var po *TranscodeOptions
+	if ok {
+		if _, ok := hlsContentTypes[o.Format]; !ok {
+			http.Error(w, fmt.Sprintf("invalid HLS format: %v", o.Format), http.StatusBadRequest)
+			return
+		}
+		po = &o
+	}
+
+	t, err := h.track(path, po)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if name == hlsPlaylist {
+		var query string
+		if len(q) > 0 {
+			query = "?" + q.Encode()
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		_, err = w.Write(t.playlist(query, h.d))
+		if err != nil {
+			log.Printf("error writing response: %v", err)
+		}
+		return
+	}
+
+	ext := "." + string(t.format)
+	n, err := strconv.Atoi(strings.TrimSuffix(name, ext))
+	if err != nil || !strings.HasSuffix(name, ext) || n < 0 {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	s, data, ok := t.segment(n)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	buf := bytes.NewBuffer(id3Timestamp(s.start))
+	_, err = io.Copy(buf, io.NewSectionReader(data, s.offset, s.size))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading segment: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", hlsContentTypes[t.format])
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+// segment waits for segment n of the track, and returns it along with the data of the track.
+// Returns false if the track doesn't have segment n.
+func (t *hlsTrack) segment(n int) (hlsSegment, io.ReaderAt, bool) {
+	t.wait(n)
+
+	t.Lock()
+	defer t.Unlock()
+	if n >= len(t.segments) {
+		return hlsSegment{}, nil, false
+	}
+	return t.segments[n], t.data, true
+}
+
+// playlist returns the HLS playlist of the segments of the track read so far, where query is
+// added to the segment URLs.  Segments are of (roughly) duration d.
+func (t *hlsTrack) playlist(query string, d time.Duration) []byte {
+	t.Lock()
+	defer t.Unlock()
+
+	// Segments are at most one frame longer than d (and the last may be shorter).
+	target := int(d/time.Second) + 1
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "#EXTM3U")
+	fmt.Fprintln(buf, "#EXT-X-VERSION:3")
+	fmt.Fprintf(buf, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintln(buf, "#EXT-X-MEDIA-SEQUENCE:0")
+	fmt.Fprintln(buf, "#EXT-X-PLAYLIST-TYPE:EVENT")
+	for i, s := range t.segments {
+		fmt.Fprintf(buf, "#EXTINF:%.3f,\n", s.duration.Seconds())
+		fmt.Fprintf(buf, "%d.%v%v\n", i, t.format, query)
+	}
+	if t.done {
+		fmt.Fprintln(buf, "#EXT-X-ENDLIST")
+	}
+	return buf.Bytes()
+}
+
+// hlsTimestampOwner is the owner of the ID3 PRIV frame which gives the timestamp of a
+// packed audio segment.
+const hlsTimestampOwner = "com.apple.streaming.transportStreamTimestamp"
+
+// id3Timestamp returns an ID3v2.4 tag containing the timestamp of a packed audio segment
+// which starts at t, as required by the HLS specification.
+func id3Timestamp(t time.Duration) []byte {
+	frame := &bytes.Buffer{}
+	frame.WriteString(hlsTimestampOwner)
+	frame.WriteByte(0)
+	binary.Write(frame, binary.BigEndian, uint64(t*90000/time.Second)&(1<<33-1)) // 90kHz MPEG-2 clock
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("ID3")
+	buf.Write([]byte{4, 0, 0}) // version 2.4, no flags
+	buf.Write(syncSafe(10 + frame.Len()))
+	buf.WriteString("PRIV")
+	buf.Write(syncSafe(frame.Len()))
+	buf.Write([]byte{0, 0}) // no flags
+	frame.WriteTo(buf)
+	return buf.Bytes()
+}
+
+// syncSafe returns n as an ID3v2 sync-safe integer.
+func syncSafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7F, byte(n>>14) & 0x7F, byte(n>>7) & 0x7F, byte(n) & 0x7F}
+}
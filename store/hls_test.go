@@ -0,0 +1,213 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// adtsStream returns an ADTS stream of n frames (44.1kHz, 1024 samples, 100 bytes each)
+// preceded by an ID3v2 tag.
+func adtsStream(n int) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("ID3")
+	buf.Write([]byte{4, 0, 0, 0, 0, 0, 5})
+	buf.WriteString("abcde")
+
+	const size = 100
+	for i := 0; i < n; i++ {
+		h := []byte{0xFF, 0xF1, 0x50, 0x80, byte(size >> 3), byte(size&0x07)<<5 | 0x1F, 0xFC}
+		buf.Write(h)
+		buf.Write(make([]byte, size-len(h)))
+	}
+	return buf.Bytes()
+}
+
+func TestAudioFrames(t *testing.T) {
+	data := adtsStream(3)
+	frames, format, err := audioFrames(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error from audioFrames: %v", err)
+	}
+	if format != AAC {
+		t.Errorf("audioFrames() format = %v, expected: %v", format, AAC)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("len(audioFrames()) = %d, expected: %d", len(frames), 3)
+	}
+	expected := audioFrame{offset: 115, size: 100, duration: 1024 * time.Second / 44100}
+	if frames[1] != expected {
+		t.Errorf("audioFrames()[1] = %#v, expected: %#v", frames[1], expected)
+	}
+
+	// MPEG-1 Layer III, 128kbit/s, 44.1kHz: 417 bytes.
+	mp3 := make([]byte, 417)
+	copy(mp3, []byte{0xFF, 0xFB, 0x90, 0x00})
+	frames, format, err = audioFrames(bytes.NewReader(mp3), int64(len(mp3)))
+	if err != nil {
+		t.Fatalf("unexpected error from audioFrames: %v", err)
+	}
+	if format != MP3 || len(frames) != 1 || frames[0].size != 417 {
+		t.Errorf("audioFrames() = (%#v, %v), expected one MP3 frame of 417 bytes", frames, format)
+	}
+
+	_, _, err = audioFrames(strings.NewReader("not audio data"), 14)
+	if err == nil {
+		t.Errorf("expected error for invalid audio data")
+	}
+}
+
+func TestHLSHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hls")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 100 frames is roughly 2.3s.
+	err = ioutil.WriteFile(filepath.Join(dir, "track.aac"), adtsStream(100), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	h := NewHLSHandler(NewFileSystem(http.Dir(dir), "test"), time.Second)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Requests for segments past the end wait until the whole track has been read.
+	if w := get("track.aac/3.aac"); w.Code != http.StatusNotFound {
+		t.Errorf("w.Code = %d, expected %d", w.Code, http.StatusNotFound)
+	}
+
+	w := get("track.aac/index.m3u8")
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code = %d, expected %d: %v", w.Code, http.StatusOK, w.Body.String())
+	}
+	playlist := w.Body.String()
+	for _, x := range []string{"#EXT-X-TARGETDURATION:2\n", "#EXTINF:1.022,\n0.aac\n", "2.aac\n#EXT-X-ENDLIST\n"} {
+		if !strings.Contains(playlist, x) {
+			t.Errorf("playlist = %v, expected to contain: %#v", playlist, x)
+		}
+	}
+
+	w = get("track.aac/1.aac")
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code = %d, expected %d: %v", w.Code, http.StatusOK, w.Body.String())
+	}
+	// ID3 timestamp (73 bytes) followed by 44 frames.
+	if n := w.Body.Len(); n != 73+44*100 {
+		t.Errorf("len(segment) = %d, expected: %d", n, 73+44*100)
+	}
+	if !bytes.HasPrefix(w.Body.Bytes(), []byte("ID3")) {
+		t.Errorf("expected segment to start with ID3 timestamp")
+	}
+
+	for _, path := range []string{"track.aac/1.mp3", "missing.aac/index.m3u8"} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Errorf("[%v] w.Code = %d, expected %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+	if w := get("track.aac/index.m3u8?format=mp3"); w.Code != http.StatusNotFound {
+		t.Errorf("w.Code = %d, expected %d for MP3 stream without transcoder", w.Code, http.StatusNotFound)
+	}
+	if w := get("track.aac/index.m3u8?format=opus"); w.Code != http.StatusBadRequest {
+		t.Errorf("w.Code = %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// adtsTranscoder is a Transcoder which writes an ADTS stream of n frames, blocking after the
+// first half until release is closed.
+type adtsTranscoder struct {
+	n       int
+	release chan struct{}
+}
+
+func (a *adtsTranscoder) Transcode(ctx context.Context, w io.Writer, r io.Reader, o TranscodeOptions) error {
+	data := adtsStream(a.n)
+	if _, err := w.Write(data[:len(data)/2]); err != nil {
+		return err
+	}
+	select {
+	case <-a.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	_, err := w.Write(data[len(data)/2:])
+	return err
+}
+
+func TestHLSHandlerTranscode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hls")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "track.flac"), []byte("fLaC not audio frames"), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	tr := &adtsTranscoder{n: 100, release: make(chan struct{})}
+	fs := TranscodeFileSystem(NewFileSystem(http.Dir(dir), "test"), tr, nil)
+	h := NewHLSHandler(fs, time.Second)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// The playlist is served before the transcode has completed.
+	w := get("track.flac/index.m3u8")
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code = %d, expected %d: %v", w.Code, http.StatusOK, w.Body.String())
+	}
+	playlist := w.Body.String()
+	if !strings.Contains(playlist, "0.aac\n") || strings.Contains(playlist, "#EXT-X-ENDLIST") {
+		t.Errorf("playlist = %v, expected first segment and no end", playlist)
+	}
+
+	close(tr.release)
+	if w := get("track.flac/2.aac"); w.Code != http.StatusOK {
+		t.Errorf("w.Code = %d, expected %d: %v", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w := get("track.flac/3.aac"); w.Code != http.StatusNotFound {
+		t.Errorf("w.Code = %d, expected %d", w.Code, http.StatusNotFound)
+	}
+	playlist = get("track.flac/index.m3u8").Body.String()
+	if !strings.Contains(playlist, "2.aac\n#EXT-X-ENDLIST\n") {
+		t.Errorf("playlist = %v, expected to contain all segments", playlist)
+	}
+
+	// Without a transcoder, files which aren't MP3 or AAC can't be streamed.
+	h = NewHLSHandler(NewFileSystem(http.Dir(dir), "test"), time.Second)
+	if w := get("track.flac/index.m3u8"); w.Code != http.StatusNotFound {
+		t.Errorf("w.Code = %d, expected %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,597 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/trace"
+)
+
+// TranscodeFormat is an audio format which media files can be transcoded into.
+type TranscodeFormat string
+
+// Transcode formats.
+const (
+	Opus TranscodeFormat = "opus"
+	MP3  TranscodeFormat = "mp3"
+	AAC  TranscodeFormat = "aac"
+)
+
+// transcodeFormats maps the transcode formats to their default bit rate (in kbit/s).
+var transcodeFormats = map[TranscodeFormat]int{
+	Opus: 96,
+	MP3:  192,
+	AAC:  128,
+}
+
+// Limits for transcode bit rates (in kbit/s).
+const (
+	MinTranscodeBitRate = 16
+	MaxTranscodeBitRate = 320
+)
+
+// TranscodeOptions are the options for transcoding a media file.
+type TranscodeOptions struct {
+	Format  TranscodeFormat
+	BitRate int // kbit/s
+}
+
+// TranscodeOptionsFromQuery returns the TranscodeOptions given by the "format" and "bitrate"
+// (kbit/s) query parameters.  Returns false if no format is given.  If no bit rate is given
+// then the default for the format is used.
+func TranscodeOptionsFromQuery(q url.Values) (TranscodeOptions, bool, error) {
+	f := q.Get("format")
+	if f == "" {
+		return TranscodeOptions{}, false, nil
+	}
+
+	o := TranscodeOptions{
+		Format: TranscodeFormat(strings.ToLower(f)),
+	}
+	if b := q.Get("bitrate"); b != "" {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(b), "k"))
+		if err != nil {
+			return TranscodeOptions{}, false, fmt.Errorf("invalid bitrate: %v", b)
+		}
+		o.BitRate = n
+	}
+
+	if err := o.validate(); err != nil {
+		return TranscodeOptions{}, false, err
+	}
+	return o, true, nil
+}
+
+// validate checks the options, and sets the default bit rate if none is set.
+func (o *TranscodeOptions) validate() error {
+	def, ok := transcodeFormats[o.Format]
+	if !ok {
+		return fmt.Errorf("invalid transcode format: %v", o.Format)
+	}
+	if o.BitRate == 0 {
+		o.BitRate = def
+	}
+	if o.BitRate < MinTranscodeBitRate || o.BitRate > MaxTranscodeBitRate {
+		return fmt.Errorf("invalid bitrate %dk: must be between %dk and %dk", o.BitRate, MinTranscodeBitRate, MaxTranscodeBitRate)
+	}
+	return nil
+}
+
+// transcodeContentTypes are the MIME types of each of the transcode formats.
+var transcodeContentTypes = map[TranscodeFormat]string{
+	Opus: "audio/ogg",
+	MP3:  "audio/mpeg",
+	AAC:  "audio/aac",
+}
+
+// ContentType returns the MIME type of files transcoded using the options.
+func (o TranscodeOptions) ContentType() string {
+	return transcodeContentTypes[o.Format]
+}
+
+// suffix returns the suffix added to the path of transcoded files.
+func (o TranscodeOptions) suffix() string {
+	return fmt.Sprintf(".%dk.%v", o.BitRate, o.Format)
+}
+
+type transcodeKey int
+
+const transcodeOptionsKey transcodeKey = 0
+
+// NewTranscodeContext returns a new context.Context which carries the TranscodeOptions.  Files
+// opened from a FileSystem created by TranscodeFileSystem with this context are transcoded.
+func NewTranscodeContext(ctx context.Context, o TranscodeOptions) context.Context {
+	return context.WithValue(ctx, transcodeOptionsKey, o)
+}
+
+// TranscodeFromContext returns the TranscodeOptions in the context, if any.
+func TranscodeFromContext(ctx context.Context) (TranscodeOptions, bool) {
+	o, ok := ctx.Value(transcodeOptionsKey).(TranscodeOptions)
+	return o, ok
+}
+
+// Transcoder is an interface which defines the Transcode method.
+type Transcoder interface {
+	// Transcode reads a media file from r and writes it to w in the format given by the
+	// options.
+	Transcode(ctx context.Context, w io.Writer, r io.Reader, o TranscodeOptions) error
+}
+
+// FFmpeg returns a Transcoder which uses the ffmpeg command at the given path.
+func FFmpeg(path string) Transcoder {
+	return ffmpeg(path)
+}
+
+type ffmpeg string
+
+// ffmpegArgs are the codec and container arguments for each format.
+var ffmpegArgs = map[TranscodeFormat][]string{
+	Opus: {"-c:a", "libopus", "-f", "ogg"},
+	MP3:  {"-c:a", "libmp3lame", "-f", "mp3"},
+	AAC:  {"-c:a", "aac", "-f", "adts"},
+}
+
+// Transcode implements Transcoder.  The ffmpeg process is killed if ctx is cancelled.
+func (f ffmpeg) Transcode(ctx context.Context, w io.Writer, r io.Reader, o TranscodeOptions) error {
+	in, cleanup, err := seekableInput(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	args := []string{"-v", "error", "-i", "file:" + in, "-vn", "-b:a", fmt.Sprintf("%dk", o.BitRate)}
+	args = append(args, ffmpegArgs[o.Format]...)
+	args = append(args, "pipe:1")
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, string(f), args...)
+	cmd.Stdout = w
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running %v: %v: %v", f, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// seekableInput returns the path of a file containing the data read from r, so that ffmpeg
+// can seek within it (some inputs can't be read from a pipe, i.e. MP4 files with the "moov"
+// atom after the media data).  Local files are used directly, anything else is copied to a
+// temporary file which is removed by calling cleanup.
+func seekableInput(r io.Reader) (path string, cleanup func(), err error) {
+	if f, ok := r.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			return f.Name(), func() {}, nil
+		}
+	}
+
+	f, err := ioutil.TempFile("", "tchaik-input")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// TranscodeFileSystem wraps a FileSystem so that files opened with a context created by
+// NewTranscodeContext are transcoded using t.  Transcoded files are cached in cache (which
+// can be nil), with the bit rate and format added to the path (i.e. "file.flac.96k.opus").
+// If t is nil then the original files are returned.
+//
+// Files are transcoded into a temporary file and can be read while they are being written:
+// Open returns as soon as the first data is available (see StreamingFile).  Concurrent
+// requests for the same file and options share one transcode, which is cancelled if all
+// the files reading it are closed before it completes.
+func TranscodeFileSystem(fs FileSystem, t Transcoder, cache RWFileSystem) FileSystem {
+	return &transcodeFileSystem{
+		FileSystem: fs,
+		t:          t,
+		cache:      cache,
+		transcodes: make(map[string]*transcode),
+	}
+}
+
+type transcodeFileSystem struct {
+	FileSystem
+
+	t     Transcoder
+	cache RWFileSystem
+
+	sync.Mutex // protects transcodes
+	transcodes map[string]*transcode
+}
+
+// Open implements FileSystem.
+func (tfs *transcodeFileSystem) Open(ctx context.Context, path string) (http.File, error) {
+	o, ok := TranscodeFromContext(ctx)
+	if !ok || tfs.t == nil {
+		return tfs.FileSystem.Open(ctx, path)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	cachePath := path + o.suffix()
+	if tfs.cache != nil {
+		if f, err := tfs.cache.Open(ctx, cachePath); err == nil {
+			return f, nil
+		}
+	}
+
+	tfs.Lock()
+	t, ok := tfs.transcodes[cachePath]
+	if !ok || !t.open() {
+		if tr, ok := trace.FromContext(ctx); ok {
+			tr.LazyPrintf("transcode: %v (%v, %dk)", path, o.Format, o.BitRate)
+		}
+		t = newTranscode()
+		tfs.transcodes[cachePath] = t
+		go tfs.transcode(t, path, cachePath, o)
+		t.open()
+	}
+	tfs.Unlock()
+
+	f := &transcodeFile{
+		t:       t,
+		name:    path[strings.LastIndex(path, "/")+1:] + o.suffix(),
+		modTime: t.started,
+	}
+	if err := t.wait(1); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error transcoding '%v': %v", path, err)
+	}
+	return f, nil
+}
+
+// transcode runs the transcode t of the file at path, and then writes the result to the
+// cache.
+func (tfs *transcodeFileSystem) transcode(t *transcode, path, cachePath string, o TranscodeOptions) {
+	err := func() error {
+		f, err := tfs.FileSystem.Open(t.ctx, path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return tfs.t.Transcode(t.ctx, t, f, o)
+	}()
+
+	if err == nil && tfs.cache != nil && t.tmp != nil {
+		if err := writeFile(t.ctx, tfs.cache, cachePath, io.NewSectionReader(t.tmp, 0, t.size())); err != nil {
+			log.Printf("transcode: error writing '%v' to cache: %v", cachePath, err)
+		}
+	}
+
+	tfs.Lock()
+	if err != nil && tfs.transcodes[cachePath] == t {
+		delete(tfs.transcodes, cachePath) // try again next time
+	}
+	t.finish(err)
+	tfs.Unlock()
+
+	go func() {
+		// Remove the transcode once it's no longer being read (new requests will then
+		// use the cache).
+		t.closed()
+		tfs.Lock()
+		if tfs.transcodes[cachePath] == t {
+			delete(tfs.transcodes, cachePath)
+		}
+		tfs.Unlock()
+	}()
+}
+
+// transcode is a transcoded file which is written to a temporary file, so that it can be
+// read (by any number of readers) while it is being written.
+type transcode struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started time.Time
+
+	sync.Mutex
+	cond *sync.Cond
+
+	tmp     *os.File
+	n       int64 // number of bytes written to tmp
+	done    bool
+	err     error
+	readers int
+}
+
+func newTranscode() *transcode {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &transcode{
+		ctx:     ctx,
+		cancel:  cancel,
+		started: time.Now(),
+	}
+	t.cond = sync.NewCond(&t.Mutex)
+	return t
+}
+
+// Write implements io.Writer.  Writes are only made by the Transcoder.
+func (t *transcode) Write(b []byte) (int, error) {
+	if t.tmp == nil {
+		f, err := ioutil.TempFile("", "tchaik-transcode")
+		if err != nil {
+			return 0, err
+		}
+		t.Lock()
+		t.tmp = f
+		t.Unlock()
+	}
+
+	n, err := t.tmp.WriteAt(b, t.size())
+	t.Lock()
+	t.n += int64(n)
+	t.cond.Broadcast()
+	t.Unlock()
+	return n, err
+}
+
+// size returns the number of bytes written.
+func (t *transcode) size() int64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.n
+}
+
+// finish marks the transcode as complete, with the error err.
+func (t *transcode) finish(err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.done = true
+	t.err = err
+	t.cancel()
+	if t.readers == 0 {
+		t.remove()
+	}
+	t.cond.Broadcast()
+}
+
+// wait blocks until n bytes have been written (from the start of the file) or the transcode
+// has completed.  Returns the error from the transcode if there is less data.
+func (t *transcode) wait(n int64) error {
+	t.Lock()
+	defer t.Unlock()
+
+	for !t.done && t.n < n {
+		t.cond.Wait()
+	}
+	if t.n < n && t.err != nil {
+		return t.err
+	}
+	return nil
+}
+
+// open adds a reader of the transcode.  Returns false if the transcode can no longer be
+// read (it has been cancelled, or has finished and its temporary file has been removed).
+func (t *transcode) open() bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.done && t.readers == 0 || !t.done && t.ctx.Err() != nil {
+		return false
+	}
+	t.readers++
+	return true
+}
+
+// close removes a reader of the transcode.  When there are no readers left the transcode
+// is cancelled (if it hasn't finished) and its temporary file is removed.
+func (t *transcode) close() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.readers--
+	if t.readers > 0 {
+		return
+	}
+	if !t.done {
+		t.cancel()
+		return
+	}
+	t.remove()
+	t.cond.Broadcast()
+}
+
+// closed blocks until the transcode has finished and there are no readers.
+func (t *transcode) closed() {
+	t.Lock()
+	defer t.Unlock()
+
+	for !t.done || t.readers > 0 {
+		t.cond.Wait()
+	}
+}
+
+// remove removes the temporary file.  Assumes that the lock is held.
+func (t *transcode) remove() {
+	if t.tmp != nil {
+		t.tmp.Close()
+		os.Remove(t.tmp.Name())
+		t.tmp = nil
+	}
+}
+
+// StreamingFile is an http.File which can be read while it is being written (see
+// TranscodeFileSystem).  Until it is complete its size is the amount written so far, reads
+// block until more data is available, and seeking relative to the end blocks until the
+// file is complete.
+type StreamingFile interface {
+	http.File
+	io.ReaderAt
+
+	// Streaming returns true if the file is still being written.
+	Streaming() bool
+}
+
+// transcodeFile is a reader of a transcode, and implements StreamingFile.
+type transcodeFile struct {
+	t       *transcode
+	name    string
+	modTime time.Time
+
+	offset int64
+	closed bool
+}
+
+// Streaming implements StreamingFile.
+func (f *transcodeFile) Streaming() bool {
+	f.t.Lock()
+	defer f.t.Unlock()
+	return !f.t.done
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *transcodeFile) ReadAt(b []byte, off int64) (int, error) {
+	if err := f.t.wait(off + int64(len(b))); err != nil {
+		return 0, err
+	}
+
+	f.t.Lock()
+	defer f.t.Unlock()
+
+	n := int64(len(b))
+	if off+n > f.t.n {
+		n = f.t.n - off
+	}
+	if n <= 0 {
+		return 0, io.EOF
+	}
+	if f.closed || f.t.tmp == nil {
+		return 0, os.ErrInvalid
+	}
+	m, err := f.t.tmp.ReadAt(b[:n], off)
+	if err == nil && m < len(b) {
+		err = io.EOF
+	}
+	return m, err
+}
+
+// Read implements io.Reader.
+func (f *transcodeFile) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := f.t.wait(f.offset + 1); err != nil {
+		return 0, err
+	}
+	n, err := f.ReadAt(b[:f.available(len(b))], f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// available returns the number of bytes (up to n) which can be read from the current
+// offset without blocking.
+func (f *transcodeFile) available(n int) int {
+	f.t.Lock()
+	defer f.t.Unlock()
+
+	if x := f.t.n - f.offset; x < int64(n) {
+		if x <= 0 {
+			return n // at the end
+		}
+		return int(x)
+	}
+	return n
+}
+
+// Seek implements io.Seeker.
+func (f *transcodeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 0:
+	case 1:
+		offset += f.offset
+	case 2:
+		f.t.waitDone()
+		if f.t.err != nil {
+			return f.offset, f.t.err
+		}
+		offset += f.t.size()
+	default:
+		return f.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return f.offset, fmt.Errorf("invalid offset: %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// waitDone blocks until the transcode has finished.
+func (t *transcode) waitDone() {
+	t.Lock()
+	defer t.Unlock()
+
+	for !t.done {
+		t.cond.Wait()
+	}
+}
+
+// Stat implements http.File.
+func (f *transcodeFile) Stat() (os.FileInfo, error) {
+	return &fileInfo{
+		name:    f.name,
+		size:    f.t.size(),
+		modTime: f.modTime,
+	}, nil
+}
+
+// Readdir implements http.File.
+func (f *transcodeFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, nil
+}
+
+// Close implements io.Closer.
+func (f *transcodeFile) Close() error {
+	f.t.Lock()
+	closed := f.closed
+	f.closed = true
+	f.t.Unlock()
+
+	if !closed {
+		f.t.close()
+	}
+	return nil
+}
+
+// writeFile creates the file with the path in the RWFileSystem and writes the data read
+// from r to it.
+func writeFile(ctx context.Context, fs RWFileSystem, path string, r io.Reader) error {
+	w, err := fs.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err1 := w.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
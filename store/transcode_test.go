@@ -0,0 +1,211 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// upperTranscoder is a Transcoder which converts its input to upper case, and counts the
+// number of calls to Transcode.
+type upperTranscoder struct {
+	n int
+}
+
+func (u *upperTranscoder) Transcode(ctx context.Context, w io.Writer, r io.Reader, o TranscodeOptions) error {
+	u.n++
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytes.ToUpper(b))
+	return err
+}
+
+func readFile(t *testing.T, fs FileSystem, ctx context.Context, path string) (string, string) {
+	f, err := fs.Open(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error from Open: %v", err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	return stat.Name(), string(b)
+}
+
+func TestTranscodeFileSystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcode")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "track.flac"), []byte("audio"), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	src := NewFileSystem(http.Dir(dir), "test")
+	cache := Dir(filepath.Join(dir, "cache"))
+	tr := &upperTranscoder{}
+	fs := TranscodeFileSystem(src, tr, cache)
+
+	ctx := context.Background()
+	if _, data := readFile(t, fs, ctx, "/track.flac"); data != "audio" {
+		t.Errorf("Open() without options = %#v, expected: %#v", data, "audio")
+	}
+
+	ctx = NewTranscodeContext(ctx, TranscodeOptions{Format: Opus})
+	for i := 0; i < 2; i++ {
+		name, data := readFile(t, fs, ctx, "/track.flac")
+		if data != "AUDIO" {
+			t.Errorf("Open() = %#v, expected: %#v", data, "AUDIO")
+		}
+		if name != "track.flac.96k.opus" {
+			t.Errorf("Open() name = %#v, expected: %#v", name, "track.flac.96k.opus")
+		}
+	}
+	if tr.n != 1 {
+		t.Errorf("Transcode called %d times, expected transcoded file to be cached", tr.n)
+	}
+
+	_, err = fs.Open(NewTranscodeContext(ctx, TranscodeOptions{Format: "wav"}), "/track.flac")
+	if err == nil {
+		t.Errorf("expected error for invalid transcode format")
+	}
+
+	fs = TranscodeFileSystem(src, nil, cache)
+	if _, data := readFile(t, fs, ctx, "/track.flac"); data != "audio" {
+		t.Errorf("Open() without transcoder = %#v, expected: %#v", data, "audio")
+	}
+}
+
+// blockingTranscoder is a Transcoder which writes its input, and then blocks until
+// release is closed (or the context is cancelled).
+type blockingTranscoder struct {
+	n         int
+	release   chan struct{}
+	cancelled chan struct{}
+}
+
+func (b *blockingTranscoder) Transcode(ctx context.Context, w io.Writer, r io.Reader, o TranscodeOptions) error {
+	b.n++
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	select {
+	case <-b.release:
+		_, err := w.Write([]byte(" end"))
+		return err
+	case <-ctx.Done():
+		close(b.cancelled)
+		return ctx.Err()
+	}
+}
+
+func TestTranscodeFileSystemStreaming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcode")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "track.flac"), []byte("audio"), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	tr := &blockingTranscoder{
+		release:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+	fs := TranscodeFileSystem(NewFileSystem(http.Dir(dir), "test"), tr, nil)
+	ctx := NewTranscodeContext(context.Background(), TranscodeOptions{Format: MP3})
+
+	// Open returns as soon as data is available, and concurrent requests share the
+	// transcode.
+	var files []http.File
+	for i := 0; i < 2; i++ {
+		f, err := fs.Open(ctx, "/track.flac")
+		if err != nil {
+			t.Fatalf("unexpected error from Open: %v", err)
+		}
+		if sf, ok := f.(StreamingFile); !ok || !sf.Streaming() {
+			t.Fatalf("expected Open() to return a StreamingFile which is streaming")
+		}
+		files = append(files, f)
+	}
+
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(files[0], b); err != nil || string(b) != "audio" {
+		t.Errorf("ReadFull() = (%#v, %v), expected: (%#v, nil)", string(b), err, "audio")
+	}
+	close(tr.release)
+	data, err := ioutil.ReadAll(files[1])
+	if err != nil || string(data) != "audio end" {
+		t.Errorf("ReadAll() = (%#v, %v), expected: (%#v, nil)", string(data), err, "audio end")
+	}
+	for _, f := range files {
+		f.Close()
+	}
+	if tr.n != 1 {
+		t.Errorf("Transcode called %d times, expected: 1", tr.n)
+	}
+
+	// Closing all the files cancels the transcode.
+	tr.release = make(chan struct{})
+	f, err := fs.Open(ctx, "/track.flac")
+	if err != nil {
+		t.Fatalf("unexpected error from Open: %v", err)
+	}
+	f.Close()
+	select {
+	case <-tr.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected transcode to be cancelled when all files are closed")
+	}
+}
+
+func TestTranscodeOptionsFromQuery(t *testing.T) {
+	tests := []struct {
+		in  string
+		o   TranscodeOptions
+		ok  bool
+		err bool
+	}{
+		{"", TranscodeOptions{}, false, false},
+		{"format=opus", TranscodeOptions{Opus, 96}, true, false},
+		{"format=MP3&bitrate=128k", TranscodeOptions{MP3, 128}, true, false},
+		{"format=aac&bitrate=64", TranscodeOptions{AAC, 64}, true, false},
+		{"format=aac&bitrate=1000", TranscodeOptions{}, false, true},
+		{"format=aac&bitrate=fast", TranscodeOptions{}, false, true},
+		{"format=wav", TranscodeOptions{}, false, true},
+	}
+
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.in)
+		o, ok, err := TranscodeOptionsFromQuery(q)
+		if o != tt.o || ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("TranscodeOptionsFromQuery(%#v) = (%#v, %v, %v), expected: (%#v, %v, error: %v)", tt.in, o, ok, err, tt.o, tt.ok, tt.err)
+		}
+	}
+}